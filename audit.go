@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// File, in data directory, where audit events are appended (one JSON per line)
+const auditFile = "audit.log"
+
+// Number of events kept in memory to be queried from API
+const auditHistorySize = 200
+
+// Something worth to be reviewed later by an admin, i.e. a play blocked by parental rules
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+	Profile string    `json:"profile,omitempty"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target,omitempty"`
+	Media   string    `json:"media,omitempty"`
+	Reason  string    `json:"reason"`
+}
+
+// Append-only trail of events
+type AuditTrail struct {
+	file string
+
+	lock   sync.Mutex
+	recent []AuditEvent
+}
+
+// Open audit trail, recent events are reloaded from file
+func NewAuditTrail(file string) *AuditTrail {
+	a := &AuditTrail{file: file}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return a
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &event) == nil {
+			a.recent = append(a.recent, event)
+			if len(a.recent) > auditHistorySize {
+				a.recent = a.recent[1:]
+			}
+		}
+	}
+	return a
+}
+
+// Log event in file, and keep it in recent events
+func (a *AuditTrail) Record(event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	glog.Warning("[audit] ", event.User, " ", event.Action, " ", event.Media, ": ", event.Reason)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.recent = append(a.recent, event)
+	if len(a.recent) > auditHistorySize {
+		a.recent = a.recent[len(a.recent)-auditHistorySize:]
+	}
+
+	if err := a.append(event); err != nil {
+		glog.Error("Can't write audit event in ", a.file, ": ", err)
+	}
+}
+
+// Lock must be held
+func (a *AuditTrail) append(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Most recent events, newest first
+func (a *AuditTrail) Recent(limit int) []AuditEvent {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if limit <= 0 || limit > len(a.recent) {
+		limit = len(a.recent)
+	}
+	events := make([]AuditEvent, limit)
+	for i := 0; i < limit; i++ {
+		events[i] = a.recent[len(a.recent)-1-i]
+	}
+	return events
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// Delay before answering a failed login, to slow down password guessing
+var loginFailureDelay = time.Second
+
+// Login, accounts and API keys. Does nothing when authentication is disabled.
+func AuthController(r *mux.Router) error {
+	if !GetMmConfig().auth {
+		glog.Warning("Authentication is disabled: anyone on the network can use the API")
+		return nil
+	}
+	glog.V(1).Infoln("Registering Auth Controller")
+
+	var err error
+	if authenticator, err = NewAuthenticator(dataFile(usersFile)); err != nil {
+		return err
+	}
+	if err = ConfigureRoleRoots(GetMmConfig().roleRoots); err != nil {
+		return err
+	}
+
+	r.Methods("POST").Path("/api/auth/login").HandlerFunc(HandleLogin)
+	r.Methods("POST").Path("/api/auth/logout").HandlerFunc(HandleLogout)
+	r.Methods("GET").Path("/api/auth/me").HandlerFunc(HandleMe)
+
+	r.Methods("GET").Path("/api/auth/keys").HandlerFunc(HandleApiKeys)
+	r.Methods("POST").Path("/api/auth/keys").HandlerFunc(HandleCreateApiKey)
+	r.Methods("DELETE").Path("/api/auth/keys/{id}").HandlerFunc(HandleDeleteApiKey)
+
+	r.Methods("GET").Path("/api/users").HandlerFunc(restricted(PermissionManageUsers, HandleUsers))
+	r.Methods("PUT").Path("/api/users/{name}").HandlerFunc(HandlePutUser)
+	r.Methods("DELETE").Path("/api/users/{name}").HandlerFunc(restricted(PermissionManageUsers, HandleDeleteUser))
+
+	glog.Info("Auth controller loaded with users ", authenticator.Users())
+	return nil
+}
+
+// Wrap handler with authentication, when it's enabled
+func authMiddleware(handler http.Handler) http.Handler {
+	if authenticator == nil {
+		return handler
+	}
+	return authenticator.Middleware(handler)
+}
+
+type credentialsDto struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Role     string `json:"role,omitempty"`
+}
+
+type sessionDto struct {
+	User    string    `json:"user"`
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Open a session from JSON credentials. Token is set as cookie, and returned to be used as bearer token.
+func HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var credentials credentialsDto
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid credentials: " + err.Error()}, w)
+		return
+	}
+
+	token, expires, err := authenticator.Login(credentials.User, credentials.Password)
+	if err != nil {
+		glog.Warning("Failed login of '", credentials.User, "' from ", r.RemoteAddr)
+		time.Sleep(loginFailureDelay)
+		failureResponse(r, &UnauthenticatedError{err.Error()}, w)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	respondWithJSON(w, 200, sessionDto{User: credentials.User, Token: token, Expires: expires})
+}
+
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		authenticator.Logout(cookie.Value)
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 {
+		authenticator.Logout(auth[7:])
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	respondWithJSON(w, 204, nil)
+}
+
+func HandleMe(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, 200, currentPrincipal(r))
+}
+
+// Owner filter on API keys: admins manage all keys, others only their own
+func apiKeysOwner(r *http.Request) string {
+	if hasPermission(r, PermissionManageUsers) {
+		return ""
+	}
+	return currentPrincipal(r).User
+}
+
+func HandleApiKeys(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, 200, authenticator.ApiKeys(apiKeysOwner(r)))
+}
+
+type apiKeyDto struct {
+	ApiKey
+	// Only returned on creation
+	Key string `json:"key"`
+}
+
+// Create a read-only API key, owned by current user, from JSON {"name": "..."}
+func HandleCreateApiKey(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" {
+		failureResponse(r, &InvalidRequestError{"API key 'name' is required"}, w)
+		return
+	}
+
+	apiKey, key, err := authenticator.CreateApiKey(currentPrincipal(r).User, request.Name)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	respondWithJSON(w, 201, apiKeyDto{ApiKey: apiKey, Key: key})
+}
+
+func HandleDeleteApiKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	found, err := authenticator.RemoveApiKey(id, apiKeysOwner(r))
+	if err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown API key: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+func HandleUsers(w http.ResponseWriter, _ *http.Request) {
+	respondWithJSON(w, 200, authenticator.Accounts())
+}
+
+// Create user or change its password and role, from JSON {"password": "...", "role": "..."}
+// Users can change their own password, everything else requires to manage users.
+func HandlePutUser(w http.ResponseWriter, r *http.Request) {
+	var credentials credentialsDto
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid credentials: " + err.Error()}, w)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if !hasPermission(r, PermissionManageUsers) && (name != currentPrincipal(r).User || credentials.Role != "") {
+		failureResponse(r, &ForbiddenError{"permission '" + PermissionManageUsers + "' is required"}, w)
+		return
+	}
+
+	if err := authenticator.SetUser(name, credentials.Password, credentials.Role); err != nil {
+		failureResponse(r, err, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	found, err := authenticator.RemoveUser(name)
+	if err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown user: " + name}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
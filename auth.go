@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// File, in data directory, where accounts and API keys are persisted
+const usersFile = "users.json"
+
+// File, next to users file, where the password of the first admin account is written. Only its owner can read it.
+const adminPasswordFile = "admin-password"
+
+const (
+	sessionCookie   = "medima_session"
+	sessionDuration = 30 * 24 * time.Hour
+
+	apiKeyPrefix = "mk_"
+	apiKeyHeader = "X-Api-Key"
+	// last use of an API key is written to users file at most once per interval
+	apiKeyUsageSaveInterval = time.Hour
+)
+
+// PBKDF2 iterations for new password hashes (existing hashes keep their own count)
+var passwordIterations = 60000
+
+// Who is calling the API
+type Principal struct {
+	User string `json:"user,omitempty"`
+	Role string `json:"role"`
+	// Set when authenticated with an API key
+	ApiKey   string `json:"apiKey,omitempty"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+type principalKey struct{}
+
+// Principal of an authenticated request, nil when authentication is disabled
+func currentPrincipal(r *http.Request) *Principal {
+	if p, ok := r.Context().Value(principalKey{}).(*Principal); ok {
+		return p
+	}
+	return nil
+}
+
+// Name of the user calling the API, empty when authentication is disabled
+func requestUser(r *http.Request) string {
+	if principal := currentPrincipal(r); principal != nil {
+		return principal.User
+	}
+	return ""
+}
+
+// Account, as persisted
+type User struct {
+	Name         string    `json:"name"`
+	Role         string    `json:"role"`
+	PasswordHash string    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// Account DTO, built for REST API
+type UserDto struct {
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+}
+
+// Key for automation, only allowed to read (GET, HEAD)
+type ApiKey struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	Owner    string     `json:"owner"`
+	KeyHash  string     `json:"keyHash"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+// Content of users file
+type authData struct {
+	Users   []*User   `json:"users"`
+	ApiKeys []*ApiKey `json:"apiKeys"`
+	// Secret used to sign stream URLs given to DLNA renderers, which can't authenticate
+	StreamSecret string `json:"streamSecret"`
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Check credentials of API calls: session cookie, bearer token or API key
+type Authenticator struct {
+	file string
+
+	lock     sync.Mutex
+	data     authData
+	sessions map[string]session
+}
+
+var authenticator *Authenticator
+
+// Load accounts from file. When there is no account yet, an 'admin' one is created with a random password.
+func NewAuthenticator(file string) (*Authenticator, error) {
+	a := &Authenticator{file: file, sessions: make(map[string]session)}
+	if _, err := readJsonFile(file, &a.data); err != nil {
+		return nil, fmt.Errorf("can't load users from %s: %s", file, err.Error())
+	}
+
+	changed := false
+	if a.data.StreamSecret == "" {
+		a.data.StreamSecret = randomToken(32)
+		changed = true
+	}
+	for _, u := range a.data.Users {
+		if u.Role == "" {
+			// accounts created before roles had all rights
+			u.Role = RoleAdmin
+			changed = true
+		}
+	}
+	if len(a.data.Users) == 0 {
+		password := randomToken(8)
+		a.data.Users = append(a.data.Users, &User{Name: "admin", Role: RoleAdmin, PasswordHash: hashPassword(password), Created: time.Now()})
+		passwordFile := filepath.Join(filepath.Dir(file), adminPasswordFile)
+		if err := writeSecretFile(passwordFile, password+"\n"); err != nil {
+			return nil, fmt.Errorf("can't write admin password to %s: %s", passwordFile, err.Error())
+		}
+		glog.Warning("No user account yet: created user 'admin', its password is in ", passwordFile, ", please change it.")
+		changed = true
+	}
+
+	if changed {
+		if err := writeJsonFile(file, a.data); err != nil {
+			return nil, err
+		}
+	}
+
+	glog.V(1).Infoln("Loaded ", len(a.data.Users), " users and ", len(a.data.ApiKeys), " API keys from ", file)
+	return a, nil
+}
+
+// Paths which don't require authentication: health, login, UI static files, signed streams and DLNA from local network.
+// Anything else, including /metrics, requires it.
+func (a *Authenticator) isOpen(r *http.Request) bool {
+	path := r.URL.Path
+	read := r.Method == "GET" || r.Method == "HEAD"
+	switch {
+	case path == "/health" || path == "/api/auth/login":
+		return true
+	case strings.HasPrefix(path, STREAM_PREFIX+"/") && read:
+		pathId := strings.Trim(strings.TrimPrefix(path, STREAM_PREFIX), "/")
+		return a.validStreamSignature(pathId, streamViewer(r), r.URL.Query().Get("sig"))
+	case path == DLNA_PREFIX || strings.HasPrefix(path, DLNA_PREFIX+"/"):
+		// DLNA clients can't authenticate: they only see roots shared with guests
+		return isLocalNetwork(r.RemoteAddr)
+	case path == "/api" || strings.HasPrefix(path, "/api/") || path == "/metrics":
+		return false
+	}
+	// UI, served for any other path (HTML5 routing)
+	return read
+}
+
+// Private, link-local and loopback networks, where DLNA clients are
+var localNetworks = parseNetworks("127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16",
+	"::1/128", "fc00::/7", "fe80::/10")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// True when remote address (host:port) is in a local network
+func isLocalNetwork(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range localNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject unauthenticated API calls, and non-read calls made with an API key
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isOpen(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal := a.authenticate(r)
+		if principal == nil {
+			failureResponse(r, &UnauthenticatedError{"authentication required"}, w)
+			return
+		}
+		if principal.ReadOnly && r.Method != "GET" && r.Method != "HEAD" {
+			failureResponse(r, &ForbiddenError{"API key " + principal.ApiKey + " is read-only"}, w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// Find principal from request credentials, nil if there is none or they are invalid
+func (a *Authenticator) authenticate(r *http.Request) *Principal {
+	token := r.Header.Get(apiKeyHeader)
+	if token == "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+	}
+	if token == "" {
+		if cookie, err := r.Cookie(sessionCookie); err == nil && sameOrigin(r) {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		return a.authenticateApiKey(token)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s, ok := a.sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expires) {
+		delete(a.sessions, token)
+		return nil
+	}
+
+	// Role is read on each request: changes apply to opened sessions
+	user := a.user(s.user)
+	if user == nil {
+		return nil
+	}
+	return &Principal{User: user.Name, Role: user.Role}
+}
+
+// Browsers send session cookie with requests forged by other sites: only accept it from our own pages
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (a *Authenticator) authenticateApiKey(key string) *Principal {
+	hash := sha256Hex(key)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, k := range a.data.ApiKeys {
+		if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hash)) == 1 {
+			owner := a.user(k.Owner)
+			if owner == nil {
+				return nil
+			}
+
+			now := time.Now()
+			if k.LastUsed == nil || now.Sub(*k.LastUsed) >= apiKeyUsageSaveInterval {
+				k.LastUsed = &now
+				if err := writeJsonFile(a.file, a.data); err != nil {
+					glog.Warning("Can't save last use of API key ", k.Name, ": ", err)
+				}
+			}
+			return &Principal{User: owner.Name, Role: owner.Role, ApiKey: k.Name, ReadOnly: true}
+		}
+	}
+	return nil
+}
+
+// Check password and open a session. Return session token.
+// Password is hashed without lock: it's slow on purpose, and other requests must still be authenticated meanwhile.
+func (a *Authenticator) Login(name string, password string) (string, time.Time, error) {
+	a.lock.Lock()
+	hash := ""
+	if user := a.user(name); user != nil {
+		hash = user.PasswordHash
+	}
+	a.lock.Unlock()
+
+	if hash == "" || !checkPassword(password, hash) {
+		return "", time.Time{}, fmt.Errorf("invalid user or password")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// password may have changed, or user been removed, meanwhile
+	user := a.user(name)
+	if user == nil || user.PasswordHash != hash {
+		return "", time.Time{}, fmt.Errorf("invalid user or password")
+	}
+
+	token := randomToken(32)
+	expires := time.Now().Add(sessionDuration)
+	a.sessions[token] = session{user: user.Name, expires: expires}
+
+	glog.Info("User ", user.Name, " logged in")
+	return token, expires, nil
+}
+
+func (a *Authenticator) Logout(token string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	delete(a.sessions, token)
+}
+
+// User names
+func (a *Authenticator) Users() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	names := make([]string, len(a.data.Users))
+	for i, u := range a.data.Users {
+		names[i] = u.Name
+	}
+	return names
+}
+
+// Accounts, without their credentials
+func (a *Authenticator) Accounts() []UserDto {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	users := make([]UserDto, len(a.data.Users))
+	for i, u := range a.data.Users {
+		users[i] = UserDto{Name: u.Name, Role: u.Role, Created: u.Created}
+	}
+	return users
+}
+
+// Create a user, or change its password and/or role (kept when empty).
+// New users are guests by default. Sessions of an existing user are closed when password changes.
+func (a *Authenticator) SetUser(name string, password string, role string) error {
+	if name == "" {
+		return &InvalidCredentialsError{"user name is required"}
+	}
+	if password != "" && len(password) < 8 {
+		return &InvalidCredentialsError{"password must have at least 8 characters"}
+	}
+	if role != "" && !isRole(role) {
+		return &InvalidCredentialsError{"unknown role: " + role}
+	}
+
+	// hashed before taking the lock, it's slow on purpose
+	hash := ""
+	if password != "" {
+		hash = hashPassword(password)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	user := a.user(name)
+	if user == nil {
+		if password == "" {
+			return &InvalidCredentialsError{"password is required to create a user"}
+		}
+		user = &User{Name: name, Role: RoleGuest, Created: time.Now()}
+		a.data.Users = append(a.data.Users, user)
+	} else if role != "" && role != RoleAdmin && user.Role == RoleAdmin && a.admins() == 1 {
+		return &ConflictError{"last admin must keep its role"}
+	}
+
+	if hash != "" {
+		user.PasswordHash = hash
+		a.closeSessions(name)
+		if name == "admin" {
+			// generated password isn't valid anymore
+			os.Remove(filepath.Join(filepath.Dir(a.file), adminPasswordFile))
+		}
+	}
+	if role != "" {
+		user.Role = role
+	}
+
+	return writeJsonFile(a.file, a.data)
+}
+
+// Number of admin accounts. Lock must be held.
+func (a *Authenticator) admins() int {
+	count := 0
+	for _, u := range a.data.Users {
+		if u.Role == RoleAdmin {
+			count++
+		}
+	}
+	return count
+}
+
+// Remove a user, its sessions and its API keys. Return false if user doesn't exist.
+func (a *Authenticator) RemoveUser(name string) (bool, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i, u := range a.data.Users {
+		if u.Name == name {
+			if u.Role == RoleAdmin && a.admins() == 1 {
+				return true, &ConflictError{"last admin can't be removed"}
+			}
+
+			a.data.Users = append(a.data.Users[:i], a.data.Users[i+1:]...)
+			a.closeSessions(name)
+
+			keys := a.data.ApiKeys[:0]
+			for _, k := range a.data.ApiKeys {
+				if k.Owner != name {
+					keys = append(keys, k)
+				}
+			}
+			a.data.ApiKeys = keys
+
+			return true, writeJsonFile(a.file, a.data)
+		}
+	}
+	return false, nil
+}
+
+// Create a read-only API key. Key itself is only returned here, only its hash is kept.
+func (a *Authenticator) CreateApiKey(owner string, name string) (ApiKey, string, error) {
+	key := apiKeyPrefix + randomToken(24)
+	apiKey := &ApiKey{Id: randomToken(4), Name: name, Owner: owner, KeyHash: sha256Hex(key), Created: time.Now()}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.data.ApiKeys = append(a.data.ApiKeys, apiKey)
+	return *apiKey, key, writeJsonFile(a.file, a.data)
+}
+
+// API keys of owner, or all keys when owner is empty
+func (a *Authenticator) ApiKeys(owner string) []ApiKey {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	keys := make([]ApiKey, 0, len(a.data.ApiKeys))
+	for _, k := range a.data.ApiKeys {
+		if owner == "" || k.Owner == owner {
+			keys = append(keys, *k)
+		}
+	}
+	return keys
+}
+
+// Revoke an API key of owner (any owner when empty), return false if it doesn't exist
+func (a *Authenticator) RemoveApiKey(id string, owner string) (bool, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i, k := range a.data.ApiKeys {
+		if k.Id == id && (owner == "" || k.Owner == owner) {
+			a.data.ApiKeys = append(a.data.ApiKeys[:i], a.data.ApiKeys[i+1:]...)
+			return true, writeJsonFile(a.file, a.data)
+		}
+	}
+	return false, nil
+}
+
+// Signature appended to stream URLs, so renderers can play media without credentials. It covers the viewer the URL
+// is given to, whose parental rules apply to the stream.
+func (a *Authenticator) streamSignature(pathId string, viewer parentalViewer) string {
+	mac := hmac.New(sha256.New, []byte(a.data.StreamSecret))
+	mac.Write([]byte(pathId + "\n" + viewer.User + "\n" + viewer.Profile))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func (a *Authenticator) validStreamSignature(pathId string, viewer parentalViewer, signature string) bool {
+	return signature != "" && hmac.Equal([]byte(signature), []byte(a.streamSignature(pathId, viewer)))
+}
+
+// Lock must be held
+func (a *Authenticator) user(name string) *User {
+	for _, u := range a.data.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// Lock must be held
+func (a *Authenticator) closeSessions(name string) {
+	for token, s := range a.sessions {
+		if s.user == name {
+			delete(a.sessions, token)
+		}
+	}
+}
+
+// Returned when requested account change is refused
+type InvalidCredentialsError struct {
+	Reason string
+}
+
+func (e *InvalidCredentialsError) Error() string {
+	return e.Reason
+}
+
+// Hash password with PBKDF2-SHA256 and a random salt: pbkdf2-sha256$<iterations>$<salt>$<hash>
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	hash := pbkdf2Sha256([]byte(password), salt, passwordIterations, sha256.Size)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func checkPassword(password string, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iterations, err1 := strconv.Atoi(parts[1])
+	salt, err2 := base64.RawStdEncoding.DecodeString(parts[2])
+	expected, err3 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || iterations <= 0 {
+		return false
+	}
+
+	hash := pbkdf2Sha256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(hash, expected) == 1
+}
+
+// PBKDF2 (RFC 2898) with HMAC-SHA256
+func pbkdf2Sha256(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Random hex string from n random bytes
+func randomToken(n int) string {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		glog.Fatal("No random source available: ", err)
+	}
+	return hex.EncodeToString(bytes)
+}
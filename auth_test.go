@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pbkdf2Sha256(t *testing.T) {
+	// RFC 7914 test vectors
+	assert.Equal(t, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		hex.EncodeToString(pbkdf2Sha256([]byte("password"), []byte("salt"), 1, 32)))
+	assert.Equal(t, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+		hex.EncodeToString(pbkdf2Sha256([]byte("password"), []byte("salt"), 4096, 32)))
+}
+
+func Test_hashPassword(t *testing.T) {
+	hash := hashPassword("correct horse")
+
+	assert.True(t, strings.HasPrefix(hash, "pbkdf2-sha256$"))
+	assert.NotContains(t, hash, "correct horse")
+	assert.NotEqual(t, hash, hashPassword("correct horse"), "salt must be random")
+
+	assert.True(t, checkPassword("correct horse", hash))
+	assert.False(t, checkPassword("battery staple", hash))
+	assert.False(t, checkPassword("correct horse", "plain"))
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-users")
+	defer os.RemoveAll(dir)
+
+	a, err := NewAuthenticator(filepath.Join(dir, "users.json"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"admin"}, a.Users(), "an admin account is created on first start")
+	if info, err := os.Stat(filepath.Join(dir, adminPasswordFile)); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		password, _ := ioutil.ReadFile(filepath.Join(dir, adminPasswordFile))
+		_, _, err = a.Login("admin", strings.TrimSpace(string(password)))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, a.SetUser("tom", "my secret password", RoleAdult))
+
+	authenticator = a
+	defer func() { authenticator = nil }()
+	loginFailureDelay = 0
+
+	r := mux.NewRouter()
+	r.Methods("POST").Path("/api/auth/login").HandlerFunc(HandleLogin)
+	r.Methods("GET").Path("/api/auth/me").HandlerFunc(HandleMe)
+	r.HandleFunc("/health", healthCheck)
+	r.PathPrefix("/api/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, 200, currentPrincipal(r))
+	})
+	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("<html></html>"))
+	})
+	handler := authMiddleware(r)
+
+	call := func(method string, url string, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("health and UI are open", func(t *testing.T) {
+		// health depends on roots and players left by other tests
+		previousRoots, previousTargets := roots, playerTargets
+		roots, playerTargets = map[string]Path{"data": {Root: "data", localPath: dir}}, nil
+		defer func() { roots, playerTargets = previousRoots, previousTargets }()
+
+		assert.Equal(t, 200, call("GET", "/health", "").Code)
+		assert.Equal(t, 200, call("GET", "/browser/data", "").Code)
+	})
+
+	t.Run("API requires authentication", func(t *testing.T) {
+		w := call("POST", "/api/player/stop", "")
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		assert.Equal(t, 401, call("GET", "/api", "").Code)
+		assert.Equal(t, 401, call("GET", "/metrics", "").Code)
+		assert.Equal(t, 401, call("POST", "/browser/data", "").Code)
+	})
+
+	t.Run("DLNA is open to local network only", func(t *testing.T) {
+		callFrom := func(remoteAddr string, method string, url string) int {
+			req := httptest.NewRequest(method, url, nil)
+			req.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, 200, callFrom("192.168.1.20:51234", "POST", DLNA_PREFIX+"/control/ContentDirectory"))
+		assert.Equal(t, 200, callFrom("[fe80::1]:51234", "GET", DLNA_PREFIX+"/device.xml"))
+		assert.Equal(t, 401, callFrom("203.0.113.7:51234", "GET", DLNA_PREFIX+"/device.xml"))
+	})
+
+	t.Run("login fails with wrong password", func(t *testing.T) {
+		assert.Equal(t, 401, call("POST", "/api/auth/login", `{"user": "tom", "password": "guess"}`).Code)
+	})
+
+	w := call("POST", "/api/auth/login", `{"user": "tom", "password": "my secret password"}`)
+	assert.Equal(t, 200, w.Code)
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, sessionCookie+"=")
+	assert.Contains(t, cookie, "HttpOnly")
+	token := strings.TrimPrefix(strings.Split(cookie, ";")[0], sessionCookie+"=")
+
+	t.Run("session cookie and bearer token are accepted", func(t *testing.T) {
+		assert.Equal(t, 200, call("POST", "/api/player/stop", "", "Cookie", sessionCookie+"="+token).Code)
+		assert.Equal(t, 200, call("POST", "/api/player/stop", "", "Authorization", "Bearer "+token).Code)
+		assert.Contains(t, call("GET", "/api/auth/me", "", "Authorization", "Bearer "+token).Body.String(), `"user":"tom"`)
+	})
+
+	t.Run("session cookie is refused from other sites", func(t *testing.T) {
+		w := call("POST", "/api/player/stop", "", "Cookie", sessionCookie+"="+token, "Origin", "http://evil.example.com")
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("API keys are read-only", func(t *testing.T) {
+		_, key, err := a.CreateApiKey("tom", "home automation")
+		assert.NoError(t, err)
+
+		assert.Equal(t, 200, call("GET", "/api/player/status", "", apiKeyHeader, key).Code)
+		assert.Equal(t, 200, call("GET", "/api/player/status", "", "Authorization", "Bearer "+key).Code)
+		assert.Equal(t, 403, call("POST", "/api/player/stop", "", apiKeyHeader, key).Code)
+		assert.Equal(t, 401, call("GET", "/api/player/status", "", apiKeyHeader, key+"0").Code)
+	})
+
+	t.Run("stream URLs are signed", func(t *testing.T) {
+		url := streamUrl("", Path{"", "data", "", "Films/movie.mp4"}, parentalViewer{User: "kid"})
+		assert.Contains(t, url, "sig=")
+
+		assert.Equal(t, 200, call("GET", url, "").Code)
+		assert.Equal(t, 401, call("GET", strings.Replace(url, "user=kid", "user=tom", 1), "").Code, "signature covers viewer")
+		assert.Equal(t, 401, call("GET", "/api/stream/data/Films/other.mp4?sig="+a.streamSignature("data/Films/movie.mp4", parentalViewer{}), "").Code)
+	})
+
+	t.Run("changing password closes sessions", func(t *testing.T) {
+		assert.NoError(t, a.SetUser("tom", "another secret password", ""))
+		assert.Equal(t, 401, call("POST", "/api/player/stop", "", "Authorization", "Bearer "+token).Code)
+	})
+
+	t.Run("generated admin password is removed once changed", func(t *testing.T) {
+		assert.NoError(t, a.SetUser("admin", "admin secret password", ""))
+		_, err := os.Stat(filepath.Join(dir, adminPasswordFile))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("accounts are persisted hashed", func(t *testing.T) {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "users.json"))
+		assert.NotContains(t, string(content), "another secret password")
+
+		reloaded, err := NewAuthenticator(filepath.Join(dir, "users.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"admin", "tom"}, reloaded.Users())
+		if assert.Len(t, reloaded.ApiKeys(""), 1) {
+			assert.NotNil(t, reloaded.ApiKeys("")[0].LastUsed, "last use of API key is saved")
+		}
+
+		_, _, err = reloaded.Login("tom", "another secret password")
+		assert.NoError(t, err)
+	})
+}
@@ -7,6 +7,8 @@ import (
 
 	"strings"
 	"encoding/json"
+	"fmt"
+	"time"
 
 )
 
@@ -15,10 +17,15 @@ const BROWSER_PREFIX = "/api/browser"
 func BrowserController(r *mux.Router) error {
 	glog.V(1).Infoln("Registering Browser Controller")
 
+	collation = newCollator(GetMmConfig().locale)
+	symlinkPolicy = GetMmConfig().symlinks
 	err := ConfigureRoots()
 	if err != nil {
 		return err
 	}
+	if err = ConfigureIgnoreRules(GetMmConfig().ignore); err != nil {
+		return err
+	}
 
 	r.PathPrefix(BROWSER_PREFIX).HandlerFunc(ShowMedia)
 
@@ -28,19 +35,75 @@ func BrowserController(r *mux.Router) error {
 
 // Handle browsing request
 func ShowMedia(w http.ResponseWriter, r *http.Request) {
-	path, err1 := parsePath(r)
-	if err1 != nil {
-		failureResponse(r, err1, w)
+	if fileId := browserFileId(r); strings.HasPrefix(fileId, virtualPrefix) {
+		ShowVirtualDir(w, r, fileId)
+		return
+	}
+
+	page, err := parsePage(r)
+	var less fileLess
+	if err == nil {
+		less, err = parseFileOrder(r, sortName, sortName, sortNatural, sortModified, sortSize)
+	}
+	var fields fileFields
+	if err == nil {
+		fields, err = parseFileFields(r)
+	}
+	if err != nil {
+		failureResponse(r, &InvalidRequestError{err.Error()}, w)
+		return
+	}
+
+	path, err := parsePath(r)
+	if err == nil {
+		err = checkRootAccess(r, path)
 	}
 
-	file, err2 := path.ToFile(false)
-	if err2 != nil {
-		failureResponse(r, err2, w)
+	// Ignored files are only listed to admins asking for them
+	hidden := showHidden(r)
+	if err == nil && !hidden && isIgnoredPath(path) {
+		err = &NotFoundError{path.PathId() + " doesn't exist"}
 	}
 
-	if err1 == nil && err2 == nil {
-		respondWithJSON(w, 200, NewFileDto(file))
+	var file File
+	if err == nil {
+		file, err = path.ToVisibleFile(hidden, visibleRoots(r))
+	}
+	if err == nil {
+		err = parental.Visible(requestViewer(r), file)
+	}
+	if err != nil {
+		failureResponse(r, err, w)
+		return
 	}
+
+	if dir, ok := file.(*Dir); ok {
+		if hidden && !path.IsIndex() {
+			dir.listChildren(nil)
+		}
+		parental.FilterChildren(requestViewer(r), dir)
+		dir.sortChildren(less)
+	}
+
+	// details are only read for children in requested page
+	dto := newFileDto(file, fields)
+	if dir, ok := file.(*Dir); ok {
+		var virtual []FileDto
+		if path.IsIndex() {
+			virtual = virtualDirs()
+		}
+
+		count := len(dir.Children)
+		start, end := paginateBounds(w, page, count+len(virtual))
+		for i := start; i < end; i++ {
+			if i < count {
+				dto.Children = append(dto.Children, NewFileDtoWith(dir.Children[i], fields))
+			} else {
+				dto.Children = append(dto.Children, virtual[i-count])
+			}
+		}
+	}
+	respondWithJSON(w, 200, dto)
 }
 
 type FileDto struct {
@@ -50,14 +113,44 @@ type FileDto struct {
 	Name     string `json:"name"`
 	RealPath string `json:"realPath"`
 
+	// Details, as requested with 'fields'
+	Modified *time.Time `json:"modified,omitempty"`
+	Created  *time.Time `json:"created,omitempty"`
+
 	// Specific for directories
-	Children []FileDto `json:"children,omitempty"`
+	Children   []FileDto `json:"children,omitempty"`
+	ChildCount *int      `json:"childCount,omitempty"`
+	TotalSize  *int64    `json:"totalSize,omitempty"`
 
 	// Specific to Media
-	Playable bool `json:"playable"`
+	Playable bool   `json:"playable"`
+	Size     *int64 `json:"size,omitempty"`
+	Mime     string `json:"mime,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+
+	// Specific to search results: relevance from 0 to 1
+	Score float64 `json:"score,omitempty"`
 }
 
+// DTO of file, and of its children, with summary details
 func NewFileDto(file File) FileDto {
+	return NewFileDtoWith(file, summaryFields)
+}
+
+// DTO of file, and of its children, with requested details
+func NewFileDtoWith(file File, fields fileFields) FileDto {
+	dto := newFileDto(file, fields)
+	if dir, ok := file.(*Dir); ok {
+		dto.Children = make([]FileDto, len(dir.Children))
+		for i, c := range dir.Children {
+			dto.Children[i] = NewFileDtoWith(c, fields)
+		}
+	}
+	return dto
+}
+
+// DTO of file, without children
+func newFileDto(file File, fields fileFields) FileDto {
 	dto := FileDto{
 		Type:     file.Type(),
 		PathId:   file.Path().PathId(),
@@ -65,13 +158,7 @@ func NewFileDto(file File) FileDto {
 		Name:     file.Path().DisplayName(),
 		RealPath: file.Path().RealPath(),
 	}
-
-	if dir, ok := file.(*Dir); ok {
-		dto.Children = make([]FileDto, len(dir.Children))
-		for i, c := range dir.Children {
-			dto.Children[i] = NewFileDto(c)
-		}
-	}
+	fields.fill(&dto, file)
 
 	if media, ok := file.(*Media); ok {
 		dto.Playable = IsPlayable(media)
@@ -80,16 +167,14 @@ func NewFileDto(file File) FileDto {
 	return dto
 }
 
-func failureResponse(r *http.Request, err error, w http.ResponseWriter) {
-	glog.Warning("Fail to browse '", r.URL.Path, "': ", err.Error())
-	respondWithJSON(w, 500, map[string]string{"error": err.Error()})
-}
-
 // Parse file public path and resolve its internal path
 func parsePath(request *http.Request) (Path, error) {
-	fileId := strings.Trim(strings.TrimPrefix(request.URL.Path, BROWSER_PREFIX), "/")
+	return NewPathFromId(browserFileId(request))
+}
 
-	return NewPathFromId(fileId)
+// Public path of browsed file
+func browserFileId(request *http.Request) string {
+	return strings.Trim(strings.TrimPrefix(request.URL.Path, BROWSER_PREFIX), "/")
 }
 
 // Create new Path from its fileId
@@ -103,7 +188,9 @@ func NewPathFromId(fileId string) (Path, error) {
 		lastSlash := strings.LastIndex(fileId, "/")
 
 		root = fileId[:firstSlash]
-		if firstSlash < lastSlash {
+		if firstSlash+1 == lastSlash {
+			return Path{}, &InvalidIdError{fmt.Sprintf("invalid path '%s'", fileId)}
+		} else if firstSlash < lastSlash {
 			relativePath = fileId[firstSlash+1 : lastSlash]
 		}
 		name = fileId[lastSlash+1:]
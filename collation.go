@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Locale used to sort names when none is configured
+const defaultLocale = "fr"
+
+// Rules of a language to sort names
+type collationLocale struct {
+	// Leading articles ignored when sorting: "The Matrix" is sorted as "Matrix"
+	articles []string
+	// Letters of the alphabet sorted after a base letter, instead of being seen as accented letters
+	letters map[rune]tailoredLetter
+}
+
+// Letter sorted after its base letter, and after other tailored letters of the same base with a lower rank
+type tailoredLetter struct {
+	base rune
+	rank int
+}
+
+var collationLocales = map[string]collationLocale{
+	"en": {articles: []string{"the", "a", "an"}},
+	"fr": {articles: []string{"le", "la", "les", "l'", "the"}},
+	"de": {articles: []string{"der", "die", "das", "the"}},
+	"es": {articles: []string{"el", "la", "los", "las", "the"}, letters: map[rune]tailoredLetter{'ñ': {'n', 1}}},
+	"it": {articles: []string{"il", "lo", "la", "i", "gli", "le", "l'", "the"}},
+	"sv": {articles: []string{"the"}, letters: map[rune]tailoredLetter{'å': {'z', 1}, 'ä': {'z', 2}, 'ö': {'z', 3}}},
+}
+
+// Tailored letters are sorted after their base letter with a rune outside of any alphabet
+const tailoredRunes = unicode.MaxRune - 16
+
+// Compare names as a library would: numbers by value, accents and case only when names are otherwise equal, and
+// ignoring leading articles and media extensions
+type collator struct {
+	locale   string
+	articles []string
+	letters  map[rune][]rune
+}
+
+// Collation of browsed directories, search results and collections
+var collation = newCollator(defaultLocale)
+
+func newCollator(locale string) *collator {
+	rules := collationLocales[locale]
+	c := &collator{locale: locale, articles: rules.articles, letters: make(map[rune][]rune)}
+	for letter, tailored := range rules.letters {
+		c.letters[letter] = []rune{tailored.base, tailoredRunes + rune(tailored.rank)}
+	}
+	return c
+}
+
+// Check locale is supported
+func validLocale(locale string) error {
+	if _, ok := collationLocales[locale]; !ok {
+		var supported []string
+		for name := range collationLocales {
+			supported = append(supported, name)
+		}
+		sort.Strings(supported)
+		return fmt.Errorf("unsupported locale '%s', expected one of %s", locale, strings.Join(supported, ", "))
+	}
+	return nil
+}
+
+// Negative when a is before b, positive when after, 0 when names are the same
+func (c *collator) compare(a string, b string) int {
+	if r := naturalCompare(c.primary(a), c.primary(b)); r != 0 {
+		return r
+	}
+	// accented letters after plain ones, then upper case before lower case
+	if r := naturalCompare(strings.ToLower(a), strings.ToLower(b)); r != 0 {
+		return r
+	}
+	return strings.Compare(a, b)
+}
+
+// Lower case name without article, accents nor media extension
+func (c *collator) primary(name string) string {
+	key := strings.ToLower(name)
+	if path := (Path{Name: key}); mediaMimeType(path.Ext()) != "" {
+		key = strings.TrimSuffix(key, "."+path.Ext())
+	}
+	key = c.withoutArticle(key)
+
+	primary := make([]rune, 0, len(key))
+	for _, r := range key {
+		if tailored, ok := c.letters[r]; ok {
+			primary = append(primary, tailored...)
+		} else if unicode.Is(unicode.Mn, r) {
+			continue
+		} else if ascii, ok := foldedLetters[r]; ok {
+			primary = append(primary, []rune(ascii)...)
+		} else {
+			primary = append(primary, r)
+		}
+	}
+	return string(primary)
+}
+
+// Remove leading article, followed by a separator or elided ("l'odyssee")
+func (c *collator) withoutArticle(name string) string {
+	for _, article := range c.articles {
+		if !strings.HasPrefix(name, article) || len(name) == len(article) {
+			continue
+		}
+
+		rest := name[len(article):]
+		if strings.HasSuffix(article, "'") {
+			return rest
+		}
+		if separator := rest[0]; separator == ' ' || separator == '.' || separator == '_' || separator == '-' {
+			if trimmed := strings.TrimLeft(rest, " ._-"); trimmed != "" {
+				return trimmed
+			}
+		}
+	}
+	return name
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortedNames(c *collator, names ...string) []string {
+	sorted := append([]string{}, names...)
+	sort.Slice(sorted, func(i, j int) bool { return c.compare(sorted[i], sorted[j]) < 0 })
+	return sorted
+}
+
+func Test_collator_compare(t *testing.T) {
+	fr := newCollator("fr")
+
+	assert.Equal(t, []string{"Episode 1.mkv", "Episode 2.mkv", "Episode 10.mkv"},
+		sortedNames(fr, "Episode 10.mkv", "Episode 2.mkv", "Episode 1.mkv"), "numbers by value")
+	assert.Equal(t, []string{"Astérix", "Été", "Zorro"},
+		sortedNames(fr, "Zorro", "Été", "Astérix"), "accented letters aren't after Z")
+	assert.Equal(t, []string{"cote", "coté", "côte", "côté"},
+		sortedNames(fr, "côté", "coté", "côte", "cote"), "accents only count when names are otherwise equal")
+	assert.Equal(t, []string{"Avatar", "The Matrix", "Les Misérables", "L'Odyssée", "Zodiac"},
+		sortedNames(fr, "The Matrix", "Zodiac", "L'Odyssée", "Les Misérables", "Avatar"), "articles are ignored")
+	assert.Equal(t, []string{"Cars.mp4", "Cars 2.mp4", "Cars 3.mp4"},
+		sortedNames(fr, "Cars 3.mp4", "Cars.mp4", "Cars 2.mp4"), "extension is ignored")
+	assert.Equal(t, []string{"Le", "Les"}, sortedNames(fr, "Les", "Le"), "a name isn't only an article")
+
+	assert.Equal(t, 0, fr.compare("Cars.mp4", "Cars.mp4"))
+	assert.True(t, fr.compare("Cars.mp4", "cars.mp4") < 0)
+}
+
+func Test_collator_locales(t *testing.T) {
+	assert.Equal(t, []string{"Öland", "Zebra"}, sortedNames(newCollator("fr"), "Zebra", "Öland"))
+	assert.Equal(t, []string{"Zebra", "Åsa", "Älg", "Öland"}, sortedNames(newCollator("sv"), "Öland", "Älg", "Zebra", "Åsa"))
+	assert.Equal(t, []string{"Nube", "Nuñez", "Oso"}, sortedNames(newCollator("es"), "Oso", "Nuñez", "Nube"))
+	assert.Equal(t, []string{"Piano", "A Quiet Place"}, sortedNames(newCollator("en"), "A Quiet Place", "Piano"))
+	assert.Equal(t, []string{"A Quiet Place", "Piano"}, sortedNames(newCollator("fr"), "A Quiet Place", "Piano"))
+
+	assert.NoError(t, validLocale("fr"))
+	assert.EqualError(t, validLocale("xx"), "unsupported locale 'xx', expected one of de, en, es, fr, it, sv")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// Virtual directories, browsable under BROWSER_PREFIX
+const (
+	virtualPrefix    = "@"
+	collectionsDirId = "@collections"
+	favoritesDirId   = "@favorites"
+)
+
+// Custom collections of medias, and favorites of active profile, browsable as directories.
+// Must be registered after ProfilesController.
+func CollectionsController(r *mux.Router) error {
+	glog.V(1).Infoln("Registering Collections Controller")
+
+	var err error
+	if collections, err = NewCollections(dataFile(collectionsFile)); err != nil {
+		return err
+	}
+
+	r.Methods("GET").Path("/api/collections").HandlerFunc(HandleCollections)
+	r.Methods("POST").Path("/api/collections").HandlerFunc(restricted(PermissionPlay, HandlePutCollection))
+	r.Methods("GET").Path("/api/collections/{id}").HandlerFunc(HandleCollection)
+	r.Methods("PUT").Path("/api/collections/{id}").HandlerFunc(restricted(PermissionPlay, HandlePutCollection))
+	r.Methods("DELETE").Path("/api/collections/{id}").HandlerFunc(restricted(PermissionPlay, HandleDeleteCollection))
+	r.Methods("PUT", "DELETE").Path("/api/collections/{id}/items").Queries("media", "").HandlerFunc(restricted(PermissionPlay, HandleCollectionItem))
+
+	glog.Info("Collections controller loaded")
+	return nil
+}
+
+func HandleCollections(w http.ResponseWriter, _ *http.Request) {
+	respondWithJSON(w, 200, collections.List())
+}
+
+func HandleCollection(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if collection, ok := collections.Get(id); ok {
+		respondWithJSON(w, 200, visibleItems(r, collection))
+	} else {
+		failureResponse(r, &NotFoundError{"unknown collection: " + id}, w)
+	}
+}
+
+// Collection without items of roots the caller can't see, nor those hidden by parental rules
+func visibleItems(r *http.Request, collection Collection) Collection {
+	visible := visibleRoots(r)
+	viewer := requestViewer(r)
+
+	items := make([]CollectionItem, 0, len(collection.Items))
+	for _, item := range collection.Items {
+		path, err := NewPathFromId(item.PathId)
+		if err != nil || (visible != nil && !visible(path.Root)) {
+			continue
+		}
+
+		var file File = NewMedia(path)
+		if item.Dir {
+			file = NewDir(path)
+		}
+		if parental.Visible(viewer, file) == nil {
+			items = append(items, item)
+		}
+	}
+	collection.Items = items
+	return collection
+}
+
+// Create (POST) or replace (PUT) a collection from JSON body: {"name": "...", "items": ["root/path", ...]}
+func HandlePutCollection(w http.ResponseWriter, r *http.Request) {
+	var dto CollectionDto
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid collection: " + err.Error()}, w)
+		return
+	}
+	for _, pathId := range dto.Items {
+		if err := checkPathIdAccess(r, pathId); err != nil {
+			failureResponse(r, err, w)
+			return
+		}
+	}
+
+	id := mux.Vars(r)["id"]
+	if !collections.Exists(id) && r.Method == "PUT" {
+		failureResponse(r, &NotFoundError{"unknown collection: " + id}, w)
+		return
+	}
+
+	collection, err := collections.Put(id, dto)
+	if err != nil {
+		failureResponse(r, err, w)
+	} else if r.Method == "POST" {
+		respondWithJSON(w, 201, collection)
+	} else {
+		respondWithJSON(w, 200, collection)
+	}
+}
+
+func HandleDeleteCollection(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if found, err := collections.Remove(id); err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown collection: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+// PUT adds media to collection, DELETE removes it
+func HandleCollectionItem(w http.ResponseWriter, r *http.Request) {
+	pathId := r.URL.Query().Get("media")
+	if err := checkPathIdAccess(r, pathId); err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	found, err := collections.SetItem(id, pathId, r.Method == "PUT")
+	if err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown collection: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+func checkPathIdAccess(r *http.Request, pathId string) error {
+	path, err := NewPathFromId(pathId)
+	if err == nil {
+		err = checkRootAccess(r, path)
+	}
+	return err
+}
+
+// Virtual directories listed in browser index, none when collections aren't enabled
+func virtualDirs() []FileDto {
+	if collections == nil {
+		return nil
+	}
+	return []FileDto{virtualDirDto(collectionsDirId, "Collections"), virtualDirDto(favoritesDirId, "Favorites")}
+}
+
+// Browse a virtual directory: '@collections', '@collections/{id}' or '@favorites'
+func ShowVirtualDir(w http.ResponseWriter, r *http.Request, pathId string) {
+	var dir FileDto
+
+	switch {
+	case pathId == collectionsDirId && collections != nil:
+		dir = virtualDirDto(collectionsDirId, "Collections")
+		dir.Children = []FileDto{}
+		for _, c := range collections.List() {
+			child := virtualDirDto(collectionsDirId+"/"+c.Id, c.Name)
+			child.ParentId = collectionsDirId
+			dir.Children = append(dir.Children, child)
+		}
+
+	case strings.HasPrefix(pathId, collectionsDirId+"/") && collections != nil:
+		collection, ok := collections.Get(strings.TrimPrefix(pathId, collectionsDirId+"/"))
+		if !ok {
+			failureResponse(r, &NotFoundError{"unknown collection: " + pathId}, w)
+			return
+		}
+
+		dir = virtualDirDto(pathId, collection.Name)
+		dir.ParentId = collectionsDirId
+		pathIds := make([]string, len(collection.Items))
+		for i, item := range collection.Items {
+			pathIds[i] = item.PathId
+		}
+		dir.Children = virtualChildren(r, pathIds)
+
+	case pathId == favoritesDirId && profiles != nil:
+		profile, _ := profiles.Get(profiles.Active(r))
+		dir = virtualDirDto(favoritesDirId, "Favorites")
+		dir.Children = virtualChildren(r, profile.Favorites)
+
+	default:
+		failureResponse(r, &NotFoundError{"unknown virtual directory: " + pathId}, w)
+		return
+	}
+
+	respondWithJSON(w, 200, dir)
+}
+
+func virtualDirDto(pathId string, name string) FileDto {
+	return FileDto{Type: "dir", PathId: pathId, Name: name}
+}
+
+// Real files of a virtual directory, skipping the ones missing or not visible by user
+func virtualChildren(r *http.Request, pathIds []string) []FileDto {
+	visible := visibleRoots(r)
+	children := []FileDto{}
+	for _, pathId := range pathIds {
+		path, err := NewPathFromId(pathId)
+		if err != nil || path.IsIndex() || (visible != nil && !visible(path.Root)) || isIgnoredPath(path) {
+			continue
+		}
+
+		file, err := path.ToVisibleFile(true, visible)
+		if err == nil {
+			err = parental.Visible(requestViewer(r), file)
+		}
+		if err != nil {
+			glog.V(1).Infoln("Skip ", pathId, " from virtual directory: ", err)
+			continue
+		}
+		children = append(children, NewFileDto(file))
+	}
+	return children
+}
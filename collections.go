@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// File, in data directory, where collections are persisted
+const collectionsFile = "collections.json"
+
+// Delay before looking again for an item which couldn't be found back: each search walks its whole root
+const relocateRetryDelay = time.Hour
+
+// Media or directory in a collection. Size and modification time are kept to find it back once renamed.
+type CollectionItem struct {
+	PathId  string    `json:"pathId"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Custom list of medias and directories, from any root
+type Collection struct {
+	Id      string           `json:"id"`
+	Name    string           `json:"name"`
+	Created time.Time        `json:"created"`
+	Items   []CollectionItem `json:"items"`
+}
+
+func (c *Collection) copy() Collection {
+	copied := *c
+	copied.Items = append([]CollectionItem{}, c.Items...)
+	return copied
+}
+
+// Collection request, items are given by PathId
+type CollectionDto struct {
+	Name  string   `json:"name"`
+	Items []string `json:"items"`
+}
+
+// All collections, persisted in a JSON file
+type Collections struct {
+	file string
+
+	lock        sync.Mutex
+	collections map[string]*Collection
+	// Missing items which couldn't be found back, by PathId, with when they were looked for
+	lost map[string]time.Time
+}
+
+var collections *Collections
+
+func NewCollections(file string) (*Collections, error) {
+	c := &Collections{file: file, collections: make(map[string]*Collection), lost: make(map[string]time.Time)}
+	if _, err := readJsonFile(file, &c.collections); err != nil {
+		return nil, fmt.Errorf("can't load collections from %s: %s", file, err.Error())
+	}
+
+	glog.V(1).Infoln("Loaded ", len(c.collections), " collections from ", file)
+	return c, nil
+}
+
+// Collections sorted by name
+func (c *Collections) List() []Collection {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	list := make([]Collection, 0, len(c.collections))
+	for _, collection := range c.collections {
+		list = append(list, collection.copy())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if c := collation.compare(list[i].Name, list[j].Name); c != 0 {
+			return c < 0
+		}
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+// Test if a collection exists, without looking for its moved items
+func (c *Collections) Exists(id string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.collections[id]
+	return ok
+}
+
+// Get a collection. Items which have been moved are looked for, and updated when found. Roots are walked without
+// holding the lock, and items which couldn't be found back aren't looked for again before relocateRetryDelay.
+func (c *Collections) Get(id string) (Collection, bool) {
+	c.lock.Lock()
+	collection, ok := c.collections[id]
+	if !ok {
+		c.lock.Unlock()
+		return Collection{}, false
+	}
+	copied := collection.copy()
+	var missing []CollectionItem
+	for _, item := range copied.Items {
+		if lostAt, lost := c.lost[item.PathId]; !lost || time.Since(lostAt) > relocateRetryDelay {
+			missing = append(missing, item)
+		}
+	}
+	c.lock.Unlock()
+
+	relocated := make(map[string]CollectionItem)
+	var lost []string
+	for _, item := range missing {
+		if found, missing, ok := relocate(item); ok {
+			glog.Info("Collection item ", item.PathId, " moved to ", found.PathId)
+			relocated[item.PathId] = found
+		} else if missing {
+			lost = append(lost, item.PathId)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for _, pathId := range lost {
+		c.lost[pathId] = now
+	}
+	if len(relocated) == 0 {
+		return copied, true
+	}
+
+	for _, collection := range c.collections {
+		for i, item := range collection.Items {
+			if found, ok := relocated[item.PathId]; ok {
+				collection.Items[i] = found
+			}
+		}
+	}
+	for pathId := range relocated {
+		delete(c.lost, pathId)
+	}
+	if err := c.save(); err != nil {
+		glog.Error("Can't save collections: ", err)
+	}
+
+	if collection, ok := c.collections[id]; ok {
+		return collection.copy(), true
+	}
+	return Collection{}, false
+}
+
+// Create a collection (empty id) or replace an existing one
+func (c *Collections) Put(id string, dto CollectionDto) (Collection, error) {
+	name := strings.TrimSpace(dto.Name)
+	if name == "" {
+		return Collection{}, &InvalidCollectionError{"collection name is required"}
+	}
+
+	items := make([]CollectionItem, 0, len(dto.Items))
+	for _, pathId := range dto.Items {
+		item, err := newCollectionItem(pathId)
+		if err != nil {
+			return Collection{}, &InvalidCollectionError{err.Error()}
+		}
+		items = append(items, item)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	collection, ok := c.collections[id]
+	if !ok {
+		base := slugId(name)
+		if base == "" {
+			base = "collection"
+		}
+		id = base
+		for i := 2; c.collections[id] != nil; i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		collection = &Collection{Id: id, Created: time.Now()}
+		c.collections[id] = collection
+	}
+
+	collection.Name = name
+	collection.Items = items
+	return collection.copy(), c.save()
+}
+
+// Add an item at the end of a collection, or remove it. Return false if collection doesn't exist.
+func (c *Collections) SetItem(id string, pathId string, included bool) (bool, error) {
+	var item CollectionItem
+	if included {
+		var err error
+		if item, err = newCollectionItem(pathId); err != nil {
+			return true, &InvalidCollectionError{err.Error()}
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	collection, ok := c.collections[id]
+	if !ok {
+		return false, nil
+	}
+
+	items := collection.Items[:0]
+	for _, i := range collection.Items {
+		if i.PathId != pathId {
+			items = append(items, i)
+		}
+	}
+	if included {
+		items = append(items, item)
+	}
+	collection.Items = items
+
+	return true, c.save()
+}
+
+// Return false if collection doesn't exist
+func (c *Collections) Remove(id string) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.collections[id]; !ok {
+		return false, nil
+	}
+	delete(c.collections, id)
+	return true, c.save()
+}
+
+// Lock must be held
+func (c *Collections) save() error {
+	return writeJsonFile(c.file, c.collections)
+}
+
+// Build item of an existing file
+func newCollectionItem(pathId string) (CollectionItem, error) {
+	path, err := NewPathFromId(pathId)
+	if err != nil {
+		return CollectionItem{}, err
+	}
+	if path.IsIndex() {
+		return CollectionItem{}, fmt.Errorf("index can't be in a collection")
+	}
+
+	stat, err := os.Stat(path.localPath)
+	if err != nil {
+		return CollectionItem{}, fmt.Errorf("can't add %s: %s", pathId, err.Error())
+	}
+
+	item := CollectionItem{PathId: path.PathId(), Dir: stat.IsDir()}
+	if !item.Dir {
+		item.Size = stat.Size()
+		item.ModTime = stat.ModTime()
+	}
+	return item, nil
+}
+
+var errAmbiguous = fmt.Errorf("several candidates")
+
+// Find where a missing item has been moved in its root: file with same size and modification time, or directory
+// with same name. Only an unique candidate is accepted. Missing is false when the item is still there (or invalid).
+func relocate(item CollectionItem) (relocated CollectionItem, missing bool, found bool) {
+	path, err := NewPathFromId(item.PathId)
+	if err != nil || path.IsIndex() {
+		return item, false, false
+	}
+	if _, err := os.Stat(path.localPath); !os.IsNotExist(err) {
+		return item, false, false
+	}
+
+	var candidates []string
+	walkRoot(path.Root, roots[path.Root].localPath, false, func(_ string, relative string, f os.FileInfo, err error) error {
+		switch {
+		case len(candidates) > 1:
+			return errAmbiguous
+		case f == nil:
+			return nil
+		case item.Dir && f.IsDir() && f.Name() == path.Name && relative != "":
+			candidates = append(candidates, relative)
+		case !item.Dir && !f.IsDir() && f.Size() == item.Size && f.ModTime().Equal(item.ModTime):
+			candidates = append(candidates, relative)
+		}
+		return nil
+	})
+	if len(candidates) != 1 {
+		return item, true, false
+	}
+
+	item.PathId = path.Root + "/" + candidates[0]
+	return item, true, true
+}
+
+// Collection request can't be applied
+type InvalidCollectionError struct {
+	Reason string
+}
+
+func (e *InvalidCollectionError) Error() string {
+	return e.Reason
+}
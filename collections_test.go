@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollections(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-collections")
+	defer os.RemoveAll(dir)
+
+	films := filepath.Join(dir, "films")
+	music := filepath.Join(dir, "music")
+	os.MkdirAll(filepath.Join(films, "Christmas"), 0755)
+	os.MkdirAll(filepath.Join(music, "Carols"), 0755)
+	ioutil.WriteFile(filepath.Join(films, "Christmas", "Home Alone.mkv"), []byte("fake movie"), 0644)
+	ioutil.WriteFile(filepath.Join(films, "Christmas", "Elf.mkv"), []byte("another fake movie"), 0644)
+	roots = map[string]Path{"films": {Root: "films", localPath: films}, "music": {Root: "music", localPath: music}}
+
+	c, err := NewCollections(filepath.Join(dir, "collections.json"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	collections = c
+	defer func() { collections = nil }()
+
+	t.Run("collections mix paths from several roots", func(t *testing.T) {
+		xmas, err := c.Put("", CollectionDto{Name: "Christmas films", Items: []string{"films/Christmas/Home Alone.mkv", "music/Carols"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "christmas-films", xmas.Id)
+		if assert.Len(t, xmas.Items, 2) {
+			assert.False(t, xmas.Items[0].Dir)
+			assert.Equal(t, int64(10), xmas.Items[0].Size)
+			assert.True(t, xmas.Items[1].Dir)
+		}
+
+		_, err = c.Put("", CollectionDto{Name: "Broken", Items: []string{"films/Missing.mkv"}})
+		assert.IsType(t, &InvalidCollectionError{}, err)
+
+		found, err := c.SetItem("christmas-films", "films/Christmas/Elf.mkv", true)
+		assert.True(t, found)
+		assert.NoError(t, err)
+	})
+
+	t.Run("collections are browsable as directories", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", BROWSER_PREFIX+"/@collections/christmas-films", nil))
+		assert.Equal(t, 200, w.Code)
+
+		var dto FileDto
+		json.Unmarshal(w.Body.Bytes(), &dto)
+		assert.Equal(t, "dir", dto.Type)
+		assert.Equal(t, "Christmas films", dto.Name)
+		assert.Equal(t, collectionsDirId, dto.ParentId)
+
+		var children []string
+		for _, child := range dto.Children {
+			children = append(children, child.PathId)
+		}
+		assert.Equal(t, []string{"films/Christmas/Home Alone.mkv", "music/Carols", "films/Christmas/Elf.mkv"}, children)
+
+		w = httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", BROWSER_PREFIX+"/@collections/unknown", nil))
+		assert.Equal(t, 404, w.Code)
+	})
+
+	t.Run("renamed media are found back", func(t *testing.T) {
+		os.MkdirAll(filepath.Join(films, "Kids"), 0755)
+		os.Rename(filepath.Join(films, "Christmas", "Home Alone.mkv"), filepath.Join(films, "Kids", "Home Alone (1990).mkv"))
+		os.Rename(filepath.Join(music, "Carols"), filepath.Join(music, "Carols (old)"))
+
+		xmas, _ := c.Get("christmas-films")
+		assert.Equal(t, "films/Kids/Home Alone (1990).mkv", xmas.Items[0].PathId)
+		assert.Equal(t, "music/Carols", xmas.Items[1].PathId, "renamed directories can't be found")
+
+		reloaded, _ := NewCollections(filepath.Join(dir, "collections.json"))
+		xmas, _ = reloaded.Get("christmas-films")
+		assert.Equal(t, "films/Kids/Home Alone (1990).mkv", xmas.Items[0].PathId)
+	})
+
+	t.Run("missing media are not looked for again on each view", func(t *testing.T) {
+		os.Remove(filepath.Join(films, "Christmas", "Elf.mkv"))
+		xmas, _ := c.Get("christmas-films")
+		assert.Equal(t, "films/Christmas/Elf.mkv", xmas.Items[2].PathId)
+		assert.Contains(t, c.lost, "films/Christmas/Elf.mkv")
+
+		// put back elsewhere: not found until retry delay is over
+		ioutil.WriteFile(filepath.Join(films, "Elf.mkv"), []byte("another fake movie"), 0644)
+		os.Chtimes(filepath.Join(films, "Elf.mkv"), xmas.Items[2].ModTime, xmas.Items[2].ModTime)
+		xmas, _ = c.Get("christmas-films")
+		assert.Equal(t, "films/Christmas/Elf.mkv", xmas.Items[2].PathId)
+
+		c.lost["films/Christmas/Elf.mkv"] = time.Now().Add(-relocateRetryDelay - time.Minute)
+		xmas, _ = c.Get("christmas-films")
+		assert.Equal(t, "films/Elf.mkv", xmas.Items[2].PathId)
+		assert.NotContains(t, c.lost, "films/Christmas/Elf.mkv")
+	})
+
+	t.Run("media are found back in roots with unclean paths", func(t *testing.T) {
+		roots["films"] = Path{Root: "films", localPath: dir + "/music/../films/"}
+		defer func() { roots["films"] = Path{Root: "films", localPath: films} }()
+
+		os.Rename(filepath.Join(films, "Elf.mkv"), filepath.Join(films, "Kids", "Elf.mkv"))
+		xmas, _ := c.Get("christmas-films")
+		assert.Equal(t, "films/Kids/Elf.mkv", xmas.Items[2].PathId)
+	})
+
+	t.Run("items of hidden roots aren't returned", func(t *testing.T) {
+		assert.NoError(t, ConfigureRoleRoots("child:music"))
+		defer ConfigureRoleRoots("")
+
+		r := httptest.NewRequest("GET", "/api/collections/christmas-films", nil)
+		r = mux.SetURLVars(r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: "kid", Role: RoleChild})), map[string]string{"id": "christmas-films"})
+		w := httptest.NewRecorder()
+		HandleCollection(w, r)
+
+		var xmas Collection
+		json.Unmarshal(w.Body.Bytes(), &xmas)
+		if assert.Len(t, xmas.Items, 1) {
+			assert.Equal(t, "music/Carols", xmas.Items[0].PathId)
+		}
+	})
+}
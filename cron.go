@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shortcuts accepted instead of the 5 fields
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Cron-like expression: "minute hour day-of-month month day-of-week"
+// Each field accepts '*', values, ranges (1-5), lists (1,3,5) and steps (*/15, 8-18/2). Sunday is 0 or 7.
+type cronSpec struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// Day of month and day of week are restricted: matching any of them is enough (like cron)
+	anyDay bool
+}
+
+func parseCron(expression string) (*cronSpec, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, ok := cronMacros[expression]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields: minute hour day-of-month month day-of-week", expression)
+	}
+
+	spec := &cronSpec{}
+	var err error
+	if spec.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if spec.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if spec.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if spec.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if spec.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+
+	// 7 is an alias for sunday
+	if spec.weekdays&(1<<7) != 0 {
+		spec.weekdays |= 1
+	}
+	// as in Vixie cron, a field starting with '*' (even with a step) doesn't restrict days
+	spec.anyDay = !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*")
+
+	return spec, nil
+}
+
+// Parse one field into a bit set of accepted values
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in cron field '%s'", field)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in cron field '%s'", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range in cron field '%s'", field)
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 to max, every 15
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("cron field '%s' is out of range %d-%d", field, min, max)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// True when time (at minute precision) is selected by the expression
+func (c *cronSpec) Matches(t time.Time) bool {
+	if c.minutes&(1<<uint(t.Minute())) == 0 || c.hours&(1<<uint(t.Hour())) == 0 || c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Next matching minute strictly after given time, nil if none within a year (i.e. 31st of February)
+func (c *cronSpec) Next(after time.Time) *time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(1, 0, 1); t.Before(limit); t = t.Add(time.Minute) {
+		if c.Matches(t) {
+			return &t
+		}
+	}
+	return nil
+}
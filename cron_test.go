@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCron(t *testing.T) {
+	// Monday 2018-10-01
+	monday := func(hour int, minute int) time.Time {
+		return time.Date(2018, 10, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		time       time.Time
+		want       bool
+	}{
+		{"every minute", "* * * * *", monday(13, 37), true},
+		{"fixed time", "30 7 * * *", monday(7, 30), true},
+		{"fixed time, other minute", "30 7 * * *", monday(7, 31), false},
+		{"weekdays range", "30 7 * * 1-5", monday(7, 30), true},
+		{"week-end only", "30 7 * * 0,6", monday(7, 30), false},
+		{"sunday as 7", "0 9 * * 7", monday(9, 0).AddDate(0, 0, 6), true},
+		{"step", "*/15 * * * *", monday(10, 45), true},
+		{"step not matching", "*/15 * * * *", monday(10, 50), false},
+		{"range with step", "0 8-18/2 * * *", monday(10, 0), true},
+		{"range with step not matching", "0 8-18/2 * * *", monday(11, 0), false},
+		{"day of month or day of week", "0 0 15 * 1", monday(0, 0), true},
+		{"day of month with step and day of week", "0 8 */2 * 1", monday(8, 0), true},
+		{"day of month with step and day of week, even day", "0 8 */2 * 1", monday(8, 0).AddDate(0, 0, 7), false},
+		{"day of month with step and day of week, other weekday", "0 8 */2 * 1", monday(8, 0).AddDate(0, 0, 2), false},
+		{"month", "0 0 1 9 *", monday(0, 0), false},
+		{"macro", "@daily", monday(0, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseCron(tt.expression)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, spec.Matches(tt.time))
+			}
+		})
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		t.Run("invalid '"+invalid+"'", func(t *testing.T) {
+			_, err := parseCron(invalid)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_cronSpec_Next(t *testing.T) {
+	spec, _ := parseCron("30 7 * * 1-5")
+
+	// Friday evening, next is Monday morning
+	friday := time.Date(2018, 10, 5, 20, 0, 12, 0, time.Local)
+	assert.Equal(t, time.Date(2018, 10, 8, 7, 30, 0, 0, time.Local), *spec.Next(friday))
+
+	// Strictly after
+	monday := time.Date(2018, 10, 8, 7, 30, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2018, 10, 9, 7, 30, 0, 0, time.Local), *spec.Next(monday))
+
+	never, _ := parseCron("0 0 31 2 *")
+	assert.Nil(t, never.Next(friday))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Path of a state file in data directory (-data)
+func dataFile(name string) string {
+	dir := "."
+	if GetMmConfig() != nil && GetMmConfig().data != "" {
+		dir = GetMmConfig().data
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// Read JSON file into value. Return false, without error, when file doesn't exist yet.
+func readJsonFile(path string, value interface{}) (bool, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(content, value)
+}
+
+// Write value as JSON. File is replaced atomically to never leave a truncated state on crash.
+func writeJsonFile(path string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write content readable by owner only. An existing file is replaced, to not keep its permissions.
+func writeSecretFile(path string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(content), 0600)
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Identifier built from a display name: lower case letters and digits separated by dashes
+func slugId(name string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
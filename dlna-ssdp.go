@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ssdpMulticast = "239.255.255.250:1900"
+	ssdpMaxAge    = 1800
+	ssdpNotifyGap = 30 * time.Second
+)
+
+// Announce DLNA server on the network (SSDP protocol) and answer discovery requests
+type ssdpAnnouncer struct {
+	udn     string
+	port    int
+	targets []string
+
+	multicast *net.UDPAddr
+	stopIt    chan bool
+}
+
+func newSsdpAnnouncer(udn string, port int) (*ssdpAnnouncer, error) {
+	multicast, err := net.ResolveUDPAddr("udp4", ssdpMulticast)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssdpAnnouncer{
+		udn:       udn,
+		port:      port,
+		targets:   []string{"upnp:rootdevice", udn, mediaServerType, contentDirectoryType, connectionManagerType},
+		multicast: multicast,
+		stopIt:    make(chan bool, 1),
+	}, nil
+}
+
+// Listen discovery requests and periodically notify presence, until Stop is called
+func (a *ssdpAnnouncer) Start() {
+	conn, err := net.ListenMulticastUDP("udp4", nil, a.multicast)
+	if err != nil {
+		glog.Error("Can't listen SSDP discovery requests, DLNA server won't be discoverable: ", err)
+		return
+	}
+	go a.answerSearches(conn)
+
+	ticker := time.NewTicker(ssdpNotifyGap)
+	defer ticker.Stop()
+
+	a.notify("ssdp:alive")
+	for {
+		select {
+		case <-ticker.C:
+			a.notify("ssdp:alive")
+
+		case <-a.stopIt:
+			a.notify("ssdp:byebye")
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (a *ssdpAnnouncer) Stop() {
+	select {
+	case a.stopIt <- true:
+	default:
+	}
+}
+
+// Read M-SEARCH requests until connection is closed
+func (a *ssdpAnnouncer) answerSearches(conn *net.UDPConn) {
+	buffer := make([]byte, 2048)
+	for {
+		n, remote, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			glog.V(1).Infoln("Stop answering SSDP requests: ", err)
+			return
+		}
+
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buffer[:n])))
+		if err != nil || request.Method != "M-SEARCH" || request.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		ip, err := localIpFor(remote)
+		if err != nil {
+			glog.Warning("Can't find local address to answer ", remote, ": ", err)
+			continue
+		}
+
+		for _, response := range a.searchResponses(request.Header.Get("ST"), ip) {
+			glog.V(2).Infoln("SSDP answer to ", remote, ": ", response)
+			if _, err := conn.WriteToUDP([]byte(response), remote); err != nil {
+				glog.Warning("Can't answer SSDP request of ", remote, ": ", err)
+			}
+		}
+	}
+}
+
+// Build responses for requested search target, empty if nothing matches
+func (a *ssdpAnnouncer) searchResponses(searchTarget string, ip net.IP) []string {
+	var responses []string
+	for _, target := range a.targets {
+		if searchTarget == "ssdp:all" || searchTarget == target {
+			responses = append(responses, fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+				"CACHE-CONTROL: max-age=%d\r\n"+
+				"DATE: %s\r\n"+
+				"EXT:\r\n"+
+				"LOCATION: %s\r\n"+
+				"SERVER: %s\r\n"+
+				"ST: %s\r\n"+
+				"USN: %s\r\n\r\n",
+				ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), a.location(ip), ssdpServer, target, a.usn(target)))
+		}
+	}
+
+	return responses
+}
+
+// Multicast a NOTIFY message for each target, on each network interface
+func (a *ssdpAnnouncer) notify(subType string) {
+	for _, ip := range localIps() {
+		conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: ip}, a.multicast)
+		if err != nil {
+			glog.Warning("Can't notify SSDP from ", ip, ": ", err)
+			continue
+		}
+
+		for _, target := range a.targets {
+			message := fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+				"HOST: %s\r\n"+
+				"CACHE-CONTROL: max-age=%d\r\n"+
+				"LOCATION: %s\r\n"+
+				"NT: %s\r\n"+
+				"NTS: %s\r\n"+
+				"SERVER: %s\r\n"+
+				"USN: %s\r\n\r\n",
+				ssdpMulticast, ssdpMaxAge, a.location(ip), target, subType, ssdpServer, a.usn(target))
+			if _, err := conn.Write([]byte(message)); err != nil {
+				glog.Warning("Can't notify SSDP from ", ip, ": ", err)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func (a *ssdpAnnouncer) location(ip net.IP) string {
+	return fmt.Sprintf("http://%s:%d%s/device.xml", ip, a.port, DLNA_PREFIX)
+}
+
+// Unique Service Name of a target
+func (a *ssdpAnnouncer) usn(target string) string {
+	if target == a.udn {
+		return a.udn
+	}
+	return a.udn + "::" + target
+}
+
+const ssdpServer = "Linux/1.0 UPnP/1.0 medima-pi/1.0"
+
+// IPv4 address used to reach remote host
+func localIpFor(remote *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// All non loopback IPv4 addresses of this host
+func localIps() []net.IP {
+	var ips []net.IP
+
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		glog.Warning("Can't list network interfaces: ", err)
+		return ips
+	}
+
+	for _, address := range addresses {
+		if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	return ips
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const (
+	DLNA_PREFIX = "/dlna"
+
+	// Object ID of the DLNA root container, which lists configured roots
+	dlnaRootId = "0"
+
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	mediaServerType       = "urn:schemas-upnp-org:device:MediaServer:1"
+
+	dlnaContentFeatures = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+// Expose roots as an UPnP ContentDirectory (DLNA Media Server) when enabled
+func DlnaController(r *mux.Router) error {
+	config := GetMmConfig()
+	if !config.dlna {
+		glog.V(1).Infoln("DLNA server is disabled")
+		return nil
+	}
+	glog.V(1).Infoln("Registering DLNA Controller")
+
+	server := newDlnaServer(config.dlnaName)
+
+	r.Methods("GET").Path(DLNA_PREFIX + "/device.xml").HandlerFunc(server.deviceDescription)
+	r.Methods("GET").Path(DLNA_PREFIX + "/ContentDirectory.xml").HandlerFunc(xmlDocument(contentDirectoryScpd))
+	r.Methods("GET").Path(DLNA_PREFIX + "/ConnectionManager.xml").HandlerFunc(xmlDocument(connectionManagerScpd))
+	r.Methods("POST").Path(DLNA_PREFIX + "/control/ContentDirectory").HandlerFunc(server.contentDirectoryControl)
+	r.Methods("POST").Path(DLNA_PREFIX + "/control/ConnectionManager").HandlerFunc(server.connectionManagerControl)
+	r.Methods("SUBSCRIBE", "UNSUBSCRIBE").PathPrefix(DLNA_PREFIX + "/event/").HandlerFunc(eventSubscription)
+
+	announcer, err := newSsdpAnnouncer(server.udn, config.PlainPort())
+	if err != nil {
+		return err
+	}
+	go announcer.Start()
+	onShutdown("DLNA announcer", announcer.Stop)
+
+	glog.Info("DLNA controller loaded, announced as '", config.dlnaName, "' (", server.udn, ")")
+	return nil
+}
+
+type dlnaServer struct {
+	name string
+	udn  string
+}
+
+func newDlnaServer(name string) *dlnaServer {
+	return &dlnaServer{name: name, udn: stableUdn(name)}
+}
+
+// UDN must not change between restarts, otherwise clients see a new server each time
+func stableUdn(name string) string {
+	hostname, _ := os.Hostname()
+	sum := md5.Sum([]byte(hostname + "/" + name))
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// UPnP device description
+type upnpRoot struct {
+	XMLName     xml.Name    `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion specVersion `xml:"specVersion"`
+	Device      upnpDevice  `xml:"device"`
+}
+type specVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+type upnpDevice struct {
+	DeviceType   string        `xml:"deviceType"`
+	FriendlyName string        `xml:"friendlyName"`
+	Manufacturer string        `xml:"manufacturer"`
+	ModelName    string        `xml:"modelName"`
+	UDN          string        `xml:"UDN"`
+	Services     []upnpService `xml:"serviceList>service"`
+}
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceId   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+func (s *dlnaServer) deviceDescription(w http.ResponseWriter, _ *http.Request) {
+	description := upnpRoot{
+		SpecVersion: specVersion{1, 0},
+		Device: upnpDevice{
+			DeviceType:   mediaServerType,
+			FriendlyName: s.name,
+			Manufacturer: "Medima PI",
+			ModelName:    "medima-pi",
+			UDN:          s.udn,
+			Services: []upnpService{
+				newUpnpService(contentDirectoryType, "ContentDirectory"),
+				newUpnpService(connectionManagerType, "ConnectionManager"),
+			},
+		},
+	}
+
+	writeXml(w, 200, description)
+}
+
+func newUpnpService(serviceType string, name string) upnpService {
+	return upnpService{
+		ServiceType: serviceType,
+		ServiceId:   "urn:upnp-org:serviceId:" + name,
+		SCPDURL:     DLNA_PREFIX + "/" + name + ".xml",
+		ControlURL:  DLNA_PREFIX + "/control/" + name,
+		EventSubURL: DLNA_PREFIX + "/event/" + name,
+	}
+}
+
+// Handle ContentDirectory SOAP actions
+func (s *dlnaServer) contentDirectoryControl(w http.ResponseWriter, r *http.Request) {
+	action, err := readSoapAction(r.Body)
+	if err != nil {
+		glog.Warning("Invalid SOAP request: ", err)
+		soapFault(w, 401, "Invalid Action")
+		return
+	}
+	glog.V(1).Infoln("ContentDirectory action ", action.XMLName.Local, " from ", r.RemoteAddr)
+
+	switch action.XMLName.Local {
+	case "Browse":
+		start, err1 := strconv.Atoi(action.Arg("StartingIndex"))
+		count, err2 := strconv.Atoi(action.Arg("RequestedCount"))
+		if err1 != nil || err2 != nil || start < 0 || count < 0 {
+			soapFault(w, 402, "Invalid Args")
+			return
+		}
+
+		baseUrl := "http://" + r.Host
+		result, returned, total, err := s.browse(action.Arg("ObjectID"), action.Arg("BrowseFlag"), start, count, baseUrl, requestViewer(r))
+		if err != nil {
+			glog.Warning("Can't browse ", action.Arg("ObjectID"), ": ", err)
+			soapFault(w, 701, "No such object")
+			return
+		}
+
+		soapResponse(w, contentDirectoryType, "Browse",
+			"Result", result,
+			"NumberReturned", strconv.Itoa(returned),
+			"TotalMatches", strconv.Itoa(total),
+			"UpdateID", "1")
+
+	case "GetSearchCapabilities":
+		soapResponse(w, contentDirectoryType, "GetSearchCapabilities", "SearchCaps", "")
+
+	case "GetSortCapabilities":
+		soapResponse(w, contentDirectoryType, "GetSortCapabilities", "SortCaps", "")
+
+	case "GetSystemUpdateID":
+		soapResponse(w, contentDirectoryType, "GetSystemUpdateID", "Id", "1")
+
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+// Handle ConnectionManager SOAP actions, mandatory for a MediaServer
+func (s *dlnaServer) connectionManagerControl(w http.ResponseWriter, r *http.Request) {
+	action, err := readSoapAction(r.Body)
+	if err != nil {
+		soapFault(w, 401, "Invalid Action")
+		return
+	}
+
+	switch action.XMLName.Local {
+	case "GetProtocolInfo":
+		var protocols []string
+		for _, mime := range mediaMimeTypes {
+			protocols = append(protocols, "http-get:*:"+mime+":*")
+		}
+		sort.Strings(protocols)
+		soapResponse(w, connectionManagerType, "GetProtocolInfo", "Source", strings.Join(protocols, ","), "Sink", "")
+
+	case "GetCurrentConnectionIDs":
+		soapResponse(w, connectionManagerType, "GetCurrentConnectionIDs", "ConnectionIDs", "0")
+
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+// Accept (but ignore) event subscriptions: content never changes from clients point of view
+func eventSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "SUBSCRIBE" {
+		w.Header().Set("SID", stableUdn(r.URL.Path))
+		w.Header().Set("TIMEOUT", "Second-1800")
+	}
+	w.WriteHeader(200)
+}
+
+// Build DIDL-Lite document for a browse request, without media hidden to viewer by parental rules
+// objectId is either the root container ID ("0"), or a PathId
+func (s *dlnaServer) browse(objectId string, browseFlag string, start int, count int, baseUrl string, viewer parentalViewer) (string, int, int, error) {
+	if objectId == dlnaRootId {
+		objectId = ""
+	}
+	path, err := NewPathFromId(objectId)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	visible := anonymousRoots()
+	if !path.IsIndex() && visible != nil && !visible(path.Root) {
+		return "", 0, 0, &ForbiddenError{"root '" + path.Root + "' is not shared on the network"}
+	}
+
+	didl := newDidlLite(viewer)
+	switch browseFlag {
+	case "BrowseMetadata":
+		file, err := path.ToVisibleFile(true, visible)
+		if err == nil {
+			err = parental.Visible(viewer, file)
+		}
+		if err != nil {
+			return "", 0, 0, err
+		}
+		if !didl.add(file, baseUrl) {
+			return "", 0, 0, fmt.Errorf("%s is not a media", objectId)
+		}
+		if len(didl.Containers) > 0 {
+			children, _ := dlnaChildren(path, visible, viewer)
+			childCount := len(children)
+			didl.Containers[0].ChildCount = &childCount
+		}
+		if path.IsIndex() {
+			didl.Containers[0].Title = s.name
+		}
+
+	case "BrowseDirectChildren":
+		children, err := dlnaChildren(path, visible, viewer)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		end := len(children)
+		if count > 0 && start+count < end {
+			end = start + count
+		}
+		for i := start; i < end; i++ {
+			didl.add(children[i], baseUrl)
+		}
+
+		result, err := didl.marshal()
+		return result, len(didl.Containers) + len(didl.Items), len(children), err
+
+	default:
+		return "", 0, 0, fmt.Errorf("unknown browse flag: %s", browseFlag)
+	}
+
+	result, err := didl.marshal()
+	return result, 1, 1, err
+}
+
+// Children of a path which can be exposed through DLNA: directories and known media visible to viewer, sorted by name
+func dlnaChildren(path Path, visible RootPredicate, viewer parentalViewer) ([]File, error) {
+	file, err := path.ToVisibleFile(false, visible)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, ok := file.(*Dir)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a directory", path.PathId())
+	}
+
+	var children []File
+	for _, c := range dir.Children {
+		if (c.IsDir() || mediaMimeType(c.Path().Ext()) != "") && parental.Visible(viewer, c) == nil {
+			children = append(children, c)
+		}
+	}
+
+	// Index is built from a map, order must be stable to allow paging
+	if path.IsIndex() {
+		sort.Sort(&dirSorter{children, nameOrder})
+	}
+
+	return children, nil
+}
+
+type didlLite struct {
+	XMLName    xml.Name        `xml:"DIDL-Lite"`
+	Xmlns      string          `xml:"xmlns,attr"`
+	XmlnsDc    string          `xml:"xmlns:dc,attr"`
+	XmlnsUpnp  string          `xml:"xmlns:upnp,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+
+	// Stream URLs are signed for this viewer
+	viewer parentalViewer
+}
+type didlContainer struct {
+	Id         string `xml:"id,attr"`
+	ParentId   string `xml:"parentID,attr"`
+	Restricted string `xml:"restricted,attr"`
+	ChildCount *int   `xml:"childCount,attr,omitempty"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+}
+type didlItem struct {
+	Id         string  `xml:"id,attr"`
+	ParentId   string  `xml:"parentID,attr"`
+	Restricted string  `xml:"restricted,attr"`
+	Title      string  `xml:"dc:title"`
+	Class      string  `xml:"upnp:class"`
+	Res        didlRes `xml:"res"`
+}
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	Url          string `xml:",chardata"`
+}
+
+// Document with stream URLs given to viewer
+func newDidlLite(viewer parentalViewer) *didlLite {
+	return &didlLite{
+		Xmlns:     "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XmlnsDc:   "http://purl.org/dc/elements/1.1/",
+		XmlnsUpnp: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		viewer:    viewer,
+	}
+}
+
+// Add a file as container or item, return false when the file can't be exposed
+func (d *didlLite) add(file File, baseUrl string) bool {
+	path := file.Path()
+	id, parentId := dlnaIds(path)
+
+	if file.IsDir() {
+		d.Containers = append(d.Containers, didlContainer{
+			Id:         id,
+			ParentId:   parentId,
+			Restricted: "1",
+			Title:      path.DisplayName(),
+			Class:      "object.container.storageFolder",
+		})
+		return true
+	}
+
+	mime := mediaMimeType(path.Ext())
+	if mime == "" {
+		return false
+	}
+
+	var size int64
+	if stat, err := os.Stat(path.localPath); err == nil {
+		size = stat.Size()
+	}
+
+	d.Items = append(d.Items, didlItem{
+		Id:         id,
+		ParentId:   parentId,
+		Restricted: "1",
+		Title:      path.DisplayName(),
+		Class:      didlItemClass(mime),
+		Res: didlRes{
+			ProtocolInfo: "http-get:*:" + mime + ":" + dlnaContentFeatures,
+			Size:         size,
+			Url:          streamUrl(baseUrl, *path, d.viewer),
+		},
+	})
+	return true
+}
+
+func (d *didlLite) marshal() (string, error) {
+	content, err := xml.Marshal(d)
+	return string(content), err
+}
+
+// DLNA IDs are PathIds, except for the root container and its direct children
+func dlnaIds(path *Path) (string, string) {
+	if path.IsIndex() {
+		return dlnaRootId, "-1"
+	}
+	if path.Name == "" {
+		return path.PathId(), dlnaRootId
+	}
+	return path.PathId(), path.ParentId()
+}
+
+func didlItemClass(mime string) string {
+	switch {
+	case strings.HasPrefix(mime, "video/"):
+		return "object.item.videoItem"
+	case strings.HasPrefix(mime, "audio/"):
+		return "object.item.audioItem.musicTrack"
+	case strings.HasPrefix(mime, "image/"):
+		return "object.item.imageItem.photo"
+	default:
+		return "object.item"
+	}
+}
+
+// URL escape each element of the path ID
+func escapePathId(pathId string) string {
+	elements := strings.Split(pathId, "/")
+	for i, e := range elements {
+		elements[i] = url.PathEscape(e)
+	}
+	return strings.Join(elements, "/")
+}
+
+// SOAP envelope as received from control points
+type soapEnvelope struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    soapBody `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+type soapBody struct {
+	Action soapAction `xml:",any"`
+}
+type soapAction struct {
+	XMLName xml.Name
+	Args    []soapArg `xml:",any"`
+}
+type soapArg struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// Get argument value, empty string when missing
+func (a *soapAction) Arg(name string) string {
+	for _, arg := range a.Args {
+		if arg.XMLName.Local == name {
+			return arg.Value
+		}
+	}
+	return ""
+}
+
+func readSoapAction(body io.Reader) (*soapAction, error) {
+	var envelope soapEnvelope
+	if err := xml.NewDecoder(body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Body.Action.XMLName.Local == "" {
+		return nil, fmt.Errorf("SOAP body doesn't contain any action")
+	}
+	return &envelope.Body.Action, nil
+}
+
+// Write SOAP response ; values are pairs of argument name and value
+func soapResponse(w http.ResponseWriter, serviceType string, action string, values ...string) {
+	writeSoapEnvelope(w, 200, soapActionXml(serviceType, action+"Response", values...))
+}
+
+// Build SOAP body element ; values are pairs of argument name and value
+func soapActionXml(serviceType string, element string, values ...string) string {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, element, serviceType)
+	for i := 0; i+1 < len(values); i += 2 {
+		fmt.Fprintf(&body, "<%s>", values[i])
+		xml.EscapeText(&body, []byte(values[i+1]))
+		fmt.Fprintf(&body, "</%s>", values[i])
+	}
+	fmt.Fprintf(&body, `</u:%s>`, element)
+
+	return body.String()
+}
+
+func soapFault(w http.ResponseWriter, code int, description string) {
+	writeSoapEnvelope(w, 500, fmt.Sprintf(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError></detail>`+
+		`</s:Fault>`, code, description))
+}
+
+func writeSoapEnvelope(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("EXT", "")
+	w.WriteHeader(code)
+	fmt.Fprint(w, soapEnvelopeXml(body))
+}
+
+func soapEnvelopeXml(body string) string {
+	return xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		body + `</s:Body></s:Envelope>`
+}
+
+// Serialise payload into XML document, respond with 500 if it can't
+func writeXml(w http.ResponseWriter, code int, payload interface{}) {
+	content, err := xml.Marshal(payload)
+	if err != nil {
+		glog.Error("Can't serialise XML document: ", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	w.Write(content)
+}
+
+// Serve a static XML document
+func xmlDocument(content string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, xml.Header+content)
+	}
+}
+
+const contentDirectoryScpd = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>Browse</name>
+      <argumentList>
+        <argument><name>ObjectID</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_ObjectID</relatedStateVariable></argument>
+        <argument><name>BrowseFlag</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_BrowseFlag</relatedStateVariable></argument>
+        <argument><name>Filter</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Filter</relatedStateVariable></argument>
+        <argument><name>StartingIndex</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Index</relatedStateVariable></argument>
+        <argument><name>RequestedCount</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>SortCriteria</name><direction>in</direction><relatedStateVariable>A_ARG_TYPE_SortCriteria</relatedStateVariable></argument>
+        <argument><name>Result</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable></argument>
+        <argument><name>NumberReturned</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>TotalMatches</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_Count</relatedStateVariable></argument>
+        <argument><name>UpdateID</name><direction>out</direction><relatedStateVariable>A_ARG_TYPE_UpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSearchCapabilities</name>
+      <argumentList>
+        <argument><name>SearchCaps</name><direction>out</direction><relatedStateVariable>SearchCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSortCapabilities</name>
+      <argumentList>
+        <argument><name>SortCaps</name><direction>out</direction><relatedStateVariable>SortCapabilities</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetSystemUpdateID</name>
+      <argumentList>
+        <argument><name>Id</name><direction>out</direction><relatedStateVariable>SystemUpdateID</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_ObjectID</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Result</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_BrowseFlag</name><dataType>string</dataType>
+      <allowedValueList><allowedValue>BrowseMetadata</allowedValue><allowedValue>BrowseDirectChildren</allowedValue></allowedValueList>
+    </stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Filter</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_SortCriteria</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Index</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_Count</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>A_ARG_TYPE_UpdateID</name><dataType>ui4</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SearchCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="no"><name>SortCapabilities</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SystemUpdateID</name><dataType>ui4</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
+
+const connectionManagerScpd = `<scpd xmlns="urn:schemas-upnp-org:service-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <actionList>
+    <action>
+      <name>GetProtocolInfo</name>
+      <argumentList>
+        <argument><name>Source</name><direction>out</direction><relatedStateVariable>SourceProtocolInfo</relatedStateVariable></argument>
+        <argument><name>Sink</name><direction>out</direction><relatedStateVariable>SinkProtocolInfo</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+    <action>
+      <name>GetCurrentConnectionIDs</name>
+      <argumentList>
+        <argument><name>ConnectionIDs</name><direction>out</direction><relatedStateVariable>CurrentConnectionIDs</relatedStateVariable></argument>
+      </argumentList>
+    </action>
+  </actionList>
+  <serviceStateTable>
+    <stateVariable sendEvents="yes"><name>SourceProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>SinkProtocolInfo</name><dataType>string</dataType></stateVariable>
+    <stateVariable sendEvents="yes"><name>CurrentConnectionIDs</name><dataType>string</dataType></stateVariable>
+  </serviceStateTable>
+</scpd>`
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SOAP response, as parsed by a control point
+type browseResponseEnvelope struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    struct {
+		Response struct {
+			XMLName        xml.Name
+			Result         string `xml:"Result"`
+			NumberReturned int    `xml:"NumberReturned"`
+			TotalMatches   int    `xml:"TotalMatches"`
+		} `xml:",any"`
+		Fault struct {
+			ErrorCode int `xml:"detail>UPnPError>errorCode"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+type parsedDidl struct {
+	Containers []struct {
+		Id       string `xml:"id,attr"`
+		ParentId string `xml:"parentID,attr"`
+		Title    string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Class    string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ class"`
+	} `xml:"container"`
+	Items []struct {
+		Id       string `xml:"id,attr"`
+		ParentId string `xml:"parentID,attr"`
+		Title    string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Class    string `xml:"urn:schemas-upnp-org:metadata-1-0/upnp/ class"`
+		Res      struct {
+			ProtocolInfo string `xml:"protocolInfo,attr"`
+			Size         int64  `xml:"size,attr"`
+			Url          string `xml:",chardata"`
+		} `xml:"res"`
+	} `xml:"item"`
+}
+
+func TestDlnaServer_contentDirectoryControl(t *testing.T) {
+	// root with a video, a song, an image and a file which isn't a media
+	_, clean := rootFixture(t, "media", "Films/Iron Man.mkv", "Le Chant.mp3", "sunset.jpg", "notes.txt")
+	defer clean()
+
+	server := newDlnaServer("Test Server")
+
+	t.Run("it should list roots in root container", func(t *testing.T) {
+		envelope := browse(t, server, "0", "BrowseDirectChildren", 0, 0)
+		didl := parseDidl(t, envelope.Body.Response.Result)
+
+		assert.Equal(t, "BrowseResponse", envelope.Body.Response.XMLName.Local)
+		assert.Equal(t, 1, envelope.Body.Response.NumberReturned)
+		assert.Equal(t, 1, envelope.Body.Response.TotalMatches)
+		if assert.Len(t, didl.Containers, 1) {
+			assert.Equal(t, "media", didl.Containers[0].Id)
+			assert.Equal(t, "0", didl.Containers[0].ParentId)
+			assert.Equal(t, "media", didl.Containers[0].Title)
+			assert.Equal(t, "object.container.storageFolder", didl.Containers[0].Class)
+		}
+	})
+
+	t.Run("it should describe root container with server name", func(t *testing.T) {
+		didl := parseDidl(t, browse(t, server, "0", "BrowseMetadata", 0, 0).Body.Response.Result)
+
+		if assert.Len(t, didl.Containers, 1) {
+			assert.Equal(t, "0", didl.Containers[0].Id)
+			assert.Equal(t, "-1", didl.Containers[0].ParentId)
+			assert.Equal(t, "Test Server", didl.Containers[0].Title)
+		}
+	})
+
+	t.Run("it should list directories and media, but not other files", func(t *testing.T) {
+		envelope := browse(t, server, "media", "BrowseDirectChildren", 0, 0)
+		didl := parseDidl(t, envelope.Body.Response.Result)
+
+		assert.Equal(t, 3, envelope.Body.Response.TotalMatches)
+		if assert.Len(t, didl.Containers, 1) {
+			assert.Equal(t, "media/Films", didl.Containers[0].Id)
+			assert.Equal(t, "media", didl.Containers[0].ParentId)
+		}
+		if assert.Len(t, didl.Items, 2) {
+			assert.Equal(t, "media/Le Chant.mp3", didl.Items[0].Id)
+			assert.Equal(t, "object.item.audioItem.musicTrack", didl.Items[0].Class)
+			assert.Equal(t, "http://pi.local:8080/api/stream/media/Le%20Chant.mp3", didl.Items[0].Res.Url)
+
+			assert.Equal(t, "media/sunset.jpg", didl.Items[1].Id)
+			assert.Equal(t, "object.item.imageItem.photo", didl.Items[1].Class)
+		}
+	})
+
+	t.Run("it should describe a video with its resource", func(t *testing.T) {
+		envelope := browse(t, server, "media/Films/Iron Man.mkv", "BrowseMetadata", 0, 0)
+		didl := parseDidl(t, envelope.Body.Response.Result)
+
+		assert.Equal(t, 1, envelope.Body.Response.NumberReturned)
+		if assert.Len(t, didl.Items, 1) {
+			item := didl.Items[0]
+			assert.Equal(t, "media/Films", item.ParentId)
+			assert.Equal(t, "Iron Man.mkv", item.Title)
+			assert.Equal(t, "object.item.videoItem", item.Class)
+			assert.Equal(t, int64(len("fake movie")), item.Res.Size)
+			assert.True(t, strings.HasPrefix(item.Res.ProtocolInfo, "http-get:*:video/x-matroska:"), item.Res.ProtocolInfo)
+			assert.Equal(t, "http://pi.local:8080/api/stream/media/Films/Iron%20Man.mkv", item.Res.Url)
+		}
+	})
+
+	t.Run("it should page children", func(t *testing.T) {
+		// "Le Chant" is sorted as "Chant", before "Films"
+		envelope := browse(t, server, "media", "BrowseDirectChildren", 0, 1)
+		didl := parseDidl(t, envelope.Body.Response.Result)
+
+		assert.Equal(t, 1, envelope.Body.Response.NumberReturned)
+		assert.Equal(t, 3, envelope.Body.Response.TotalMatches)
+		if assert.Len(t, didl.Items, 1) {
+			assert.Equal(t, "media/Le Chant.mp3", didl.Items[0].Id)
+		}
+	})
+
+	t.Run("it should answer a fault for unknown objects", func(t *testing.T) {
+		envelope := browse(t, server, "unknown/foo", "BrowseDirectChildren", 0, 0)
+		assert.Equal(t, 701, envelope.Body.Fault.ErrorCode)
+	})
+}
+
+func TestSsdpAnnouncer_searchResponses(t *testing.T) {
+	announcer, _ := newSsdpAnnouncer("uuid:1234", 8080)
+	ip := net.ParseIP("192.168.0.11")
+
+	t.Run("it should answer all targets to ssdp:all", func(t *testing.T) {
+		assert.Len(t, announcer.searchResponses("ssdp:all", ip), 5)
+	})
+
+	t.Run("it should answer only requested target", func(t *testing.T) {
+		responses := announcer.searchResponses(contentDirectoryType, ip)
+		if assert.Len(t, responses, 1) {
+			assert.Contains(t, responses[0], "LOCATION: http://192.168.0.11:8080/dlna/device.xml\r\n")
+			assert.Contains(t, responses[0], "USN: uuid:1234::"+contentDirectoryType+"\r\n")
+		}
+	})
+
+	t.Run("it should ignore unknown targets", func(t *testing.T) {
+		assert.Empty(t, announcer.searchResponses("urn:schemas-upnp-org:device:MediaRenderer:1", ip))
+	})
+}
+
+func browse(t *testing.T, server *dlnaServer, objectId string, flag string, start int, count int) browseResponseEnvelope {
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+      <ObjectID>` + objectId + `</ObjectID>
+      <BrowseFlag>` + flag + `</BrowseFlag>
+      <Filter>*</Filter>
+      <StartingIndex>` + strconv.Itoa(start) + `</StartingIndex>
+      <RequestedCount>` + strconv.Itoa(count) + `</RequestedCount>
+      <SortCriteria></SortCriteria>
+    </u:Browse>
+  </s:Body>
+</s:Envelope>`
+
+	request := httptest.NewRequest("POST", "http://pi.local:8080/dlna/control/ContentDirectory", strings.NewReader(body))
+	request.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	recorder := httptest.NewRecorder()
+
+	server.contentDirectoryControl(recorder, request)
+
+	var envelope browseResponseEnvelope
+	if err := xml.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+		t.Fatal("Can't parse SOAP response: ", err, "\n", recorder.Body.String())
+	}
+	if recorder.Code != http.StatusOK && envelope.Body.Fault.ErrorCode == 0 {
+		t.Fatal("Unexpected response ", recorder.Code, ": ", recorder.Body.String())
+	}
+
+	return envelope
+}
+
+func parseDidl(t *testing.T, result string) parsedDidl {
+	var didl parsedDidl
+	if err := xml.Unmarshal([]byte(result), &didl); err != nil {
+		t.Fatal("Can't parse DIDL-Lite document: ", err, "\n", result)
+	}
+	return didl
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+// Machine readable codes of error responses
+const (
+	ErrorInvalidId         = "invalid_id"
+	ErrorInvalidRequest    = "invalid_request"
+	ErrorUnsupported       = "unsupported"
+	ErrorUnauthenticated   = "unauthenticated"
+	ErrorForbidden         = "forbidden"
+	ErrorNotFound          = "not_found"
+	ErrorConflict          = "conflict"
+	ErrorBusy              = "busy"
+	ErrorPlayerUnavailable = "player_unavailable"
+	ErrorInternal          = "internal_error"
+)
+
+// Body of all error responses
+type ErrorDto struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// Path ID which can't be parsed, or would escape its root
+type InvalidIdError struct {
+	Reason string
+}
+
+func (e *InvalidIdError) Error() string {
+	return e.Reason
+}
+
+// Malformed request: body, parameters, ...
+type InvalidRequestError struct {
+	Reason string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return e.Reason
+}
+
+// Operation can't be applied to this kind of file or player
+type UnsupportedError struct {
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return e.Reason
+}
+
+// Request isn't authenticated, or with invalid credentials
+type UnauthenticatedError struct {
+	Reason string
+}
+
+func (e *UnauthenticatedError) Error() string {
+	return e.Reason
+}
+
+// Access denied to the user, or to anyone (outside of roots)
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Reason
+}
+
+// Unknown file, root, collection, profile, ...
+type NotFoundError struct {
+	Reason string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Reason
+}
+
+// Request conflicts with current state (last admin removed, ...)
+type ConflictError struct {
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// Player can't take more commands for now, retrying later should succeed
+type BusyError struct {
+	Reason string
+}
+
+func (e *BusyError) Error() string {
+	return e.Reason
+}
+
+// Player isn't started, or is shutting down
+type PlayerUnavailableError struct {
+	Reason string
+}
+
+func (e *PlayerUnavailableError) Error() string {
+	return e.Reason
+}
+
+// HTTP status and code of an error, 500 for unexpected ones
+func errorStatus(err error) (int, string) {
+	switch err.(type) {
+	case *InvalidIdError:
+		return 400, ErrorInvalidId
+	case *InvalidRequestError, *InvalidCredentialsError, *InvalidCollectionError, *InvalidProfileError, *SearchSyntaxError:
+		return 400, ErrorInvalidRequest
+	case *UnsupportedError, *UnsupportedCommandError:
+		return 400, ErrorUnsupported
+	case *UnauthenticatedError:
+		return 401, ErrorUnauthenticated
+	case *ForbiddenError:
+		return 403, ErrorForbidden
+	case *NotFoundError:
+		return 404, ErrorNotFound
+	case *ConflictError:
+		return 409, ErrorConflict
+	case *BusyError:
+		return 503, ErrorBusy
+	case *PlayerUnavailableError:
+		return 503, ErrorPlayerUnavailable
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		return 404, ErrorNotFound
+	case os.IsPermission(err):
+		return 403, ErrorForbidden
+	}
+	return 500, ErrorInternal
+}
+
+// Reply with the status and code matching the error type
+func failureResponse(r *http.Request, err error, w http.ResponseWriter) {
+	code, errorCode := errorStatus(err)
+	if code >= 500 {
+		glog.Error("Request '", r.URL.Path, "' failed: ", err.Error())
+	} else {
+		glog.Warning("Request '", r.URL.Path, "' rejected (", code, "): ", err.Error())
+	}
+
+	switch err.(type) {
+	case *BusyError, *PlayerUnavailableError:
+		w.Header().Set("Retry-After", "1")
+	case *UnauthenticatedError:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="medima-pi"`)
+	}
+
+	if syntax, ok := err.(*SearchSyntaxError); ok {
+		respondWithJSON(w, code, searchSyntaxDto{Code: errorCode, SearchSyntaxError: syntax})
+		return
+	}
+	respondWithJSON(w, code, ErrorDto{Code: errorCode, Error: err.Error()})
+}
+
+// Invalid search expression, with the offending token
+type searchSyntaxDto struct {
+	Code string `json:"code"`
+	*SearchSyntaxError
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_errorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&InvalidIdError{"invalid path segment '..'"}, 400, ErrorInvalidId},
+		{&InvalidRequestError{"'limit' must be a positive number"}, 400, ErrorInvalidRequest},
+		{&InvalidProfileError{"profile name is required"}, 400, ErrorInvalidRequest},
+		{&SearchSyntaxError{Token: "year:>abc", Position: 11, Reason: "year must have 4 digits"}, 400, ErrorInvalidRequest},
+		{&UnsupportedCommandError{Operation: "position", Player: "mock"}, 400, ErrorUnsupported},
+		{&UnauthenticatedError{"authentication required"}, 401, ErrorUnauthenticated},
+		{&ForbiddenError{"access to root 'unsafe' is forbidden"}, 403, ErrorForbidden},
+		{&NotFoundError{"unknown root: foo"}, 404, ErrorNotFound},
+		{&os.PathError{Op: "stat", Path: "/nowhere", Err: os.ErrNotExist}, 404, ErrorNotFound},
+		{&ConflictError{"last admin can't be removed"}, 409, ErrorConflict},
+		{ErrDispatcherBusy, 503, ErrorBusy},
+		{ErrDispatcherClosed, 503, ErrorPlayerUnavailable},
+		{errors.New("disk is on fire"), 500, ErrorInternal},
+	}
+	for _, tt := range tests {
+		status, code := errorStatus(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+		assert.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
+
+func TestFailureResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	failureResponse(httptest.NewRequest("POST", "/api/player/play", nil), ErrDispatcherBusy, w)
+
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code": "busy", "error": "player is busy: commands queue is full"}`, w.Body.String())
+}
+
+func TestShowMedia_errors(t *testing.T) {
+	_, clean := ignoreFixture(t)
+	defer clean()
+
+	browse := func(url string) (int, ErrorDto) {
+		w := httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", url, nil))
+
+		// a single JSON document must be written
+		var dto ErrorDto
+		decoder := json.NewDecoder(w.Body)
+		assert.NoError(t, decoder.Decode(&dto), url)
+		assert.False(t, decoder.More(), url)
+		return w.Code, dto
+	}
+
+	for url, code := range map[string]string{
+		"/api/browser/unknown/Cars":         ErrorNotFound,
+		"/api/browser/films/Missing.mkv":    ErrorNotFound,
+		"/api/browser/films/Cars/Bonus":     ErrorNotFound,
+		"/api/browser/films/Cars/..":        ErrorInvalidId,
+		"/api/browser/films//Cars":          ErrorInvalidId,
+		"/api/browser/films?sort=color":     ErrorInvalidRequest,
+		"/api/browser/films?offset=-1":      ErrorInvalidRequest,
+		"/api/browser/films/Cars?limit=abc": ErrorInvalidRequest,
+	} {
+		status, dto := browse(url)
+		assert.Equal(t, code, dto.Code, url)
+		assert.NotEmpty(t, dto.Error, url)
+		if code == ErrorNotFound {
+			assert.Equal(t, 404, status, url)
+		} else {
+			assert.Equal(t, 400, status, url)
+		}
+	}
+}
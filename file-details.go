@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Media kinds, from MIME type
+const (
+	KindVideo    = "video"
+	KindAudio    = "audio"
+	KindImage    = "image"
+	KindSubtitle = "subtitle"
+	KindOther    = "other"
+)
+
+// Subtitles aren't streamed as medias, but are given with them
+var subtitleMimeTypes = map[string]string{
+	"ass": "text/x-ssa",
+	"srt": "application/x-subrip",
+	"ssa": "text/x-ssa",
+	"sub": "text/x-microdvd",
+	"vtt": "text/vtt",
+}
+
+// Optional attributes of files, requested with 'fields'
+type fileFields struct {
+	size       bool
+	modified   bool
+	created    bool
+	mime       bool
+	kind       bool
+	childCount bool
+	totalSize  bool
+	// Read beginning of files which extension isn't known to find their MIME type and kind
+	content bool
+}
+
+var (
+	// Attributes given by stats and extension, without any other access to the disk
+	summaryFields = fileFields{size: true, modified: true, mime: true, kind: true}
+	fullFields    = fileFields{true, true, true, true, true, true, true, true}
+)
+
+// Parse 'fields': coma separated list of summary (default), full, none, or attributes names
+func parseFileFields(r *http.Request) (fileFields, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return summaryFields, nil
+	}
+
+	var fields fileFields
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "none":
+		case "summary":
+			fields = fields.with(summaryFields)
+		case "full":
+			fields = fields.with(fullFields)
+		case "size":
+			fields.size = true
+		case "modified":
+			fields.modified = true
+		case "created":
+			fields.created = true
+		case "mime":
+			fields.mime = true
+		case "kind":
+			fields.kind = true
+		case "childCount":
+			fields.childCount = true
+		case "totalSize":
+			fields.totalSize = true
+		case "content":
+			fields.content = true
+		default:
+			return fields, fmt.Errorf("unknown field '%s', expected summary, full, none, size, modified, created, mime, kind, childCount, totalSize or content", name)
+		}
+	}
+	return fields, nil
+}
+
+func (f fileFields) with(other fileFields) fileFields {
+	return fileFields{
+		size:       f.size || other.size,
+		modified:   f.modified || other.modified,
+		created:    f.created || other.created,
+		mime:       f.mime || other.mime,
+		kind:       f.kind || other.kind,
+		childCount: f.childCount || other.childCount,
+		totalSize:  f.totalSize || other.totalSize,
+		content:    f.content || other.content,
+	}
+}
+
+// Set requested attributes of file on its DTO
+func (f fileFields) fill(dto *FileDto, file File) {
+	if file.Path().IsIndex() {
+		return
+	}
+
+	if f.modified {
+		dto.Modified = optionalTime(file.ModTime())
+	}
+	if f.created {
+		dto.Created = optionalTime(file.Created())
+	}
+
+	if dir, ok := file.(*Dir); ok {
+		if f.childCount {
+			count := dir.childCount()
+			dto.ChildCount = &count
+		}
+		if f.totalSize {
+			size := dir.totalSize()
+			dto.TotalSize = &size
+		}
+		return
+	}
+
+	if f.size {
+		size := file.Size()
+		dto.Size = &size
+	}
+	if f.mime || f.kind {
+		mime := fileMimeType(file.Path(), f.content)
+		if f.mime {
+			dto.Mime = mime
+		}
+		if f.kind {
+			dto.Kind = mediaKind(mime)
+		}
+	}
+}
+
+// Nil for zero time, which isn't known
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// MIME type of a media or subtitle from its extension, or from its content when allowed to read it. Empty when unknown.
+func fileMimeType(path *Path, content bool) string {
+	ext := strings.ToLower(path.Ext())
+	if mime := mediaMimeType(ext); mime != "" {
+		return mime
+	}
+	if mime, ok := subtitleMimeTypes[ext]; ok {
+		return mime
+	}
+	if content {
+		return sniffMimeType(path.localPath)
+	}
+	return ""
+}
+
+// MIME type detected from the first bytes of the file, empty when unknown
+func sniffMimeType(localPath string) string {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+
+	mime := http.DetectContentType(buffer[:n])
+	if mime == "application/octet-stream" {
+		return ""
+	}
+	if i := strings.Index(mime, ";"); i > 0 {
+		mime = mime[:i]
+	}
+	return mime
+}
+
+// Kind of media with that MIME type
+func mediaKind(mime string) string {
+	switch {
+	case strings.HasPrefix(mime, "video/"):
+		return KindVideo
+	case strings.HasPrefix(mime, "audio/"):
+		return KindAudio
+	case strings.HasPrefix(mime, "image/"):
+		return KindImage
+	}
+	for _, subtitle := range subtitleMimeTypes {
+		if mime == subtitle {
+			return KindSubtitle
+		}
+	}
+	return KindOther
+}
+
+// Number of children, without ignored files. Children are listed when they aren't loaded.
+func (dir *Dir) childCount() int {
+	if dir.Children != nil {
+		return len(dir.Children)
+	}
+
+	listed := NewDir(dir.path)
+	listed.loadChildren()
+	return len(listed.Children)
+}
+
+// Size of files in the directory and its sub-directories, without ignored files
+func (dir *Dir) totalSize() int64 {
+	var total int64
+	walkDir(dir.path, false, func(_ string, _ string, f os.FileInfo, _ error) error {
+		if f != nil && !f.IsDir() {
+			total += f.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFileFields(t *testing.T) {
+	parse := func(url string) (fileFields, error) {
+		return parseFileFields(httptest.NewRequest("GET", url, nil))
+	}
+
+	fields, err := parse("/api/browser/films")
+	assert.NoError(t, err)
+	assert.Equal(t, summaryFields, fields)
+
+	fields, err = parse("/api/browser/films?fields=full")
+	assert.NoError(t, err)
+	assert.Equal(t, fullFields, fields)
+
+	fields, err = parse("/api/browser/films?fields=none")
+	assert.NoError(t, err)
+	assert.Equal(t, fileFields{}, fields)
+
+	fields, err = parse("/api/browser/films?fields=summary,childCount")
+	assert.NoError(t, err)
+	assert.Equal(t, fileFields{size: true, modified: true, mime: true, kind: true, childCount: true}, fields)
+
+	_, err = parse("/api/browser/films?fields=size,color")
+	assert.Error(t, err)
+}
+
+func Test_fileMimeType(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-details")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "cover"), []byte("\x89PNG\x0D\x0A\x1A\x0A"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes"), []byte{0, 1, 2, 3}, 0644)
+
+	tests := []struct {
+		name    string
+		content bool
+		mime    string
+		kind    string
+	}{
+		{"Cars.MKV", false, "video/x-matroska", KindVideo},
+		{"song.flac", false, "audio/flac", KindAudio},
+		{"poster.jpg", false, "image/jpeg", KindImage},
+		{"Cars.fr.srt", false, "application/x-subrip", KindSubtitle},
+		{"cover", false, "", KindOther},
+		{"cover", true, "image/png", KindImage},
+		{"notes", true, "", KindOther},
+	}
+	for _, tt := range tests {
+		path := Path{Name: tt.name, localPath: filepath.Join(dir, tt.name)}
+		mime := fileMimeType(&path, tt.content)
+		assert.Equal(t, tt.mime, mime, tt.name)
+		assert.Equal(t, tt.kind, mediaKind(mime), tt.name)
+	}
+}
+
+func TestShowMedia_fields(t *testing.T) {
+	_, clean := ignoreFixture(t)
+	defer clean()
+
+	browse := func(url string) FileDto {
+		w := httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, 200, w.Code, url)
+
+		var dto FileDto
+		json.Unmarshal(w.Body.Bytes(), &dto)
+		return dto
+	}
+
+	summary := browse("/api/browser/films/Cars")
+	assert.NotNil(t, summary.Modified)
+	assert.Nil(t, summary.ChildCount)
+	assert.Nil(t, summary.TotalSize)
+	if assert.Len(t, summary.Children, 2) {
+		media := summary.Children[0]
+		assert.Equal(t, "Cars.mkv", media.Name)
+		assert.Equal(t, int64(10), *media.Size)
+		assert.Equal(t, "video/x-matroska", media.Mime)
+		assert.Equal(t, KindVideo, media.Kind)
+		assert.Nil(t, media.Created)
+	}
+
+	full := browse("/api/browser/films/Cars?fields=full")
+	assert.Equal(t, 2, *full.ChildCount)
+	assert.Equal(t, int64(20), *full.TotalSize, "ignored files aren't counted")
+	if assert.Len(t, full.Children, 2) {
+		assert.Equal(t, 1, *full.Children[1].ChildCount)
+		assert.Equal(t, int64(10), *full.Children[1].TotalSize)
+	}
+
+	paginated := browse("/api/browser/films/Cars?fields=full&offset=1&limit=1")
+	assert.Equal(t, 2, *paginated.ChildCount)
+	if assert.Len(t, paginated.Children, 1) {
+		assert.Equal(t, 1, *paginated.Children[0].ChildCount)
+	}
+
+	none := browse("/api/browser/films/Cars?fields=none")
+	if assert.Len(t, none.Children, 2) {
+		assert.Nil(t, none.Children[0].Size)
+		assert.Empty(t, none.Children[0].Kind)
+		assert.Nil(t, none.Children[0].Modified)
+	}
+
+	w := httptest.NewRecorder()
+	ShowMedia(w, httptest.NewRequest("GET", "/api/browser/films?fields=color", nil))
+	assert.Equal(t, 400, w.Code)
+}
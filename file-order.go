@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Orders of browsed directories and search results, given with 'sort' parameter
+const (
+	sortName      = "name"
+	sortNatural   = "natural"
+	sortModified  = "modified"
+	sortSize      = "size"
+	sortRelevance = "relevance"
+)
+
+// Sortable attributes of a file
+type sortKey struct {
+	name    string
+	modTime time.Time
+	size    int64
+	score   float64
+}
+
+func fileSortKey(file File, score float64) sortKey {
+	return sortKey{name: file.Path().Name, modTime: file.ModTime(), size: file.Size(), score: score}
+}
+
+// Tells if a file goes before another
+type fileLess func(a sortKey, b sortKey) bool
+
+// Alphabetical order of configured locale, with numbers compared by value ("Episode 2" is before "Episode 10")
+func nameOrder(a sortKey, b sortKey) bool {
+	return collation.compare(a.name, b.name) < 0
+}
+
+// Orders by sort name, with their default direction: ascending for names, newest, biggest and best first otherwise.
+// Natural order is kept as an alias of name order, which now compares numbers by value.
+var fileOrders = map[string]struct {
+	less       fileLess
+	descending bool
+}{
+	sortName:      {nameOrder, false},
+	sortNatural:   {nameOrder, false},
+	sortModified:  {func(a, b sortKey) bool { return a.modTime.Before(b.modTime) }, true},
+	sortSize:      {func(a, b sortKey) bool { return a.size < b.size }, true},
+	sortRelevance: {func(a, b sortKey) bool { return a.score < b.score }, true},
+}
+
+// Order read from 'sort' and 'order' (asc or desc) parameters. Files which are equal are sorted by name.
+func parseFileOrder(r *http.Request, defaultSort string, allowed ...string) (fileLess, error) {
+	name := r.URL.Query().Get("sort")
+	if name == "" {
+		name = defaultSort
+	}
+	order, ok := fileOrders[name]
+	if !ok || !containsString(allowed, name) {
+		return nil, fmt.Errorf("'sort' must be one of %s", strings.Join(allowed, ", "))
+	}
+
+	descending := order.descending
+	switch r.URL.Query().Get("order") {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		return nil, fmt.Errorf("'order' must be asc or desc")
+	}
+
+	return thenByName(order.less, descending), nil
+}
+
+func thenByName(less fileLess, descending bool) fileLess {
+	return func(a sortKey, b sortKey) bool {
+		switch {
+		case less(a, b):
+			return !descending
+		case less(b, a):
+			return descending
+		default:
+			return nameOrder(a, b)
+		}
+	}
+}
+
+// Compare texts, with sequences of digits compared by value. Result is negative when a is before b.
+func naturalCompare(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			endA, endB := digitsEnd(ra, i), digitsEnd(rb, j)
+			if c := compareNumbers(string(ra[i:endA]), string(rb[j:endB])); c != 0 {
+				return c
+			}
+			i, j = endA, endB
+			continue
+		}
+
+		if ra[i] != rb[j] {
+			if ra[i] < rb[j] {
+				return -1
+			}
+			return 1
+		}
+		i++
+		j++
+	}
+	return (len(ra) - i) - (len(rb) - j)
+}
+
+func digitsEnd(text []rune, start int) int {
+	end := start
+	for end < len(text) && unicode.IsDigit(text[end]) {
+		end++
+	}
+	return end
+}
+
+// Compare numbers of any length, leading zeros ignored
+func compareNumbers(a string, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// Slice of a list requested with 'offset' and 'limit' parameters, limit 0 returns all remaining items
+type page struct {
+	offset int
+	limit  int
+}
+
+func parsePage(r *http.Request) (page, error) {
+	var p page
+	for name, value := range map[string]*int{"offset": &p.offset, "limit": &p.limit} {
+		if text := r.URL.Query().Get(name); text != "" {
+			number, err := strconv.Atoi(text)
+			if err != nil || number < 0 {
+				return p, fmt.Errorf("'%s' must be a positive number", name)
+			}
+			*value = number
+		}
+	}
+	return p, nil
+}
+
+// Bounds of the page in a list of given length
+func (p page) bounds(length int) (int, int) {
+	start, end := p.offset, length
+	if start > length {
+		start = length
+	}
+	if p.limit > 0 && start+p.limit < end {
+		end = start + p.limit
+	}
+	return start, end
+}
+
+// Keep only the requested page, total count is given in X-Total-Count header
+func paginate(w http.ResponseWriter, p page, files []FileDto) []FileDto {
+	start, end := paginateBounds(w, p, len(files))
+	return files[start:end]
+}
+
+// Bounds of the requested page, for lists which are converted once paginated. Total count is given in X-Total-Count header.
+func paginateBounds(w http.ResponseWriter, p page, length int) (int, int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(length))
+	return p.bounds(length)
+}
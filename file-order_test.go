@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_naturalCompare(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"episode 2", "episode 10", -1},
+		{"episode 10", "episode 2", 1},
+		{"episode 02", "episode 2", 0},
+		{"episode 2", "episode 2b", -1},
+		{"cars", "cars 2", -1},
+		{"season 1 episode 12", "season 2 episode 1", -1},
+		{"12345678901234567890", "9", 1},
+	}
+	for _, tt := range tests {
+		got := naturalCompare(tt.a, tt.b)
+		assert.True(t, (got < 0 && tt.want < 0) || (got == 0 && tt.want == 0) || (got > 0 && tt.want > 0),
+			"'%s' vs '%s' = %d", tt.a, tt.b, got)
+	}
+}
+
+func Test_parseFileOrder(t *testing.T) {
+	day := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	keys := []sortKey{
+		{name: "Episode 10.mkv", modTime: day, size: 100, score: 0.5},
+		{name: "episode 2.mkv", modTime: day.Add(time.Hour), size: 300, score: 0.9},
+		{name: "Bonus.mkv", modTime: day, size: 200, score: 0.5},
+	}
+	sorted := func(query string) string {
+		less, err := parseFileOrder(httptest.NewRequest("GET", "/api/search?"+query, nil), sortRelevance,
+			sortRelevance, sortName, sortNatural, sortModified, sortSize)
+		assert.NoError(t, err, query)
+
+		copied := append([]sortKey{}, keys...)
+		sort.Slice(copied, func(i, j int) bool { return less(copied[i], copied[j]) })
+		var names []string
+		for _, k := range copied {
+			names = append(names, strings.TrimSuffix(k.name, ".mkv"))
+		}
+		return strings.Join(names, ", ")
+	}
+
+	assert.Equal(t, "episode 2, Bonus, Episode 10", sorted(""), "best first, then by name")
+	assert.Equal(t, "Bonus, episode 2, Episode 10", sorted("sort=name"))
+	assert.Equal(t, "Bonus, episode 2, Episode 10", sorted("sort=natural"))
+	assert.Equal(t, "episode 2, Bonus, Episode 10", sorted("sort=modified"), "newest first")
+	assert.Equal(t, "Bonus, Episode 10, episode 2", sorted("sort=modified&order=asc"))
+	assert.Equal(t, "Episode 10, Bonus, episode 2", sorted("sort=size&order=asc"))
+
+	_, err := parseFileOrder(httptest.NewRequest("GET", "/api/browser?sort=relevance", nil), sortName, sortName, sortSize)
+	assert.EqualError(t, err, "'sort' must be one of name, size")
+	_, err = parseFileOrder(httptest.NewRequest("GET", "/api/browser?order=up", nil), sortName, sortName, sortSize)
+	assert.Error(t, err)
+}
+
+func Test_page_bounds(t *testing.T) {
+	tests := []struct {
+		page   page
+		length int
+		start  int
+		end    int
+	}{
+		{page{}, 10, 0, 10},
+		{page{offset: 2, limit: 3}, 10, 2, 5},
+		{page{offset: 8, limit: 5}, 10, 8, 10},
+		{page{offset: 12, limit: 5}, 10, 10, 10},
+	}
+	for _, tt := range tests {
+		start, end := tt.page.bounds(tt.length)
+		assert.Equal(t, []int{tt.start, tt.end}, []int{start, end}, "%v on %d items", tt.page, tt.length)
+	}
+
+	_, err := parsePage(httptest.NewRequest("GET", "/api/browser?offset=-1", nil))
+	assert.EqualError(t, err, "'offset' must be a positive number")
+}
+
+func TestShowMedia_paginated(t *testing.T) {
+	dir, clean := rootFixture(t, "films", "Episode 1.mkv", "Episode 2.mkv", "Episode 10.mkv", "Episode 11.mkv")
+	defer clean()
+	os.Chtimes(filepath.Join(dir, "Episode 2.mkv"), time.Now(), time.Now().Add(time.Hour))
+
+	browse := func(query string) ([]string, string) {
+		w := httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", "/api/browser/films?"+query, nil))
+		assert.Equal(t, 200, w.Code, query)
+
+		var dto FileDto
+		json.Unmarshal(w.Body.Bytes(), &dto)
+		var names []string
+		for _, c := range dto.Children {
+			names = append(names, c.Name)
+		}
+		return names, w.Header().Get("X-Total-Count")
+	}
+
+	names, total := browse("sort=natural&offset=1&limit=2")
+	assert.Equal(t, []string{"Episode 2.mkv", "Episode 10.mkv"}, names)
+	assert.Equal(t, "4", total)
+
+	names, _ = browse("sort=modified&limit=1")
+	assert.Equal(t, []string{"Episode 2.mkv"}, names)
+
+	w := httptest.NewRecorder()
+	ShowMedia(w, httptest.NewRequest("GET", "/api/browser/films?sort=relevance", nil))
+	assert.Equal(t, 400, w.Code)
+}
@@ -0,0 +1,18 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Birth time of file, zero time when unknown
+func creationTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Birthtimespec.Unix())
+	}
+	return time.Time{}
+}
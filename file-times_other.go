@@ -0,0 +1,14 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Not implemented, or not given by stat (Linux, ...): creation time is never known
+func creationTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Temporary root, configured as the only one, with given files: names ending with '/' are directories, and '../' goes
+// outside of the root. Returned function removes them, and restores roots, symlink policy and ignore rules.
+func rootFixture(t *testing.T, root string, files ...string) (string, func()) {
+	base, err := ioutil.TempDir("", "medima-"+root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(base, root)
+	os.MkdirAll(dir, 0755)
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if strings.HasSuffix(f, "/") {
+			os.MkdirAll(path, 0755)
+			continue
+		}
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("fake movie"), 0644)
+	}
+
+	previousRoots, previousPolicy, previousIgnores := roots, symlinkPolicy, rootIgnores
+	roots = map[string]Path{root: {Root: root, localPath: dir}}
+	return dir, func() {
+		roots, symlinkPolicy, rootIgnores = previousRoots, previousPolicy, previousIgnores
+		os.RemoveAll(base)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Health statuses, from best to worst
+const (
+	HealthOk       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+const (
+	// Free disk space, in data directory, under which server is degraded
+	healthMinFreeDisk = 100 << 20
+	// A dispatcher executing a command for longer is considered stuck
+	healthMaxCommandDuration = time.Minute
+)
+
+// Free disk space can't be read on this platform
+var errUnsupported = errors.New("unsupported on this platform")
+
+// Result of a single check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Overall status is the worst status of checks
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HTTP code of report: 503 when down, so that load balancers and monitoring react to it
+func (r *HealthReport) Code() int {
+	if r.Status == HealthDown {
+		return 503
+	}
+	return 200
+}
+
+func (r *HealthReport) add(checks ...HealthCheck) {
+	for _, c := range checks {
+		r.Checks = append(r.Checks, c)
+		if healthSeverity(c.Status) > healthSeverity(r.Status) {
+			r.Status = c.Status
+		}
+	}
+}
+
+func healthSeverity(status string) int {
+	switch status {
+	case HealthOk:
+		return 0
+	case HealthDegraded:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Report health of server: roots, players, dispatchers and free disk
+func healthCheck(w http.ResponseWriter, _ *http.Request) {
+	report := checkHealth()
+	if report.Status != HealthOk {
+		glog.Warning("Health is ", report.Status, ": ", report.Checks)
+	}
+	respondWithJSON(w, report.Code(), report)
+}
+
+func checkHealth() *HealthReport {
+	report := &HealthReport{Status: HealthOk}
+	report.add(checkRoots()...)
+	if playerTargets != nil {
+		report.add(checkPlayerBinaries(playerTargets)...)
+		report.add(checkDispatchers(playerTargets)...)
+	}
+	report.add(checkFreeDisk(dataFile("")))
+	return report
+}
+
+// Server can still play: dispatchers are alive. Used for systemd watchdog.
+func isAlive() bool {
+	if playerTargets == nil {
+		return true
+	}
+	report := &HealthReport{Status: HealthOk}
+	report.add(checkDispatchers(playerTargets)...)
+	return report.Status != HealthDown
+}
+
+// Each root must be a readable directory. Server is down when none is.
+func checkRoots() []HealthCheck {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checks := make([]HealthCheck, 0, len(names))
+	failures := 0
+	for _, name := range names {
+		check := HealthCheck{Name: "root:" + name, Status: HealthOk}
+		if err := readableDir(roots[name].localPath); err != nil {
+			check.Status, check.Message = HealthDegraded, err.Error()
+			failures++
+		}
+		checks = append(checks, check)
+	}
+
+	if failures > 0 && failures == len(checks) {
+		for i := range checks {
+			checks[i].Status = HealthDown
+		}
+	}
+	return checks
+}
+
+// Directory exists, can be listed and isn't empty (unmounted mount point). Error doesn't include local path, which
+// isn't public.
+func readableDir(path string) error {
+	dir, err := os.Open(path)
+	if err == nil {
+		_, err = dir.Readdirnames(1)
+		dir.Close()
+	}
+
+	if err == io.EOF {
+		return errors.New("empty directory, not mounted?")
+	} else if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+// Binaries of local players must be installed
+func checkPlayerBinaries(targets *PlayerTargets) []HealthCheck {
+	var checks []HealthCheck
+	checked := make(map[string]bool)
+	for _, name := range targets.names {
+		for _, p := range targets.dispatchers[name].Players {
+			omx, ok := p.(*OmxPlayer)
+			if !ok {
+				continue
+			}
+			for _, binary := range omx.Binaries() {
+				if checked[binary] {
+					continue
+				}
+				checked[binary] = true
+
+				check := HealthCheck{Name: "binary:" + binary, Status: HealthOk}
+				if _, err := exec.LookPath(binary); err != nil {
+					check.Status, check.Message = HealthDegraded, "not found in PATH"
+				}
+				checks = append(checks, check)
+			}
+		}
+	}
+	return checks
+}
+
+// Dispatching goroutines must be running, and not stuck on a command
+func checkDispatchers(targets *PlayerTargets) []HealthCheck {
+	checks := make([]HealthCheck, 0, len(targets.names))
+	for _, name := range targets.names {
+		check := HealthCheck{Name: "dispatcher:" + name, Status: HealthOk}
+
+		running, busy := targets.dispatchers[name].Liveness()
+		if !running {
+			check.Status, check.Message = HealthDown, "not running"
+		} else if busy > healthMaxCommandDuration {
+			check.Status, check.Message = HealthDown, "stuck on a command since "+busy.Truncate(time.Second).String()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkFreeDisk(path string) HealthCheck {
+	check := HealthCheck{Name: "disk", Status: HealthOk}
+
+	free, err := freeDiskSpace(path)
+	if os.IsNotExist(err) {
+		// data directory is created on first write
+		free, err = freeDiskSpace(".")
+	}
+	switch {
+	case err == errUnsupported:
+		check.Message = "free space unknown"
+	case err != nil:
+		check.Status, check.Message = HealthDegraded, err.Error()
+	case free < healthMinFreeDisk:
+		check.Status, check.Message = HealthDegraded, formatBytes(free)+" free"
+	default:
+		check.Message = formatBytes(free) + " free"
+	}
+	return check
+}
+
+func formatBytes(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value, unit := float64(bytes), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + units[unit]
+}
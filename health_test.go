@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_checkRoots(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-health")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "films"), 0755)
+	os.MkdirAll(filepath.Join(dir, "unmounted"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "films", "Cars.mp4"), []byte("fake movie"), 0644)
+
+	roots = map[string]Path{
+		"films":     {Root: "films", localPath: filepath.Join(dir, "films")},
+		"missing":   {Root: "missing", localPath: filepath.Join(dir, "missing")},
+		"unmounted": {Root: "unmounted", localPath: filepath.Join(dir, "unmounted")},
+	}
+	checks := checkRoots()
+	if assert.Len(t, checks, 3) {
+		assert.Equal(t, HealthCheck{Name: "root:films", Status: HealthOk}, checks[0])
+		assert.Equal(t, HealthCheck{Name: "root:missing", Status: HealthDegraded, Message: "no such file or directory"}, checks[1])
+		assert.Equal(t, HealthDegraded, checks[2].Status)
+		assert.Contains(t, checks[2].Message, "not mounted")
+	}
+
+	delete(roots, "films")
+	for _, check := range checkRoots() {
+		assert.Equal(t, HealthDown, check.Status, "no root is available")
+	}
+}
+
+func Test_healthCheck(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-health")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Cars.mp4"), []byte("fake movie"), 0644)
+	roots = map[string]Path{"films": {Root: "films", localPath: dir}}
+
+	omx := NewOmxPlayer("hdmi")
+	omx.command = []string{filepath.Join(dir, "missing-omxplayer")}
+	dispatcher := NewPlayerDispatcher(omx)
+	targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+	targets.add("hdmi", "omx", dispatcher)
+	playerTargets = targets
+	defer func() { playerTargets = nil }()
+
+	health := func() (int, HealthReport) {
+		w := httptest.NewRecorder()
+		healthCheck(w, httptest.NewRequest("GET", "/health", nil))
+
+		var report HealthReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	code, report := health()
+	assert.Equal(t, 503, code)
+	assert.Equal(t, HealthDown, report.Status)
+	assert.Contains(t, report.Checks, HealthCheck{Name: "dispatcher:hdmi", Status: HealthDown, Message: "not running"})
+	assert.Contains(t, report.Checks, HealthCheck{Name: "binary:" + filepath.Join(dir, "missing-omxplayer"), Status: HealthDegraded, Message: "not found in PATH"})
+	assert.False(t, isAlive())
+
+	go dispatcher.StartDispatching()
+	defer dispatcher.StopDispatching()
+	for i := 0; i < 100 && !isAlive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	code, report = health()
+	assert.Equal(t, 200, code)
+	assert.Equal(t, HealthDegraded, report.Status, "omxplayer is still missing")
+	assert.Contains(t, report.Checks, HealthCheck{Name: "dispatcher:hdmi", Status: HealthOk})
+	assert.True(t, isAlive())
+}
+
+func Test_formatBytes(t *testing.T) {
+	assert.Equal(t, "512.0 B", formatBytes(512))
+	assert.Equal(t, "1.5 KB", formatBytes(1536))
+	assert.Equal(t, "2.0 GB", formatBytes(2<<30))
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+// Not implemented: disk check is skipped
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errUnsupported
+}
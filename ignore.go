@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// File with ignore rules (same syntax as .gitignore) applying to its directory and below
+const ignoreFileName = ".mmignore"
+
+// Ignored in all roots: hidden files (including .DS_Store), and files created by systems and NAS
+var defaultIgnorePatterns = []string{".*", "Thumbs.db", "desktop.ini", "@eaDir/", "lost+found/", "$RECYCLE.BIN/", "System Volume Information/"}
+
+// Rules of each root, before .mmignore files. Roots without configuration only have default rules.
+var rootIgnores = map[string]*ignoreRules{}
+
+// Parse ignore patterns per root: coma separated list of root:pattern1+pattern2. Patterns of '*' apply to all roots.
+func ConfigureIgnoreRules(config string) error {
+	patterns := map[string][]string{}
+	for _, entry := range strings.Split(config, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		r := strings.SplitN(entry, ":", 2)
+		if len(r) != 2 || r[1] == "" {
+			return fmt.Errorf("invalid ignore rules '%s', expected root:pattern1+pattern2", entry)
+		}
+		if _, ok := roots[r[0]]; !ok && r[0] != "*" {
+			return fmt.Errorf("invalid ignore rules '%s': unknown root '%s'", entry, r[0])
+		}
+		patterns[r[0]] = append(patterns[r[0]], strings.Split(r[1], "+")...)
+	}
+
+	common := append(append([]string{}, defaultIgnorePatterns...), patterns["*"]...)
+	rootIgnores = map[string]*ignoreRules{}
+	for root := range roots {
+		rootIgnores[root] = newIgnoreRules(append(append([]string{}, common...), patterns[root]...), "")
+	}
+	return nil
+}
+
+func rootIgnoreRules(root string) *ignoreRules {
+	if rules, ok := rootIgnores[root]; ok {
+		return rules
+	}
+	return newIgnoreRules(defaultIgnorePatterns, "")
+}
+
+// Pattern of an ignore file, or of configuration
+type ignoreRule struct {
+	// Directory of the ignore file, relative to root
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// Pattern with a slash is matched from base, otherwise against names at any depth
+	anchored bool
+}
+
+// Rules applying in a directory, the last matching one wins (a negated rule includes again a file)
+type ignoreRules struct {
+	rules []ignoreRule
+}
+
+func newIgnoreRules(lines []string, base string) *ignoreRules {
+	return (&ignoreRules{}).with(lines, base)
+}
+
+// Copy of rules, followed by given ones
+func (r *ignoreRules) with(lines []string, base string) *ignoreRules {
+	rules := &ignoreRules{}
+	if r != nil {
+		rules.rules = append(rules.rules, r.rules...)
+	}
+
+	for _, line := range lines {
+		pattern := strings.TrimRight(line, " \t\r")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\`) {
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if strings.Contains(pattern, "/") {
+			rule.anchored = true
+			pattern = strings.TrimLeft(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+
+		rule.segments = strings.Split(pattern, "/")
+		rules.rules = append(rules.rules, rule)
+	}
+	return rules
+}
+
+// Rules followed by the ones of the ignore file in given directory, if any
+func (r *ignoreRules) withFile(dirLocalPath string, base string) *ignoreRules {
+	file, err := os.Open(filepath.Join(dirLocalPath, ignoreFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Warning("Can't read ignore rules of ", dirLocalPath, ": ", err)
+		}
+		return r
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return r.with(lines, base)
+}
+
+// Test if a file (path relative to root) is ignored
+func (r *ignoreRules) ignored(relative string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range r.rules {
+		if (!rule.dirOnly || isDir) && rule.matches(relative) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (rule *ignoreRule) matches(relative string) bool {
+	if rule.base != "" {
+		if !strings.HasPrefix(relative, rule.base+"/") {
+			return false
+		}
+		relative = relative[len(rule.base)+1:]
+	}
+
+	if !rule.anchored {
+		return matchSegments(rule.segments, []string{path.Base(relative)})
+	}
+	return matchSegments(rule.segments, strings.Split(relative, "/"))
+}
+
+// Match path segments with glob patterns, '**' matching any number of segments
+func matchSegments(patterns []string, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+
+	if patterns[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patterns[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(patterns[0], segments[0])
+	return ok && matchSegments(patterns[1:], segments[1:])
+}
+
+// Rules applying to children of a directory: root rules, then ignore files from root down to the directory
+func dirIgnoreRules(dir Path) *ignoreRules {
+	rootPath := roots[dir.Root].localPath
+	rules := rootIgnoreRules(dir.Root).withFile(rootPath, "")
+
+	relative := ""
+	for _, segment := range strings.Split(joinNotEmpty([]string{dir.MiddlePath, dir.Name}, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		relative = joinNotEmpty([]string{relative, segment}, "/")
+		rules = rules.withFile(filepath.Join(rootPath, filepath.FromSlash(relative)), relative)
+	}
+	return rules
+}
+
+// Test if a path, or one of its parent directories, is ignored
+func isIgnoredPath(p Path) bool {
+	if p.IsIndex() || p.Name == "" {
+		return false
+	}
+
+	rootPath := roots[p.Root].localPath
+	rules := rootIgnoreRules(p.Root).withFile(rootPath, "")
+	segments := strings.Split(joinNotEmpty([]string{p.MiddlePath, p.Name}, "/"), "/")
+
+	relative := ""
+	for i, segment := range segments {
+		relative = joinNotEmpty([]string{relative, segment}, "/")
+		local := filepath.Join(rootPath, filepath.FromSlash(relative))
+
+		isDir := i < len(segments)-1
+		if !isDir {
+			stat, err := os.Stat(local)
+			isDir = err == nil && stat.IsDir()
+		}
+		if rules.ignored(relative, isDir) {
+			return true
+		}
+		rules = rules.withFile(local, relative)
+	}
+	return false
+}
+
+// Walk a root like filepath.Walk, skipping ignored files and directories unless showHidden is set. Relative path is
+// slash separated, empty for the root itself. Links are followed as allowed by symlink policy, each directory being
+// walked once to avoid loops.
+func walkRoot(root string, rootPath string, showHidden bool, walkFn func(local string, relative string, f os.FileInfo, err error) error) error {
+	realRoot, err := filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return walkFn(rootPath, "", nil, err)
+	}
+
+	var rules *ignoreRules
+	if !showHidden {
+		rules = rootIgnoreRules(root).withFile(rootPath, "")
+	}
+	return walkFrom(realRoot, rootPath, "", rules, walkFn)
+}
+
+// Walk a directory as walkRoot does, relative paths being still relative to the root
+func walkDir(dir Path, showHidden bool, walkFn func(local string, relative string, f os.FileInfo, err error) error) error {
+	relative := joinNotEmpty([]string{dir.MiddlePath, dir.Name}, "/")
+	realRoot, err := realRootPath(dir.Root)
+	if err != nil {
+		return walkFn(dir.localPath, relative, nil, err)
+	}
+
+	var rules *ignoreRules
+	if !showHidden {
+		rules = dirIgnoreRules(dir)
+	}
+	return walkFrom(realRoot, dir.localPath, relative, rules, walkFn)
+}
+
+func walkFrom(realRoot string, local string, relative string, rules *ignoreRules, walkFn func(local string, relative string, f os.FileInfo, err error) error) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return walkFn(local, relative, nil, err)
+	}
+
+	w := &rootWalker{realRoot: realRoot, showHidden: rules == nil, walkFn: walkFn, visited: make(map[string]bool)}
+	if err = w.walk(local, relative, info, rules); err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+type rootWalker struct {
+	realRoot   string
+	showHidden bool
+	walkFn     func(local string, relative string, f os.FileInfo, err error) error
+
+	// Real paths of walked directories
+	visited map[string]bool
+}
+
+// Walk a file, and children of a directory. Rules are the ones of the directory, its own ignore file included.
+func (w *rootWalker) walk(local string, relative string, info os.FileInfo, rules *ignoreRules) error {
+	if err := w.walkFn(local, relative, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+
+	real, err := filepath.EvalSymlinks(local)
+	if err != nil {
+		return w.walkFn(local, relative, nil, err)
+	}
+	if w.visited[real] {
+		glog.Warning("Skip ", local, ": directory already walked (symbolic link loop?)")
+		return nil
+	}
+	w.visited[real] = true
+
+	infos, names, err := readDirFollowing(local, w.realRoot)
+	if err != nil {
+		return w.walkFn(local, relative, nil, err)
+	}
+
+	for i, child := range infos {
+		childLocal := filepath.Join(local, names[i])
+		childRelative := joinNotEmpty([]string{relative, names[i]}, "/")
+		if rules.ignored(childRelative, child.IsDir()) {
+			continue
+		}
+
+		childRules := rules
+		if child.IsDir() && !w.showHidden {
+			childRules = rules.withFile(childLocal, childRelative)
+		}
+		err := w.walk(childLocal, childRelative, child, childRules)
+		if err == filepath.SkipDir && child.IsDir() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parentDir(relative string) string {
+	if i := strings.LastIndex(relative, "/"); i >= 0 {
+		return relative[:i]
+	}
+	return ""
+}
+
+// Admins (or anybody when authentication is disabled) can list ignored files with hidden=true
+func showHidden(r *http.Request) bool {
+	if r.URL.Query().Get("hidden") != "true" {
+		return false
+	}
+	principal := currentPrincipal(r)
+	return principal == nil || principal.Role == RoleAdmin
+}
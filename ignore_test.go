@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ignoreRules_ignored(t *testing.T) {
+	rules := newIgnoreRules(defaultIgnorePatterns, "").with([]string{
+		"# samples are never browsed",
+		"*.sample.mkv",
+		"!keep.sample.mkv",
+		"/extras/",
+		"docs/**/*.pdf",
+		"**/subs",
+	}, "")
+
+	tests := []struct {
+		relative string
+		isDir    bool
+		want     bool
+	}{
+		{"Films/.DS_Store", false, true},
+		{".hidden", true, true},
+		{"Films/Thumbs.db", false, true},
+		{"Films/@eaDir", true, true},
+		{"Films/@eaDir", false, false},
+		{"lost+found", true, true},
+		{"Films/Cars.mkv", false, false},
+		{"Films/Cars.sample.mkv", false, true},
+		{"Films/keep.sample.mkv", false, false},
+		{"extras", true, true},
+		{"Films/extras", true, false},
+		{"docs/manual.pdf", false, true},
+		{"docs/a/b/manual.pdf", false, true},
+		{"Films/docs/manual.pdf", false, false},
+		{"Films/Cars/subs", true, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rules.ignored(tt.relative, tt.isDir), tt.relative)
+	}
+
+	var none *ignoreRules
+	assert.False(t, none.ignored(".hidden", false))
+}
+
+func Test_ignoreRules_base(t *testing.T) {
+	rules := newIgnoreRules([]string{"*.nfo", "/Bonus"}, "Films/Cars")
+
+	assert.True(t, rules.ignored("Films/Cars/movie.nfo", false))
+	assert.True(t, rules.ignored("Films/Cars/Extra/movie.nfo", false))
+	assert.False(t, rules.ignored("Films/movie.nfo", false), "rules only apply below their directory")
+	assert.True(t, rules.ignored("Films/Cars/Bonus", true))
+	assert.False(t, rules.ignored("Films/Cars/Extra/Bonus", true), "anchored to their directory")
+}
+
+// Root 'films' with ignored files: hidden, system, per root (*.nfo) and from .mmignore (Bonus)
+func ignoreFixture(t *testing.T) (string, func()) {
+	dir, clean := rootFixture(t, "films", ".git/config", ".DS_Store", "@eaDir/Cars.jpg", "Cars/Cars.mkv", "Cars/Cars.nfo",
+		"Cars/Bonus/Cars.bonus.mkv", "Cars/Making Of/Cars.making.mkv", "Up.mkv")
+	ioutil.WriteFile(filepath.Join(dir, "Cars", ignoreFileName), []byte("Bonus/\n"), 0644)
+
+	assert.NoError(t, ConfigureIgnoreRules("films:*.nfo"))
+	return dir, clean
+}
+
+func Test_walkRoot(t *testing.T) {
+	dir, clean := ignoreFixture(t)
+	defer clean()
+
+	walk := func(showHidden bool) []string {
+		var found []string
+		walkRoot("films", dir, showHidden, func(_ string, relative string, _ os.FileInfo, _ error) error {
+			found = append(found, relative)
+			return nil
+		})
+		sort.Strings(found)
+		return found
+	}
+
+	assert.Equal(t, []string{"", "Cars", "Cars/Cars.mkv", "Cars/Making Of", "Cars/Making Of/Cars.making.mkv", "Up.mkv"}, walk(false))
+	assert.Len(t, walk(true), 15)
+}
+
+func Test_isIgnoredPath(t *testing.T) {
+	_, clean := ignoreFixture(t)
+	defer clean()
+
+	for pathId, want := range map[string]bool{
+		"films":                           false,
+		"films/Cars":                      false,
+		"films/Cars/Cars.nfo":             true,
+		"films/Cars/Bonus":                true,
+		"films/Cars/Bonus/Cars.bonus.mkv": true,
+		"films/.git/config":               true,
+		"films/@eaDir/Cars.jpg":           true,
+	} {
+		path, _ := NewPathFromId(pathId)
+		assert.Equal(t, want, isIgnoredPath(path), pathId)
+	}
+
+	assert.EqualError(t, ConfigureIgnoreRules("music:*.m3u"), "invalid ignore rules 'music:*.m3u': unknown root 'music'")
+}
+
+func TestShowMedia_ignored(t *testing.T) {
+	_, clean := ignoreFixture(t)
+	defer clean()
+
+	browse := func(url string) (int, []string) {
+		w := httptest.NewRecorder()
+		ShowMedia(w, httptest.NewRequest("GET", url, nil))
+
+		var dto FileDto
+		json.Unmarshal(w.Body.Bytes(), &dto)
+		var names []string
+		for _, c := range dto.Children {
+			names = append(names, c.Name)
+		}
+		return w.Code, names
+	}
+
+	code, names := browse("/api/browser/films/Cars")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{"Cars.mkv", "Making Of"}, names)
+
+	code, names = browse("/api/browser/films/Cars?hidden=true")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, []string{".mmignore", "Bonus", "Cars.mkv", "Cars.nfo", "Making Of"}, names)
+
+	code, _ = browse("/api/browser/films/Cars/Bonus")
+	assert.NotEqual(t, 200, code)
+}
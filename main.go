@@ -7,6 +7,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -20,6 +21,20 @@ func main() {
 	flag.StringVar(&mmConfig.www, "www", ".", "the directory to serve files from. Defaults to the current dir")
 	flag.IntVar(&mmConfig.port, "port", 8080, "port on which server is started. Defaults to 8080")
 	flag.StringVar(&mmConfig.roots, "roots", "", "(required) coma separated list of media directories")
+	flag.BoolVar(&mmConfig.tls, "tls", false, "serve HTTPS on -port, with -tls-cert/-tls-key or a self-signed certificate generated in data directory")
+	flag.StringVar(&mmConfig.tlsCert, "tls-cert", "", "PEM certificate file (with intermediates), requires -tls-key")
+	flag.StringVar(&mmConfig.tlsKey, "tls-key", "", "PEM private key file of -tls-cert")
+	flag.IntVar(&mmConfig.httpPort, "http-port", 0, "with -tls, plain HTTP port redirecting to HTTPS (DLNA and streams are served on it as is)")
+	flag.StringVar(&mmConfig.ignore, "ignore", "", "files ignored per root, besides hidden and system files: coma separated list of root:pattern1+pattern2 ('*' for all roots), patterns as in .gitignore. Directories can also have .mmignore files")
+	flag.StringVar(&mmConfig.symlinks, "symlinks", SymlinksInside, "symbolic links followed in roots: 'inside' (targets in the same root), 'all' or 'never'")
+	flag.StringVar(&mmConfig.locale, "locale", defaultLocale, "language used to sort names (fr, en, de, es, it or sv): accented letters and leading articles")
+	flag.StringVar(&mmConfig.data, "data", "/var/lib/medima-pi", "directory where state (schedules, ...) is persisted")
+	flag.BoolVar(&mmConfig.auth, "auth", false, "require authentication (user accounts stored in data directory, read-only API keys) on API")
+	flag.StringVar(&mmConfig.roleRoots, "role-roots", "", "roots visible per role, with -auth: coma separated list of role:root1+root2 ('*' for all). By default adult sees all roots, child and guest (and DLNA clients) none")
+	flag.StringVar(&mmConfig.targets, "targets", "hdmi:omx:hdmi", "coma separated list of playback targets name:kind[:option], first is the default one. Kinds are 'omx' (option is audio output) and 'renderer' (option is AVTransport control URL)")
+	flag.BoolVar(&mmConfig.playerRetry, "player-retry", false, "restart playback once, from last known position, when player crashes")
+	flag.BoolVar(&mmConfig.dlna, "dlna", false, "expose roots as a DLNA media server, announced on local network")
+	flag.StringVar(&mmConfig.dlnaName, "dlna-name", "Medima PI", "name of DLNA media server, as displayed by TVs")
 
 	flag.Parse()
 	if err := mmConfig.IsValid(); err != nil {
@@ -33,25 +48,79 @@ func main() {
 		glog.Fatal("Can not start server: " + err.Error())
 	}
 
+	if err := AuthController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
 	if err := PlayerController(r); err != nil {
 		glog.Fatal("Can not start server: " + err.Error())
 	}
 
+	if err := ScheduleController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
+	if err := ParentalController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
+	if err := ProfilesController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
+	if err := CollectionsController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
 	if err := SearchController(r); err != nil {
 		glog.Fatal("Can not start server: " + err.Error())
 	}
 
+	if err := StreamController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
+	if err := DlnaController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
+	if err := MetricsController(r); err != nil {
+		glog.Fatal("Can not start server: " + err.Error())
+	}
+
 	if err := StaticController(r); err != nil {
 		glog.Fatal("Can not start server: " + err.Error())
 	}
 
+	// No write timeout: streamed media can last hours (and search module is pretty slow!)
 	srv := &http.Server{
-		Handler:      r,
-		Addr:         mmConfig.HostAndPort(),
-		WriteTimeout: 5 * time.Minute, // Search module is pretty slow!
-		ReadTimeout:  15 * time.Second,
+		Handler:     authMiddleware(r),
+		Addr:        mmConfig.HostAndPort(),
+		ReadTimeout: 15 * time.Second,
+	}
+	servers := []*http.Server{srv}
+	serve := []func(net.Listener) error{srv.Serve}
+
+	if mmConfig.tls {
+		config, err := serverTlsConfig(mmConfig.tlsCert, mmConfig.tlsKey)
+		if err != nil {
+			glog.Fatal("Can not start server: " + err.Error())
+		}
+		srv.TLSConfig = config
+		serve[0] = func(l net.Listener) error { return srv.ServeTLS(l, "", "") }
+
+		if mmConfig.httpPort != 0 {
+			redirect := &http.Server{
+				Handler:     httpsRedirect(mmConfig.port, srv.Handler),
+				Addr:        fmt.Sprintf(":%d", mmConfig.httpPort),
+				ReadTimeout: 15 * time.Second,
+			}
+			servers = append(servers, redirect)
+			serve = append(serve, redirect.Serve)
+		}
 	}
-	srv.ListenAndServe()
+
+	serveUntilSignal(servers, serve)
 }
 
 // Serve static files and /heath
@@ -64,10 +133,6 @@ func StaticController(r *mux.Router) error {
 	glog.Info("Static controller loaded")
 	return nil
 }
-// Always answer OK ;)
-func healthCheck(w http.ResponseWriter, _ *http.Request) {
-	fmt.Fprintf(w, "{\"status\": \"%s\"}", "OK")
-}
 
 var delegate http.Handler
 
@@ -110,12 +175,39 @@ type MmConfig struct {
 	port  int
 	roots string
 	www   string
+	data  string
+	auth  bool
+
+	locale   string
+	ignore   string
+	symlinks string
+
+	tls      bool
+	tlsCert  string
+	tlsKey   string
+	httpPort int
+
+	roleRoots string
+
+	targets     string
+	playerRetry bool
+
+	dlna     bool
+	dlnaName string
 }
 
 func (c *MmConfig) IsValid() error {
 	var err error
 	if len(c.roots) == 0 {
 		err = fmt.Errorf("'roots' must be specified (-root=<coma sperated list>)")
+	} else if (c.tlsCert == "") != (c.tlsKey == "") {
+		err = fmt.Errorf("'tls-cert' and 'tls-key' must be specified together")
+	} else if c.tls && c.dlna && c.httpPort == 0 {
+		err = fmt.Errorf("'http-port' must be specified to use DLNA with TLS: TVs only support plain HTTP")
+	} else if !isSymlinkPolicy(c.symlinks) {
+		err = fmt.Errorf("'symlinks' must be inside, all or never")
+	} else {
+		err = validLocale(c.locale)
 	}
 
 	glog.V(1).Infoln("Configuration loaded: ", mmConfig.String())
@@ -124,8 +216,15 @@ func (c *MmConfig) IsValid() error {
 func (c *MmConfig) HostAndPort() string {
 	return fmt.Sprintf(":%d", c.port)
 }
+// Port on which DLNA clients and renderers can reach plain HTTP server, 0 if none
+func (c *MmConfig) PlainPort() int {
+	if c.tls {
+		return c.httpPort
+	}
+	return c.port
+}
 func (c *MmConfig) String() string {
-	return fmt.Sprintf("MmCOnfig[port=%d, www=%s, roots=%s, HostAndPort=%s]", c.port, c.www, c.roots, c.HostAndPort())
+	return fmt.Sprintf("MmCOnfig[port=%d, www=%s, roots=%s, data=%s, HostAndPort=%s, targets=%s, dlna=%t, auth=%t, tls=%t]", c.port, c.www, c.roots, c.data, c.HostAndPort(), c.targets, c.dlna, c.auth, c.tls)
 }
 
 func GetMmConfig() *MmConfig {
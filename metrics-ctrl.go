@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// Metrics updated by instrumented code
+var (
+	httpRequests   = newCounterVec("medima_http_requests_total", "HTTP requests, per route, method and status code.", "route", "method", "code")
+	httpDuration   = newHistogramVec("medima_http_request_duration_seconds", "Time to serve HTTP requests, per route.", durationBuckets, "route")
+	searchDuration = newHistogramVec("medima_search_duration_seconds", "Time to walk roots and load search results.", durationBuckets)
+	searchResults  = newHistogramVec("medima_search_results", "Number of medias returned by searches.", []float64{0, 1, 5, 10, 25, 50, 100, 250, 1000})
+	playerCommands = newCounterVec("medima_player_commands_total", "Player commands executed, per target, operation and state.", "target", "operation", "state")
+)
+
+var processStart = time.Now()
+
+// Expose metrics in Prometheus format on /metrics, which requires authentication like the API when it's enabled.
+// Must be registered after PlayerController, and before StaticController which catches all remaining paths.
+func MetricsController(r *mux.Router) error {
+	glog.V(1).Infoln("Registering Metrics Controller")
+
+	r.Use(metricsMiddleware)
+	for name, d := range playerTargets.dispatchers {
+		d.AddObserver(countCommand(name))
+	}
+
+	r.Methods("GET").Path("/metrics").HandlerFunc(HandleMetrics)
+
+	glog.Info("Metrics controller loaded")
+	return nil
+}
+
+func countCommand(target string) CommandObserver {
+	return func(command PlayerCommand, err error) {
+		playerCommands.Inc(target, command.Operation, commandState(err))
+	}
+}
+
+// Records status code of the response, without hiding the Flusher of streamed responses
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Count and time requests per route template: media paths aren't used as label to keep a bounded number of series
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, code: 200}
+		next.ServeHTTP(recorder, r)
+
+		httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.code))
+		httpDuration.Observe(time.Since(start).Seconds(), route)
+	})
+}
+
+func HandleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	httpRequests.write(w)
+	httpDuration.write(w)
+	searchDuration.write(w)
+	searchResults.write(w)
+	playerCommands.write(w)
+
+	if playerTargets != nil {
+		writePlayerMetrics(w, playerTargets)
+	}
+	writeCacheMetrics(w)
+	writeRuntimeMetrics(w)
+}
+
+// Queue depth, playback state and restarts of each target. Players aren't queried: state is the last known one.
+func writePlayerMetrics(w http.ResponseWriter, targets *PlayerTargets) {
+	var queues, states, restarts []gaugeSample
+	for _, name := range targets.names {
+		d := targets.dispatchers[name]
+		queues = append(queues, gaugeSample{[]string{name}, float64(len(d.commands))})
+
+		current := d.LastState()
+		for _, state := range []string{StateStopped, StatePlaying, StatePaused} {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			states = append(states, gaugeSample{[]string{name, state}, value})
+		}
+
+		for _, p := range d.Players {
+			if omx, ok := p.(*OmxPlayer); ok {
+				restarts = append(restarts, gaugeSample{[]string{name}, float64(omx.Restarts())})
+			}
+		}
+	}
+
+	writeGauge(w, "medima_player_queue_depth", "Commands waiting to be executed, per target.", []string{"target"}, queues...)
+	writeGauge(w, "medima_player_state", "Playback state of each target (1 for current state).", []string{"target", "state"}, states...)
+	writeSamples(w, "medima_player_restarts_total", "Player processes restarted after a crash, per target.", "counter", []string{"target"}, restarts...)
+}
+
+func writeCacheMetrics(w http.ResponseWriter) {
+	var entries []gaugeSample
+	if playerTargets != nil {
+		history := 0
+		for _, d := range playerTargets.dispatchers {
+			history += d.history.size()
+		}
+		entries = append(entries, gaugeSample{[]string{"commands"}, float64(history)})
+	}
+	if parental != nil {
+		entries = append(entries, gaugeSample{[]string{"ratings"}, float64(parental.ratings.size())})
+	}
+	writeGauge(w, "medima_cache_entries", "Entries kept in memory, per cache.", []string{"cache"}, entries...)
+}
+
+func writeRuntimeMetrics(w http.ResponseWriter) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	writeGauge(w, "go_goroutines", "Number of goroutines that currently exist.", nil, gaugeSample{value: float64(runtime.NumGoroutine())})
+	writeGauge(w, "go_info", "Information about the Go environment.", []string{"version"}, gaugeSample{[]string{runtime.Version()}, 1})
+	writeGauge(w, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", nil, gaugeSample{value: float64(stats.Alloc)})
+	writeGauge(w, "go_memstats_sys_bytes", "Number of bytes obtained from system.", nil, gaugeSample{value: float64(stats.Sys)})
+	writeGauge(w, "go_memstats_heap_objects", "Number of allocated objects.", nil, gaugeSample{value: float64(stats.HeapObjects)})
+	writeSamples(w, "go_memstats_gc_total", "Number of completed GC cycles.", "counter", nil, gaugeSample{value: float64(stats.NumGC)})
+	writeSamples(w, "go_memstats_gc_pause_seconds_total", "Total time spent in GC pauses.", "counter", nil, gaugeSample{value: float64(stats.PauseTotalNs) / 1e9})
+	writeGauge(w, "process_start_time_seconds", "Start time of the process since unix epoch in seconds.", nil, gaugeSample{value: float64(processStart.Unix())})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus instrumentation: counters and histograms with labels, exported in text format 0.0.4
+
+// Default histogram buckets, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Monotonic counter, per label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) Add(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[key] += value
+}
+
+func (c *counterVec) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[labelKey(labelValues)]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitLabelKey(key), "", ""), formatValue(c.values[key]))
+	}
+}
+
+// Distribution of observed values, per label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	lock       sync.Mutex
+	histograms map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, histograms: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.histograms[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *histogramVec) Count(labelValues ...string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if hist, ok := h.histograms[labelKey(labelValues)]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.histograms))
+	for key := range h.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hist, values := h.histograms[key], splitLabelKey(key)
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatValue(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values, "", ""), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values, "", ""), hist.count)
+	}
+}
+
+// Value read when metrics are scraped
+type gaugeSample struct {
+	labels []string
+	value  float64
+}
+
+// Write a gauge, with one sample per label values
+func writeGauge(w io.Writer, name string, help string, labels []string, samples ...gaugeSample) {
+	writeSamples(w, name, help, "gauge", labels, samples...)
+}
+
+// Write a metric maintained elsewhere (counter or gauge), with one sample per label values
+func writeSamples(w io.Writer, name string, help string, kind string, labels []string, samples ...gaugeSample) {
+	writeHeader(w, name, help, kind)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.labels, "", ""), formatValue(s.value))
+	}
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Label values are joined with a separator which can't be in HTTP routes nor target names
+const labelSeparator = "\xff"
+
+func labelKey(values []string) string {
+	return strings.Join(values, labelSeparator)
+}
+
+func splitLabelKey(key string) []string {
+	return strings.Split(key, labelSeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Format labels as {name="value",...}, with an extra label (histogram bucket) when extraName isn't empty
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		if i < len(values) {
+			pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_counterVec(t *testing.T) {
+	counter := newCounterVec("test_total", "Test counter.", "route", "code")
+	counter.Inc("/api/browser", "200")
+	counter.Inc("/api/browser", "200")
+	counter.Add(3, `/api/"quoted"`, "404")
+
+	var out bytes.Buffer
+	counter.write(&out)
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{route="/api/\"quoted\"",code="404"} 3
+test_total{route="/api/browser",code="200"} 2
+`, out.String())
+}
+
+func Test_histogramVec(t *testing.T) {
+	histogram := newHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	var out bytes.Buffer
+	histogram.write(&out)
+	assert.Equal(t, `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`, out.String())
+}
+
+func Test_metricsMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(metricsMiddleware)
+	r.Methods("GET").Path("/api/profiles/{id}").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(404)
+	})
+
+	before := httpRequests.Value("/api/profiles/{id}", "GET", "404")
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/profiles/alice", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/profiles/bob", nil))
+
+	assert.Equal(t, before+2, httpRequests.Value("/api/profiles/{id}", "GET", "404"), "route template is used as label")
+	assert.True(t, httpDuration.Count("/api/profiles/{id}") >= 2)
+}
+
+func TestHandleMetrics(t *testing.T) {
+	player := new(MockPlayer)
+	player.On("Accept", mock.Anything).Return(true)
+	player.On("Execute", mock.Anything).Return(nil)
+	player.On("GetStatus").Return(NewPlayerStatus(NewMedia(Path{"", "data", "", "movie.mp4"}), true, NewTimePosition(0, 0, 0, true), NewTimePosition(0, 0, 0, true)))
+
+	d := NewPlayerDispatcher(player)
+	targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+	targets.add("hdmi", "omx", d)
+	d.AddObserver(countCommand("hdmi"))
+	playerTargets = targets
+	defer func() { playerTargets = nil }()
+
+	go d.StartDispatching()
+	defer d.StopDispatching()
+	before := playerCommands.Value("hdmi", "play", CommandSucceeded)
+	play := NewPlayerCommand("play", NewMedia(Path{"", "data", "", "movie.mp4"}))
+	d.Dispatch(play)
+	play.Wait(time.Second)
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		HandleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, w.Header().Get("Content-Type"), "version=0.0.4")
+		return w.Body.String()
+	}
+
+	body := scrape()
+	assert.Contains(t, body, `medima_player_commands_total{target="hdmi",operation="play",state="succeeded"} `)
+	assert.Equal(t, before+1, playerCommands.Value("hdmi", "play", CommandSucceeded))
+	assert.Contains(t, body, `medima_player_queue_depth{target="hdmi"} 0`)
+	assert.Contains(t, body, `medima_player_state{target="hdmi",state="playing"} 1`)
+	assert.Contains(t, body, "# TYPE go_goroutines gauge")
+	player.AssertNotCalled(t, "GetStatus")
+
+	// state read from player by someone else is given
+	d.PlayerStatus()
+	body = scrape()
+	assert.Contains(t, body, `medima_player_state{target="hdmi",state="paused"} 1`)
+	assert.Contains(t, body, `medima_player_state{target="hdmi",state="playing"} 0`)
+	player.AssertNumberOfCalls(t, "GetStatus", 1)
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"strings"
 	"github.com/golang/glog"
 	"os"
 	"sort"
+	"time"
 )
 
 var roots = make(map[string]Path)
@@ -45,7 +45,9 @@ func NewPath(root string, path string, name string) (Path, error) {
 		return empty, nil
 
 	} else if roots[root] == empty {
-		return empty, fmt.Errorf("invalid root: %s", root)
+		return empty, &NotFoundError{"unknown root: " + root}
+	} else if err := checkPathSegments(path, name); err != nil {
+		return empty, err
 	}
 
 	return Path{localPath: joinNotEmpty([]string{roots[root].localPath, path, name}, "/"), Root: root, MiddlePath: path, Name: name}, nil
@@ -84,17 +86,31 @@ func (path *Path) Ext() string {
 	}
 	return strings.ToLower(dots[len(dots)-1])
 }
+
+// Filter on root names, nil accepts all roots
+type RootPredicate func(root string) bool
+
 func (path *Path) ToFile(summarised bool) (File, error) {
+	return path.ToVisibleFile(summarised, nil)
+}
+
+// Same as ToFile, but index only lists roots accepted by visible
+func (path *Path) ToVisibleFile(summarised bool, visible RootPredicate) (File, error) {
 	if path.IsIndex() {
 		// List available "roots"
 		index := NewDir(*path)
-		for _, root := range roots {
-			index.Children = append(index.Children, NewDir(root))
+		for name, root := range roots {
+			if visible == nil || visible(name) {
+				index.Children = append(index.Children, NewDir(root))
+			}
 		}
 
 		return index, nil
 
 	} else {
+		if err := path.checkContainment(); err != nil {
+			return nil, err
+		}
 		stat, err := os.Stat(path.localPath)
 		if err != nil {
 			return nil, err
@@ -103,6 +119,7 @@ func (path *Path) ToFile(summarised bool) (File, error) {
 		if stat.IsDir() {
 			glog.V(1).Infoln("Browse directory ", path.localPath)
 			dir := NewDir(*path)
+			dir.info = stat
 			if !summarised {
 				dir.loadChildren()
 			}
@@ -112,6 +129,7 @@ func (path *Path) ToFile(summarised bool) (File, error) {
 		} else {
 			glog.V(1).Infoln("Get media details: ", path.localPath)
 			media := NewMedia(*path)
+			media.info = stat
 			return media, nil
 		}
 	}
@@ -127,17 +145,41 @@ func (path *Path) RealPath() string {
 
 type File interface {
 	Path() *Path
+	ModTime() time.Time
+	Created() time.Time
+	Size() int64
 
 	IsDir() bool
 	Type() string
 }
 type FileBase struct {
 	path Path
+
+	// Stats, nil for index
+	info os.FileInfo
 }
 
 func (fileBase *FileBase) Path() *Path {
 	return &fileBase.path
 }
+func (fileBase *FileBase) ModTime() time.Time {
+	if fileBase.info == nil {
+		return time.Time{}
+	}
+	return fileBase.info.ModTime()
+}
+func (fileBase *FileBase) Created() time.Time {
+	if fileBase.info == nil {
+		return time.Time{}
+	}
+	return creationTime(fileBase.info)
+}
+func (fileBase *FileBase) Size() int64 {
+	if fileBase.info == nil {
+		return 0
+	}
+	return fileBase.info.Size()
+}
 func (*FileBase) IsDir() bool {
 	return false
 }
@@ -163,33 +205,56 @@ func NewDir(path Path) *Dir {
 	return &Dir{FileBase: FileBase{path: path}}
 }
 
-// Load children into structure
+// Load children into structure, without ignored files
 func (dir *Dir) loadChildren() error {
-	files, err := ioutil.ReadDir(dir.path.localPath)
+	return dir.listChildren(dirIgnoreRules(dir.path))
+}
+
+// Load children into structure, skipping files ignored by rules (all files when nil) and links not allowed by policy
+func (dir *Dir) listChildren(rules *ignoreRules) error {
+	realRoot, err := realRootPath(dir.path.Root)
+	if err != nil {
+		return err
+	}
+	files, names, err := readDirFollowing(dir.path.localPath, realRoot)
 	if err != nil {
 		return err
 	}
 
-	for _, file := range files {
-		filename := file.Name()
+	dir.Children = nil
+	for i, file := range files {
+		filename := names[i]
+		if rules.ignored(joinNotEmpty([]string{dir.path.MiddlePath, dir.path.Name, filename}, "/"), file.IsDir()) {
+			continue
+		}
+
 		path := dir.path
 		newPath := path.Relative(filename)
-		f, err := newPath.ToFile(true)
-		if err != nil {
-			return err
+		if file.IsDir() {
+			child := NewDir(newPath)
+			child.info = file
+			dir.Children = append(dir.Children, child)
+		} else {
+			child := NewMedia(newPath)
+			child.info = file
+			dir.Children = append(dir.Children, child)
 		}
-		dir.Children = append(dir.Children, f)
 	}
 
 	// And sort ny name
-	sort.Sort(&dirSorter{dir.Children})
+	dir.sortChildren(nameOrder)
 
 	return nil
 }
 
+func (dir *Dir) sortChildren(less fileLess) {
+	sort.Sort(&dirSorter{dir.Children, less})
+}
+
 // Utilities to sort files within a Dir
 type dirSorter struct {
 	files []File
+	less  fileLess
 }
 
 // Len is part of sort.Interface.
@@ -204,7 +269,7 @@ func (s *dirSorter) Swap(i, j int) {
 
 // Less is part of sort.Interface.
 func (s *dirSorter) Less(i, j int) bool {
-	return strings.ToLower(s.files[i].Path().Name) < strings.ToLower(s.files[j].Path().Name)
+	return s.less(fileSortKey(s.files[i], 0), fileSortKey(s.files[j], 0))
 }
 
 
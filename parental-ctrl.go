@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+var auditTrail *AuditTrail
+
+// Parental rules, applied on top of roles. Requires authentication, and must be registered after PlayerController.
+func ParentalController(r *mux.Router) error {
+	if authenticator == nil {
+		return nil
+	}
+	glog.V(1).Infoln("Registering Parental Controller")
+
+	auditTrail = NewAuditTrail(dataFile(auditFile))
+
+	var err error
+	if parental, err = NewParentalControl(dataFile(parentalFile), dataFile(parentalUsageFile), auditTrail); err != nil {
+		return err
+	}
+	for name, d := range playerTargets.dispatchers {
+		d.SetGuard(parental.guard(name))
+	}
+	stopMonitor := make(chan bool)
+	go parental.Monitor(playerTargets, parentalMonitorPeriod, stopMonitor)
+	onShutdown("parental control", func() {
+		stopMonitor <- true
+		// count viewing time until now
+		parental.monitor(playerTargets)
+	})
+
+	r.Methods("GET").Path("/api/parental").HandlerFunc(restricted(PermissionManageUsers, HandleParentalRules))
+	r.Methods("PUT").Path("/api/parental/{user}").HandlerFunc(restricted(PermissionManageUsers, HandlePutParentalRules))
+	r.Methods("DELETE").Path("/api/parental/{user}").HandlerFunc(restricted(PermissionManageUsers, HandleDeleteParentalRules))
+	r.Methods("PUT").Path("/api/parental/profiles/{profile}").HandlerFunc(restricted(PermissionManageUsers, HandlePutParentalRules))
+	r.Methods("DELETE").Path("/api/parental/profiles/{profile}").HandlerFunc(restricted(PermissionManageUsers, HandleDeleteParentalRules))
+	r.Methods("GET").Path("/api/audit").HandlerFunc(restricted(PermissionManageUsers, HandleAudit))
+
+	glog.Info("Parental controller loaded")
+	return nil
+}
+
+func HandleParentalRules(w http.ResponseWriter, _ *http.Request) {
+	respondWithJSON(w, 200, parental.List())
+}
+
+// Set rules of a user or a profile from JSON body
+func HandlePutParentalRules(w http.ResponseWriter, r *http.Request) {
+	var rules ParentalRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid parental rules: " + err.Error()}, w)
+		return
+	}
+	if err := rules.validate(); err != nil {
+		failureResponse(r, &InvalidRequestError{err.Error()}, w)
+		return
+	}
+
+	if err := setParentalRules(r, &rules); err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+func HandleDeleteParentalRules(w http.ResponseWriter, r *http.Request) {
+	if err := setParentalRules(r, nil); err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	respondWithJSON(w, 204, nil)
+}
+
+// Set rules of user or profile from URL, or remove them when nil
+func setParentalRules(r *http.Request, rules *ParentalRules) error {
+	if profile, ok := mux.Vars(r)["profile"]; ok {
+		if rules != nil && profiles != nil {
+			if _, found := profiles.Get(profile); !found {
+				return &NotFoundError{"unknown profile: " + profile}
+			}
+		}
+		return parental.SetProfile(profile, rules)
+	}
+	return parental.SetUser(mux.Vars(r)["user"], rules)
+}
+
+// Most recent audit events, newest first. 'limit' defaults to 50.
+func HandleAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		limit = l
+	}
+	respondWithJSON(w, 200, auditTrail.Recent(limit))
+}
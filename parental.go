@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Files, in data directory, where parental rules and daily viewing time are persisted
+const (
+	parentalFile      = "parental.json"
+	parentalUsageFile = "parental-usage.json"
+)
+
+// How often viewing time is counted, and rules checked on running plays
+const parentalMonitorPeriod = 30 * time.Second
+
+// Restrictions applied to a user or a profile
+type ParentalRules struct {
+	// Highest rating allowed, as a minimum age. No rating filter when nil.
+	MaxAge *int `json:"maxAge,omitempty"`
+	// Media without rating are allowed when rating is filtered
+	AllowUnrated bool `json:"allowUnrated"`
+
+	// Periods of the day when playing is allowed. Always allowed when empty.
+	Hours []TimeWindow `json:"hours,omitempty"`
+
+	// Daily viewing time, unlimited when 0
+	DailyMinutes int `json:"dailyMinutes,omitempty"`
+}
+
+// Period of the day, from "HH:MM" to "HH:MM". It spans over midnight when 'to' is before 'from'.
+type TimeWindow struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (w TimeWindow) validate() error {
+	if _, err := parseDayMinutes(w.From); err != nil {
+		return err
+	}
+	_, err := parseDayMinutes(w.To)
+	return err
+}
+
+func (w TimeWindow) Contains(t time.Time) bool {
+	from, _ := parseDayMinutes(w.From)
+	to, _ := parseDayMinutes(w.To)
+	now := t.Hour()*60 + t.Minute()
+
+	if from <= to {
+		return from <= now && now < to
+	}
+	return now >= from || now < to
+}
+
+// Parse "HH:MM" into minutes since midnight
+func parseDayMinutes(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r *ParentalRules) validate() error {
+	for _, w := range r.Hours {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+	if r.DailyMinutes < 0 || (r.MaxAge != nil && *r.MaxAge < 0) {
+		return fmt.Errorf("maxAge and dailyMinutes can't be negative")
+	}
+	return nil
+}
+
+// Playing is allowed at that time
+func (r *ParentalRules) allowedAt(t time.Time) bool {
+	if len(r.Hours) == 0 {
+		return true
+	}
+	for _, w := range r.Hours {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules and viewing time are kept per user and per profile, with these prefixes
+const (
+	parentalUserPrefix    = "user:"
+	parentalProfilePrefix = "profile:"
+)
+
+// Who is watching: rules of both the user and the profile apply
+type parentalViewer struct {
+	User    string
+	Profile string
+}
+
+// Viewer of a request: authenticated user and active profile
+func requestViewer(r *http.Request) parentalViewer {
+	return parentalViewer{User: requestUser(r), Profile: activeProfile(r)}
+}
+
+// Keys of rules applying to the viewer
+func (v parentalViewer) keys() []string {
+	var keys []string
+	if v.User != "" {
+		keys = append(keys, parentalUserPrefix+v.User)
+	}
+	if v.Profile != "" {
+		keys = append(keys, parentalProfilePrefix+v.Profile)
+	}
+	return keys
+}
+
+// Viewing time of a user or profile, for a day
+type parentalUsage struct {
+	Day     string `json:"day"`
+	Seconds int    `json:"seconds"`
+}
+
+// Parental rules DTO, with today's viewing time, built for REST API. Either user or profile is set.
+type ParentalRulesDto struct {
+	User    string `json:"user,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	ParentalRules
+	TodayMinutes int `json:"todayMinutes"`
+}
+
+// Apply parental rules: check commands before players execute them, stop plays out of rules, filter browsed files
+type ParentalControl struct {
+	file      string
+	usageFile string
+	audit     *AuditTrail
+	ratings   *ratingReader
+
+	// Clock, replaced in tests
+	now func() time.Time
+
+	lock sync.Mutex
+	// Rules and viewing time, by key: user or profile prefix, and its name
+	rules map[string]*ParentalRules
+	usage map[string]*parentalUsage
+	// Viewer who started current play, per target
+	viewers map[string]parentalViewer
+	// Last time viewing time has been counted
+	counted time.Time
+}
+
+var parental *ParentalControl
+
+func NewParentalControl(file string, usageFile string, audit *AuditTrail) (*ParentalControl, error) {
+	p := &ParentalControl{
+		file:      file,
+		usageFile: usageFile,
+		audit:     audit,
+		ratings:   newRatingReader(),
+		now:       time.Now,
+		rules:     make(map[string]*ParentalRules),
+		usage:     make(map[string]*parentalUsage),
+		viewers:   make(map[string]parentalViewer),
+	}
+
+	if _, err := readJsonFile(file, &p.rules); err != nil {
+		return nil, fmt.Errorf("can't load parental rules from %s: %s", file, err.Error())
+	}
+	if _, err := readJsonFile(usageFile, &p.usage); err != nil {
+		glog.Warning("Can't load viewing time from ", usageFile, ": ", err)
+	}
+	// rules were only set on users before profiles
+	for key, rules := range p.rules {
+		if !strings.HasPrefix(key, parentalUserPrefix) && !strings.HasPrefix(key, parentalProfilePrefix) {
+			delete(p.rules, key)
+			p.rules[parentalUserPrefix+key] = rules
+		}
+	}
+	p.counted = p.now()
+
+	return p, nil
+}
+
+// Guard for a dispatcher: plays must follow the rules of the user and profile requesting it.
+// Play without file, resuming current media, is only checked against allowed hours and daily time.
+// Other commands control current play, which is stopped by Monitor once out of rules.
+func (p *ParentalControl) guard(target string) CommandGuard {
+	return func(command PlayerCommand) error {
+		if command.File == nil && command.Operation != "play" {
+			return nil
+		}
+
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		viewer := parentalViewer{User: command.User, Profile: command.Profile}
+		if err := p.check(viewer.keys(), command.File); err != nil {
+			media := ""
+			if command.File != nil {
+				media = command.File.Path().PathId()
+			}
+			p.audit.Record(AuditEvent{User: command.User, Profile: command.Profile, Action: "play-blocked", Target: target, Media: media, Reason: err.Error()})
+			return err
+		}
+
+		if _, watched := p.viewers[target]; command.File != nil || !watched {
+			p.viewers[target] = viewer
+		}
+		return nil
+	}
+}
+
+// Error when viewer can't play file now, file being nil when resuming. Lock must be held.
+func (p *ParentalControl) check(keys []string, file File) error {
+	now := p.now()
+	for _, key := range keys {
+		rules, ok := p.rules[key]
+		if !ok {
+			continue
+		}
+
+		if file != nil {
+			if err := p.checkRating(rules, file); err != nil {
+				return err
+			}
+		}
+		if !rules.allowedAt(now) {
+			return &ForbiddenError{fmt.Sprintf("playing is not allowed at %s", now.Format("15:04"))}
+		}
+		if rules.DailyMinutes > 0 && p.todaySeconds(key, now) >= rules.DailyMinutes*60 {
+			return &ForbiddenError{fmt.Sprintf("daily viewing time of %d minutes is over", rules.DailyMinutes)}
+		}
+	}
+	return nil
+}
+
+func (p *ParentalControl) checkRating(rules *ParentalRules, file File) error {
+	if rules.MaxAge == nil {
+		return nil
+	}
+
+	age, rated := p.ratings.minimumAge(file)
+	if !rated && !rules.AllowUnrated {
+		return &ForbiddenError{file.Path().DisplayName() + " has no rating"}
+	}
+	if rated && age > *rules.MaxAge {
+		return &ForbiddenError{fmt.Sprintf("%s is rated %d+", file.Path().DisplayName(), age)}
+	}
+	return nil
+}
+
+// Error when viewer can't stream file now: rating, allowed hours and daily viewing time
+func (p *ParentalControl) CheckStream(viewer parentalViewer, file File) error {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.check(viewer.keys(), file); err != nil {
+		p.audit.Record(AuditEvent{User: viewer.User, Profile: viewer.Profile, Action: "stream-blocked", Media: file.Path().PathId(), Reason: err.Error()})
+		return err
+	}
+	return nil
+}
+
+// Error when file must be hidden to viewer (rating)
+func (p *ParentalControl) Visible(viewer parentalViewer, file File) error {
+	if p == nil || file.Path().IsIndex() {
+		return nil
+	}
+
+	for _, key := range viewer.keys() {
+		p.lock.Lock()
+		rules, ok := p.rules[key]
+		p.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+		if err := p.checkRating(rules, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove children which must be hidden to viewer
+func (p *ParentalControl) FilterChildren(viewer parentalViewer, dir *Dir) {
+	if p == nil {
+		return
+	}
+
+	children := dir.Children[:0]
+	for _, c := range dir.Children {
+		if p.Visible(viewer, c) == nil {
+			children = append(children, c)
+		}
+	}
+	dir.Children = children
+}
+
+// Count viewing time and stop plays which are out of rules, until stopped
+func (p *ParentalControl) Monitor(targets *PlayerTargets, period time.Duration, stop chan bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.monitor(targets)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *ParentalControl) monitor(targets *PlayerTargets) {
+	p.lock.Lock()
+	viewers := make(map[string]parentalViewer, len(p.viewers))
+	for target, viewer := range p.viewers {
+		for _, key := range viewer.keys() {
+			if _, ok := p.rules[key]; ok {
+				viewers[target] = viewer
+			}
+		}
+	}
+	p.lock.Unlock()
+
+	// players are queried without lock, they may be slow to answer
+	statuses := make(map[string]PlayerStatus, len(viewers))
+	for target := range viewers {
+		if dispatcher, err := targets.Get(target); err == nil {
+			statuses[target] = dispatcher.PlayerStatus()
+		}
+	}
+
+	p.lock.Lock()
+	now := p.now()
+	elapsed := int(now.Sub(p.counted).Seconds())
+	p.counted = now
+
+	counted := false
+	var stops []string
+	for target, viewer := range viewers {
+		if p.viewers[target] != viewer {
+			// another play has started meanwhile
+			continue
+		}
+		status, known := statuses[target]
+		if !known || !status.Playing {
+			delete(p.viewers, target)
+			continue
+		}
+		if status.Paused {
+			continue
+		}
+
+		reason := ""
+		for _, key := range viewer.keys() {
+			rules, ok := p.rules[key]
+			if !ok {
+				continue
+			}
+
+			usage := p.todayUsage(key, now)
+			usage.Seconds += elapsed
+			counted = true
+
+			if reason != "" {
+				continue
+			}
+			if !rules.allowedAt(now) {
+				reason = fmt.Sprintf("playing is not allowed at %s", now.Format("15:04"))
+			} else if rules.DailyMinutes > 0 && usage.Seconds >= rules.DailyMinutes*60 {
+				reason = fmt.Sprintf("daily viewing time of %d minutes is over", rules.DailyMinutes)
+			}
+		}
+		if reason != "" {
+			media := ""
+			if status.Media != nil {
+				media = status.Media.PathId
+			}
+			p.audit.Record(AuditEvent{User: viewer.User, Profile: viewer.Profile, Action: "play-stopped", Target: target, Media: media, Reason: reason})
+
+			delete(p.viewers, target)
+			stops = append(stops, target)
+		}
+	}
+
+	// copied to be saved without lock
+	var usage map[string]parentalUsage
+	if counted {
+		usage = make(map[string]parentalUsage, len(p.usage))
+		for key, u := range p.usage {
+			usage[key] = *u
+		}
+	}
+	p.lock.Unlock()
+
+	for _, target := range stops {
+		if dispatcher, err := targets.Get(target); err == nil {
+			if err := dispatcher.Dispatch(NewPlayerCommand("stop")); err != nil {
+				glog.Error("Parental control can't stop ", target, ": ", err)
+			}
+		}
+	}
+
+	if usage != nil {
+		if err := writeJsonFile(p.usageFile, usage); err != nil {
+			glog.Error("Can't save viewing time: ", err)
+		}
+	}
+}
+
+// Lock must be held
+func (p *ParentalControl) todayUsage(key string, now time.Time) *parentalUsage {
+	day := now.Format("2006-01-02")
+	usage, ok := p.usage[key]
+	if !ok || usage.Day != day {
+		usage = &parentalUsage{Day: day}
+		p.usage[key] = usage
+	}
+	return usage
+}
+
+// Lock must be held
+func (p *ParentalControl) todaySeconds(key string, now time.Time) int {
+	if usage, ok := p.usage[key]; ok && usage.Day == now.Format("2006-01-02") {
+		return usage.Seconds
+	}
+	return 0
+}
+
+// Rules of all restricted users and profiles
+func (p *ParentalControl) List() []ParentalRulesDto {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.now()
+	var list []ParentalRulesDto
+	for key, rules := range p.rules {
+		dto := ParentalRulesDto{ParentalRules: *rules, TodayMinutes: p.todaySeconds(key, now) / 60}
+		if strings.HasPrefix(key, parentalProfilePrefix) {
+			dto.Profile = strings.TrimPrefix(key, parentalProfilePrefix)
+		} else {
+			dto.User = strings.TrimPrefix(key, parentalUserPrefix)
+		}
+		list = append(list, dto)
+	}
+	return list
+}
+
+// Set rules of a user
+func (p *ParentalControl) SetUser(user string, rules *ParentalRules) error {
+	return p.set(parentalUserPrefix+user, rules)
+}
+
+// Set rules of a profile
+func (p *ParentalControl) SetProfile(profile string, rules *ParentalRules) error {
+	return p.set(parentalProfilePrefix+profile, rules)
+}
+
+// Set rules of a key, or remove them when nil
+func (p *ParentalControl) set(key string, rules *ParentalRules) error {
+	if rules != nil {
+		if err := rules.validate(); err != nil {
+			return err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if rules == nil {
+		delete(p.rules, key)
+	} else {
+		p.rules[key] = rules
+	}
+	return writeJsonFile(p.file, p.rules)
+}
+
+// Read ratings from Kodi like NFO files: <media>.nfo or movie.nfo next to the media, tvshow.nfo in parent directories
+type ratingReader struct {
+	lock  sync.Mutex
+	cache map[string]cachedRating
+}
+
+type cachedRating struct {
+	modTime time.Time
+	age     int
+	rated   bool
+}
+
+var nfoRatingPattern = regexp.MustCompile(`(?is)<(mpaa|certification)>(.*?)</(mpaa|certification)>`)
+
+func newRatingReader() *ratingReader {
+	return &ratingReader{cache: make(map[string]cachedRating)}
+}
+
+// Minimum age of the first NFO found for that file, false when there is no rating
+func (r *ratingReader) minimumAge(file File) (int, bool) {
+	local := file.Path().localPath
+
+	var candidates []string
+	if file.IsDir() {
+		candidates = append(candidates, filepath.Join(local, "movie.nfo"), filepath.Join(local, "tvshow.nfo"))
+	} else {
+		dir := filepath.Dir(local)
+		candidates = append(candidates, strings.TrimSuffix(local, filepath.Ext(local))+".nfo", filepath.Join(dir, "movie.nfo"))
+	}
+
+	// Episodes are rated by their show, up to the root
+	root := roots[file.Path().Root].localPath
+	for dir := filepath.Dir(local); len(dir) >= len(root) && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		candidates = append(candidates, filepath.Join(dir, "tvshow.nfo"))
+	}
+
+	for _, nfo := range candidates {
+		if age, rated, found := r.readNfo(nfo); found {
+			return age, rated
+		}
+	}
+	return 0, false
+}
+
+// Number of NFO files in cache
+func (r *ratingReader) size() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.cache)
+}
+
+// Read rating from NFO file, found is false when the file doesn't exist
+func (r *ratingReader) readNfo(nfo string) (age int, rated bool, found bool) {
+	stat, err := os.Stat(nfo)
+	if err != nil || stat.IsDir() {
+		return 0, false, false
+	}
+
+	r.lock.Lock()
+	cached, ok := r.cache[nfo]
+	r.lock.Unlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.age, cached.rated, true
+	}
+
+	content, err := ioutil.ReadFile(nfo)
+	if err != nil {
+		glog.Warning("Can't read ", nfo, ": ", err)
+		return 0, false, false
+	}
+
+	// Keep the most restrictive rating when there are several (one per country)
+	for _, match := range nfoRatingPattern.FindAllStringSubmatch(string(content), -1) {
+		for _, value := range strings.Split(match[2], "/") {
+			if a, ok := parseRatingAge(value); ok && (!rated || a > age) {
+				age, rated = a, true
+			}
+		}
+	}
+
+	r.lock.Lock()
+	r.cache[nfo] = cachedRating{modTime: stat.ModTime(), age: age, rated: rated}
+	r.lock.Unlock()
+
+	return age, rated, true
+}
+
+// Minimum ages of MPAA, TV and French ratings
+var ratingAges = map[string]int{
+	"G": 0, "U": 0, "TP": 0, "TV-Y": 0, "TV-G": 0, "ALL": 0,
+	"TV-Y7": 7,
+	"PG":    10, "TV-PG": 10,
+	"PG-13": 13,
+	"TV-14": 14,
+	"R":     17, "TV-MA": 17,
+	"NC-17": 18, "X": 18,
+}
+
+var ratingAgePattern = regexp.MustCompile(`\d+`)
+
+// Parse a rating such as "Rated PG-13", "FR:12", "-16", "FSK 12" into a minimum age
+func parseRatingAge(rating string) (int, bool) {
+	value := strings.ToUpper(strings.TrimSpace(rating))
+	if i := strings.LastIndex(value, ":"); i >= 0 {
+		value = strings.TrimSpace(value[i+1:])
+	}
+	value = strings.TrimSpace(strings.TrimPrefix(value, "RATED "))
+
+	if age, ok := ratingAges[value]; ok {
+		return age, true
+	}
+	if digits := ratingAgePattern.FindString(value); digits != "" {
+		age, err := strconv.Atoi(digits)
+		return age, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_parseRatingAge(t *testing.T) {
+	tests := []struct {
+		rating string
+		age    int
+		rated  bool
+	}{
+		{"Rated PG-13", 13, true},
+		{"PG", 10, true},
+		{"US:R", 17, true},
+		{"FR:12", 12, true},
+		{"-16", 16, true},
+		{"FSK 6", 6, true},
+		{"TV-MA", 17, true},
+		{"Tous publics", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rating, func(t *testing.T) {
+			age, rated := parseRatingAge(tt.rating)
+			assert.Equal(t, tt.rated, rated)
+			assert.Equal(t, tt.age, age)
+		})
+	}
+}
+
+func TestTimeWindow_Contains(t *testing.T) {
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2018, 10, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	day := TimeWindow{"08:00", "20:30"}
+	assert.True(t, day.Contains(at(8, 0)))
+	assert.True(t, day.Contains(at(20, 29)))
+	assert.False(t, day.Contains(at(20, 30)))
+	assert.False(t, day.Contains(at(7, 59)))
+
+	night := TimeWindow{"22:00", "02:00"}
+	assert.True(t, night.Contains(at(23, 0)))
+	assert.True(t, night.Contains(at(1, 0)))
+	assert.False(t, night.Contains(at(12, 0)))
+
+	assert.Error(t, TimeWindow{"8h", "20:00"}.validate())
+}
+
+func TestParentalControl(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-parental")
+	defer os.RemoveAll(dir)
+
+	media := filepath.Join(dir, "media")
+	os.MkdirAll(filepath.Join(media, "Films", "Alien"), 0755)
+	os.MkdirAll(filepath.Join(media, "Series", "Bluey", "Season 1"), 0755)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Alien", "Alien.mkv"), []byte("fake movie"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Alien", "movie.nfo"), []byte("<movie><mpaa>US:R / FR:12</mpaa></movie>"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Cars.mp4"), []byte("fake movie"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Cars.nfo"), []byte("<movie><certification>G</certification></movie>"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Unknown.mp4"), []byte("fake movie"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Series", "Bluey", "tvshow.nfo"), []byte("<tvshow><mpaa>TV-Y</mpaa></tvshow>"), 0644)
+	ioutil.WriteFile(filepath.Join(media, "Series", "Bluey", "Season 1", "S01E01.mkv"), []byte("fake episode"), 0644)
+	roots = map[string]Path{"media": {Root: "media", localPath: media}}
+
+	file := func(pathId string) File {
+		path, _ := NewPathFromId(pathId)
+		f, err := path.ToFile(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	audit := NewAuditTrail(filepath.Join(dir, "audit.log"))
+	p, err := NewParentalControl(filepath.Join(dir, "parental.json"), filepath.Join(dir, "usage.json"), audit)
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Date(2018, 10, 1, 18, 0, 0, 0, time.Local)
+	p.now = func() time.Time { return now }
+
+	maxAge := 10
+	assert.NoError(t, p.SetUser("kid", &ParentalRules{MaxAge: &maxAge, Hours: []TimeWindow{{"08:00", "20:00"}}, DailyMinutes: 60}))
+	assert.NoError(t, p.SetProfile("little-one", &ParentalRules{MaxAge: &maxAge}))
+
+	t.Run("ratings are read from NFO files", func(t *testing.T) {
+		tests := map[string]int{
+			"media/Films/Alien/Alien.mkv":            17,
+			"media/Films/Alien":                      17,
+			"media/Films/Cars.mp4":                   0,
+			"media/Series/Bluey/Season 1/S01E01.mkv": 0,
+		}
+		for pathId, expected := range tests {
+			age, rated := p.ratings.minimumAge(file(pathId))
+			assert.True(t, rated, pathId)
+			assert.Equal(t, expected, age, pathId)
+		}
+
+		_, rated := p.ratings.minimumAge(file("media/Films/Unknown.mp4"))
+		assert.False(t, rated)
+	})
+
+	t.Run("browsing hides media above allowed rating", func(t *testing.T) {
+		films := NewDir(*file("media/Films").Path())
+		films.loadChildren()
+		p.FilterChildren(parentalViewer{User: "kid"}, films)
+
+		var names []string
+		for _, c := range films.Children {
+			names = append(names, c.Path().Name)
+		}
+		assert.Equal(t, []string{"Cars.mp4", "Cars.nfo"}, names)
+
+		assert.Error(t, p.Visible(parentalViewer{User: "kid"}, file("media/Films/Alien/Alien.mkv")))
+		assert.NoError(t, p.Visible(parentalViewer{User: "parent"}, file("media/Films/Alien/Alien.mkv")))
+		assert.Error(t, p.Visible(parentalViewer{User: "parent", Profile: "little-one"}, file("media/Films/Alien/Alien.mkv")))
+	})
+
+	t.Run("streams follow rules of viewer", func(t *testing.T) {
+		parental = p
+		defer func() { parental = nil }()
+
+		stream := func(user string, pathId string) int {
+			r := httptest.NewRequest("GET", STREAM_PREFIX+"/"+pathId, nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: user, Role: RoleAdult}))
+			w := httptest.NewRecorder()
+			StreamMedia(w, r)
+			return w.Code
+		}
+
+		assert.Equal(t, 403, stream("kid", "media/Films/Alien/Alien.mkv"))
+		assert.Equal(t, 200, stream("parent", "media/Films/Alien/Alien.mkv"))
+		assert.Equal(t, 200, stream("kid", "media/Films/Cars.mp4"))
+
+		now = time.Date(2018, 10, 1, 21, 0, 0, 0, time.Local)
+		assert.Equal(t, 403, stream("kid", "media/Films/Cars.mp4"), "out of allowed hours")
+		now = time.Date(2018, 10, 1, 18, 0, 0, 0, time.Local)
+		assert.Equal(t, "stream-blocked", audit.Recent(1)[0].Action)
+	})
+
+	t.Run("collection items follow rules of viewer", func(t *testing.T) {
+		parental = p
+		defer func() { parental = nil }()
+
+		collection := Collection{Items: []CollectionItem{{PathId: "media/Films/Alien", Dir: true}, {PathId: "media/Films/Cars.mp4"}}}
+		r := httptest.NewRequest("GET", "/api/collections/films", nil)
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: "kid", Role: RoleAdult}))
+
+		items := visibleItems(r, collection).Items
+		if assert.Len(t, items, 1) {
+			assert.Equal(t, "media/Films/Cars.mp4", items[0].PathId)
+		}
+	})
+
+	guard := p.guard("hdmi")
+	play := func(user string, pathId string) error {
+		command := NewPlayerCommand("play", file(pathId))
+		command.User = user
+		return guard(command)
+	}
+
+	t.Run("plays are checked against rules", func(t *testing.T) {
+		assert.NoError(t, play("kid", "media/Films/Cars.mp4"))
+		assert.NoError(t, play("parent", "media/Films/Alien/Alien.mkv"))
+
+		err := play("kid", "media/Films/Alien/Alien.mkv")
+		assert.IsType(t, &ForbiddenError{}, err)
+
+		now = time.Date(2018, 10, 1, 21, 0, 0, 0, time.Local)
+		assert.Error(t, play("kid", "media/Films/Cars.mp4"), "out of allowed hours")
+		resume := NewPlayerCommand("play")
+		resume.User = "kid"
+		assert.Error(t, guard(resume), "resuming out of allowed hours")
+		now = time.Date(2018, 10, 1, 18, 0, 0, 0, time.Local)
+	})
+
+	t.Run("rules of profile apply to any user", func(t *testing.T) {
+		command := NewPlayerCommand("play", file("media/Films/Alien/Alien.mkv"))
+		command.User = "parent"
+		command.Profile = "little-one"
+		assert.IsType(t, &ForbiddenError{}, guard(command))
+		assert.Equal(t, "little-one", audit.Recent(1)[0].Profile)
+	})
+
+	t.Run("blocked plays are audited", func(t *testing.T) {
+		events := audit.Recent(10)
+		if assert.Len(t, events, 6) {
+			assert.Equal(t, "kid", events[3].User)
+			assert.Equal(t, "play-blocked", events[3].Action)
+			assert.Equal(t, "media/Films/Alien/Alien.mkv", events[3].Media)
+			assert.Contains(t, events[3].Reason, "rated 17+")
+		}
+
+		reloaded := NewAuditTrail(filepath.Join(dir, "audit.log"))
+		assert.Len(t, reloaded.Recent(10), 6)
+	})
+
+	t.Run("play is stopped once daily time is over", func(t *testing.T) {
+		player := new(MockPlayer)
+		player.On("GetStatus").Return(NewPlayerStatus(file("media/Films/Cars.mp4"), false, NewTimePosition(0, 0, 0, true), NewTimePosition(0, 0, 0, true)))
+		player.On("Accept", mock.Anything).Return(true)
+		dispatcher := NewPlayerDispatcher(player)
+		dispatcher.setCurrentPlayer(player)
+		targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+		targets.add("hdmi", "omx", dispatcher)
+
+		assert.NoError(t, play("kid", "media/Films/Cars.mp4"))
+
+		now = now.Add(30 * time.Minute)
+		p.counted = now.Add(-30 * time.Minute)
+		p.monitor(targets)
+		assert.Len(t, dispatcher.commands, 0)
+		for _, rules := range p.List() {
+			if rules.User == "kid" {
+				assert.Equal(t, 30, rules.TodayMinutes)
+			}
+		}
+
+		now = now.Add(30 * time.Minute)
+		p.monitor(targets)
+		if assert.Len(t, dispatcher.commands, 1) {
+			assert.Equal(t, "stop", (<-dispatcher.commands).Operation)
+		}
+		assert.Equal(t, "play-stopped", audit.Recent(1)[0].Action)
+
+		assert.Error(t, play("kid", "media/Films/Cars.mp4"), "daily time is over")
+	})
+
+	t.Run("rules set before profiles apply to users", func(t *testing.T) {
+		ioutil.WriteFile(filepath.Join(dir, "previous.json"), []byte(`{"tom": {"allowUnrated": true}}`), 0644)
+		previous, err := NewParentalControl(filepath.Join(dir, "previous.json"), filepath.Join(dir, "usage.json"), audit)
+		assert.NoError(t, err)
+		assert.Equal(t, []ParentalRulesDto{{User: "tom", ParentalRules: ParentalRules{AllowUnrated: true}}}, previous.List())
+	})
+}
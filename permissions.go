@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// User roles
+const (
+	RoleAdmin = "admin"
+	RoleAdult = "adult"
+	RoleChild = "child"
+	RoleGuest = "guest"
+)
+
+// Actions restricted to some roles
+const (
+	// Send commands to players, set sleep timers
+	PermissionPlay = "play"
+	// Create and remove scheduled commands
+	PermissionSchedule = "schedule"
+	// Manage all accounts and API keys
+	PermissionManageUsers = "users"
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin: {PermissionPlay, PermissionSchedule, PermissionManageUsers},
+	RoleAdult: {PermissionPlay, PermissionSchedule},
+	RoleChild: {PermissionPlay},
+	RoleGuest: {},
+}
+
+// Roots visible by each role, nil when all roots are visible
+var roleRoots = map[string]map[string]bool{}
+
+func isRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Parse roots allowed per role: coma separated list of role:root1+root2. '*' allows all roots.
+// By default, admin and adult can see all roots, child and guest can't see any.
+func ConfigureRoleRoots(config string) error {
+	roleRoots = map[string]map[string]bool{
+		RoleAdmin: nil,
+		RoleAdult: nil,
+		RoleChild: {},
+		RoleGuest: {},
+	}
+
+	for _, roleConfig := range strings.Split(config, ",") {
+		if strings.TrimSpace(roleConfig) == "" {
+			continue
+		}
+
+		r := strings.SplitN(strings.TrimSpace(roleConfig), ":", 2)
+		if len(r) != 2 || !isRole(r[0]) {
+			return fmt.Errorf("role roots configuration invalid '%s', it must be role:root1+root2 with role in admin, adult, child, guest", roleConfig)
+		}
+		if r[0] == RoleAdmin {
+			return fmt.Errorf("admin can always see all roots")
+		}
+
+		if r[1] == "*" {
+			roleRoots[r[0]] = nil
+			continue
+		}
+
+		allowed := make(map[string]bool)
+		for _, root := range strings.Split(r[1], "+") {
+			if _, exists := roots[root]; !exists {
+				return fmt.Errorf("role %s is allowed on unknown root: %s", r[0], root)
+			}
+			allowed[root] = true
+		}
+		roleRoots[r[0]] = allowed
+	}
+
+	glog.V(1).Infoln("Roots allowed per role: ", roleRoots)
+	return nil
+}
+
+// Role can see and play media of root
+func roleCanAccess(role string, root string) bool {
+	allowed, configured := roleRoots[role]
+	if !configured {
+		return role == RoleAdmin
+	}
+	return allowed == nil || allowed[root]
+}
+
+// Filter of roots visible by caller. All roots are visible when authentication is disabled.
+func visibleRoots(r *http.Request) RootPredicate {
+	principal := currentPrincipal(r)
+	if principal == nil {
+		return nil
+	}
+
+	return func(root string) bool {
+		return roleCanAccess(principal.Role, root)
+	}
+}
+
+// Filter of roots visible by clients which can't authenticate (DLNA): same as guests
+func anonymousRoots() RootPredicate {
+	if authenticator == nil {
+		return nil
+	}
+
+	return func(root string) bool {
+		return roleCanAccess(RoleGuest, root)
+	}
+}
+
+// Error when caller can't access path root
+func checkRootAccess(r *http.Request, path Path) error {
+	if path.IsIndex() {
+		return nil
+	}
+	if visible := visibleRoots(r); visible != nil && !visible(path.Root) {
+		return &ForbiddenError{"access to root '" + path.Root + "' is forbidden"}
+	}
+	return nil
+}
+
+func hasPermission(r *http.Request, permission string) bool {
+	principal := currentPrincipal(r)
+	if principal == nil {
+		return true
+	}
+
+	for _, p := range rolePermissions[principal.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject request with 403 when caller doesn't have the permission
+func restricted(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r, permission) {
+			failureResponse(r, &ForbiddenError{"permission '" + permission + "' is required"}, w)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureRoleRoots(t *testing.T) {
+	roots = map[string]Path{
+		"kids":   {Root: "kids", localPath: "/mnt/kids"},
+		"data":   {Root: "data", localPath: "/mnt/data"},
+		"unsafe": {Root: "unsafe", localPath: "/mnt/unsafe"},
+	}
+
+	t.Run("by default adults see everything, children and guests nothing", func(t *testing.T) {
+		assert.NoError(t, ConfigureRoleRoots(""))
+
+		assert.True(t, roleCanAccess(RoleAdmin, "unsafe"))
+		assert.True(t, roleCanAccess(RoleAdult, "unsafe"))
+		assert.False(t, roleCanAccess(RoleChild, "kids"))
+		assert.False(t, roleCanAccess(RoleGuest, "kids"))
+		assert.False(t, roleCanAccess("unknown", "kids"))
+	})
+
+	t.Run("allow-lists are configured per role", func(t *testing.T) {
+		assert.NoError(t, ConfigureRoleRoots("adult:kids+data,child:kids,guest:*"))
+
+		assert.True(t, roleCanAccess(RoleAdmin, "unsafe"))
+		assert.True(t, roleCanAccess(RoleAdult, "data"))
+		assert.False(t, roleCanAccess(RoleAdult, "unsafe"))
+		assert.True(t, roleCanAccess(RoleChild, "kids"))
+		assert.False(t, roleCanAccess(RoleChild, "data"))
+		assert.True(t, roleCanAccess(RoleGuest, "unsafe"))
+	})
+
+	for _, invalid := range []string{"child", "teen:kids", "child:missing", "admin:kids"} {
+		t.Run("invalid configuration '"+invalid+"'", func(t *testing.T) {
+			assert.Error(t, ConfigureRoleRoots(invalid))
+		})
+	}
+}
+
+func TestPermissions_enforced(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-roles")
+	defer os.RemoveAll(dir)
+	for _, root := range []string{"kids", "unsafe"} {
+		os.MkdirAll(filepath.Join(dir, root), 0755)
+		ioutil.WriteFile(filepath.Join(dir, root, "movie.mp4"), []byte("fake movie"), 0644)
+	}
+	roots = map[string]Path{
+		"kids":   {Root: "kids", localPath: filepath.Join(dir, "kids")},
+		"unsafe": {Root: "unsafe", localPath: filepath.Join(dir, "unsafe")},
+	}
+	assert.NoError(t, ConfigureRoleRoots("child:kids"))
+
+	call := func(role string, handler http.HandlerFunc, method string, url string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, nil)
+		if role != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: role, Role: role}))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	t.Run("forbidden roots are hidden from index", func(t *testing.T) {
+		var index FileDto
+		w := call(RoleChild, ShowMedia, "GET", BROWSER_PREFIX)
+		assert.Equal(t, 200, w.Code)
+		json.Unmarshal(w.Body.Bytes(), &index)
+		if assert.Len(t, index.Children, 1) {
+			assert.Equal(t, "kids", index.Children[0].PathId)
+		}
+
+		json.Unmarshal(call("", ShowMedia, "GET", BROWSER_PREFIX).Body.Bytes(), &index)
+		assert.Len(t, index.Children, 2, "all roots are visible when authentication is disabled")
+	})
+
+	t.Run("forbidden roots can't be browsed", func(t *testing.T) {
+		assert.Equal(t, 200, call(RoleChild, ShowMedia, "GET", BROWSER_PREFIX+"/kids").Code)
+		assert.Equal(t, 403, call(RoleChild, ShowMedia, "GET", BROWSER_PREFIX+"/unsafe").Code)
+		assert.Equal(t, 403, call(RoleChild, ShowMedia, "GET", BROWSER_PREFIX+"/unsafe/movie.mp4").Code)
+		assert.Equal(t, 200, call(RoleAdult, ShowMedia, "GET", BROWSER_PREFIX+"/unsafe").Code)
+	})
+
+	t.Run("forbidden roots can't be streamed", func(t *testing.T) {
+		assert.Equal(t, 200, call(RoleChild, StreamMedia, "GET", STREAM_PREFIX+"/kids/movie.mp4").Code)
+		assert.Equal(t, 403, call(RoleChild, StreamMedia, "GET", STREAM_PREFIX+"/unsafe/movie.mp4").Code)
+	})
+
+	t.Run("forbidden roots are not searched", func(t *testing.T) {
+		var found []FileDto
+		json.Unmarshal(call(RoleChild, SearchMedia, "GET", "/api/search?pattern=movie").Body.Bytes(), &found)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, "kids/movie.mp4", found[0].PathId)
+		}
+	})
+
+	t.Run("forbidden roots can't be played", func(t *testing.T) {
+		targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+		targets.add("hdmi", "omx", NewPlayerDispatcher())
+		playerTargets = targets
+		defer func() { playerTargets = nil }()
+
+		play := restricted(PermissionPlay, commandHandler("play"))
+		assert.Equal(t, 403, call(RoleChild, play, "POST", "/api/player/play?media=unsafe/movie.mp4").Code)
+		assert.Equal(t, 403, call(RoleGuest, play, "POST", "/api/player/play?media=kids/movie.mp4").Code)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Command lifecycle states, as exposed to API callers
+const (
+	CommandPending     = "pending"
+	CommandSucceeded   = "succeeded"
+	CommandFailed      = "failed"
+	CommandUnsupported = "unsupported"
+	CommandForbidden   = "forbidden"
+)
+
+// Number of completed commands kept by each dispatcher, to be queried later
+const commandHistorySize = 100
+
+var (
+	ErrDispatcherBusy   error = &BusyError{"player is busy: commands queue is full"}
+	ErrDispatcherClosed error = &PlayerUnavailableError{"dispatcher is now closed and do not accept any other command"}
+
+	errDispatcherNotStarted error = &PlayerUnavailableError{"dispatcher is not started"}
+)
+
+// Returned by players when they don't implement requested command
+type UnsupportedCommandError struct {
+	Operation string
+	Player    string
+}
+
+func (e *UnsupportedCommandError) Error() string {
+	return fmt.Sprintf("command %s is not implemented by %s", e.Operation, e.Player)
+}
+
+// Outcome of a command, built for REST API
+type CommandResult struct {
+	Id        string     `json:"id"`
+	Operation string     `json:"operation"`
+	Target    string     `json:"target,omitempty"`
+	User      string     `json:"user,omitempty"`
+	Profile   string     `json:"profile,omitempty"`
+	State     string     `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	Code      string     `json:"code,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Completed *time.Time `json:"completed,omitempty"`
+
+	// HTTP status of the error, when command failed
+	status int
+}
+
+// Result of a command, completed once by the dispatcher
+type commandFuture struct {
+	done chan bool
+
+	lock   sync.Mutex
+	result CommandResult
+}
+
+func newCommandFuture(operation string) *commandFuture {
+	return &commandFuture{
+		done: make(chan bool),
+		result: CommandResult{
+			Id:        newCommandId(),
+			Operation: operation,
+			State:     CommandPending,
+			Submitted: time.Now(),
+		},
+	}
+}
+
+// Set the result from command execution error. Only the first completion is kept.
+func (f *commandFuture) complete(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.result.State != CommandPending {
+		return
+	}
+
+	now := time.Now()
+	f.result.Completed = &now
+	f.result.State = commandState(err)
+	if err != nil {
+		f.result.status, f.result.Code = errorStatus(err)
+		f.result.Error = err.Error()
+	}
+
+	close(f.done)
+}
+
+// Final state of a command executed with that error
+func commandState(err error) string {
+	if err == nil {
+		return CommandSucceeded
+	}
+
+	switch _, code := errorStatus(err); code {
+	case ErrorUnsupported:
+		return CommandUnsupported
+	case ErrorForbidden:
+		return CommandForbidden
+	default:
+		return CommandFailed
+	}
+}
+
+// Record where the command has been dispatched, and on behalf of whom
+func (f *commandFuture) setDispatched(target string, user string, profile string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.result.Target = target
+	f.result.User = user
+	f.result.Profile = profile
+}
+
+// Current result, which can still be pending
+func (f *commandFuture) Result() CommandResult {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.result
+}
+
+// Wait command to be completed, return the result as it is after timeout
+func (f *commandFuture) Wait(timeout time.Duration) CommandResult {
+	select {
+	case <-f.done:
+	case <-time.After(timeout):
+	}
+
+	return f.Result()
+}
+
+// Random identifier, used as correlation ID by API callers
+func newCommandId() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Bounded history of commands, oldest ones are forgotten first
+type commandHistory struct {
+	lock    sync.Mutex
+	ids     []string
+	futures map[string]*commandFuture
+}
+
+func newCommandHistory() *commandHistory {
+	return &commandHistory{futures: make(map[string]*commandFuture)}
+}
+
+func (h *commandHistory) add(future *commandFuture) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	id := future.Result().Id
+	if _, exists := h.futures[id]; exists {
+		return
+	}
+
+	h.ids = append(h.ids, id)
+	h.futures[id] = future
+	if len(h.ids) > commandHistorySize {
+		delete(h.futures, h.ids[0])
+		h.ids = h.ids[1:]
+	}
+}
+
+func (h *commandHistory) size() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.ids)
+}
+
+func (h *commandHistory) get(id string) (CommandResult, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if future, ok := h.futures[id]; ok {
+		return future.Result(), true
+	}
+	return CommandResult{}, false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandFuture_complete(t *testing.T) {
+	t.Run("it should keep only the first completion", func(t *testing.T) {
+		future := newCommandFuture("play")
+		future.complete(nil)
+		future.complete(fmt.Errorf("too late"))
+
+		result := future.Wait(time.Millisecond)
+		assert.Equal(t, CommandSucceeded, result.State)
+		assert.Empty(t, result.Error)
+	})
+
+	t.Run("it should flag commands rejected by parental control", func(t *testing.T) {
+		future := newCommandFuture("play")
+		future.complete(&ForbiddenError{"playing is not allowed at 21:00"})
+
+		result := future.Result()
+		assert.Equal(t, CommandForbidden, result.State)
+		assert.Equal(t, "playing is not allowed at 21:00", result.Error)
+	})
+
+	t.Run("it should return pending result after timeout", func(t *testing.T) {
+		future := newCommandFuture("play")
+
+		result := future.Wait(time.Millisecond)
+		assert.Equal(t, CommandPending, result.State)
+		assert.Nil(t, result.Completed)
+	})
+}
+
+func TestCommandHistory(t *testing.T) {
+	h := newCommandHistory()
+
+	var futures []*commandFuture
+	for i := 0; i < commandHistorySize+1; i++ {
+		future := newCommandFuture("pause")
+		futures = append(futures, future)
+		h.add(future)
+	}
+
+	_, found := h.get(futures[0].Result().Id)
+	assert.False(t, found, "oldest command should have been forgotten")
+
+	_, found = h.get(futures[commandHistorySize].Result().Id)
+	assert.True(t, found, "newest command should be kept")
+}
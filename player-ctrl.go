@@ -4,47 +4,86 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/golang/glog"
 	"net/http"
-	"fmt"
+	"strconv"
+	"sync"
+	"time"
 )
 
-var mainDispatcher *PlayerDispatcher
+var playerTargets *PlayerTargets
+
+// Commands accepted from API callers
+var playerOperations = []string{"play", "pause", "stop", "forward", "backward", "bigForward", "bigBackward"}
 
 func PlayerController(r *mux.Router) error {
 	glog.V(1).Infoln("Registering Player Controller")
 
-	mainDispatcher = NewPlayerDispatcher(NewOmxPlayer())
-	go mainDispatcher.StartDispatching()
+	var err error
+	if playerTargets, err = NewPlayerTargets(GetMmConfig().targets, GetMmConfig().playerRetry); err != nil {
+		return err
+	}
+	playerTargets.StartDispatching()
+	onShutdown("players", func() { playerTargets.Shutdown(dispatcherShutdownTimeout) })
 
 	// explicitly list commands that are accepted
 	r.PathPrefix("/api/player/status").HandlerFunc(HandlePlayerStatus)
-	for _, acceptableCmd := range []string{"play", "pause", "stop", "forward", "backward", "bigForward", "bigBackward"} {
+	r.Methods("GET").PathPrefix("/api/player/targets").HandlerFunc(HandlePlayerTargets)
+	r.Methods("GET").Path("/api/player/commands/{id}").HandlerFunc(HandleCommandResult)
+	for _, acceptableCmd := range playerOperations {
 		r.Methods("POST").
 			PathPrefix("/api/player/" + acceptableCmd).
-			HandlerFunc(commandHandler(mainDispatcher, acceptableCmd))
+			HandlerFunc(restricted(PermissionPlay, commandHandler(acceptableCmd)))
 	}
 
-	glog.Info("Player controller loaded with ", len(mainDispatcher.Players), " players")
+	glog.Info("Player controller loaded with ", len(playerTargets.names), " targets: ", playerTargets.names)
 	return nil
 }
 
-// Ask main dispatcher what is in progress
-func HandlePlayerStatus(w http.ResponseWriter, _ *http.Request) {
-	if mainDispatcher == nil {
-		respondWithJSON(w, 500, map[string]string{"error": "Dispatcher is not started..."})
-	} else {
-		respondWithJSON(w, 200, mainDispatcher.PlayerStatus())
+// Ask target dispatcher what is in progress
+func HandlePlayerStatus(w http.ResponseWriter, r *http.Request) {
+	if playerTargets == nil {
+		failureResponse(r, errDispatcherNotStarted, w)
+		return
+	}
+
+	dispatcher, err := playerTargets.Get(r.URL.Query().Get("target"))
+	if err != nil {
+		failureResponse(r, err, w)
+		return
 	}
+
+	respondWithJSON(w, 200, dispatcher.PlayerStatus())
 }
 
-// Build and dispatch PlayerCommand
-func commandHandler(dispatcher *PlayerDispatcher, commandType string) func(http.ResponseWriter, *http.Request) {
+// List targets and what they are playing
+func HandlePlayerTargets(w http.ResponseWriter, r *http.Request) {
+	if playerTargets == nil {
+		failureResponse(r, errDispatcherNotStarted, w)
+		return
+	}
+
+	respondWithJSON(w, 200, playerTargets.Status())
+}
+
+// Build and dispatch PlayerCommand to requested target
+func commandHandler(commandType string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cmd := NewPlayerCommand(commandType)
+		cmd.User = requestUser(r)
+		cmd.Profile = activeProfile(r)
+
+		dispatcher, err := playerTargets.Get(r.URL.Query().Get("target"))
+		if err != nil {
+			failureResponse(r, err, w)
+			return
+		}
 
 		for k, val := range r.URL.Query() {
 			if k == "media" && len(val) > 0 {
 				// Convert "media" value into File
 				path, err := NewPathFromId(val[0])
+				if err == nil {
+					err = checkRootAccess(r, path)
+				}
 				if err != nil {
 					failureResponse(r, err, w)
 					return
@@ -55,21 +94,83 @@ func commandHandler(dispatcher *PlayerDispatcher, commandType string) func(http.
 					return
 				}
 
-			} else {
+			} else if k != "target" {
 				// Fill extra args...
 				cmd.Args[k] = val
 			}
 		}
 
+		if r.URL.Query().Get("resume") == "true" {
+			resumeFromProfile(&cmd)
+		}
+
 		// and dispatch!
-		dispatcher.commands <- cmd
+		if err := dispatcher.Dispatch(cmd); err != nil {
+			failureResponse(r, err, w)
+			return
+		}
+
+		respondWithCommandResult(w, cmd.Wait(commandResultTimeout))
+	}
+}
+
+// Get result of a previously dispatched command, from its ID
+func HandleCommandResult(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	for _, d := range playerTargets.dispatchers {
+		if result, ok := d.history.get(id); ok {
+			respondWithJSON(w, 200, result)
+			return
+		}
+	}
+
+	failureResponse(r, &NotFoundError{"unknown command: " + id}, w)
+}
+
+// Map command state to HTTP status. Failed commands are answered as other errors, with status of their error.
+func respondWithCommandResult(w http.ResponseWriter, result CommandResult) {
+	switch result.State {
+	case CommandSucceeded:
+		respondWithJSON(w, 201, result)
+	case CommandUnsupported, CommandForbidden, CommandFailed:
+		if result.status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "1")
+		}
+		respondWithJSON(w, result.status, ErrorDto{Code: result.Code, Error: result.Error})
+	default:
+		// Still in progress, caller can poll the result
+		w.Header().Set("Location", "/api/player/commands/"+result.Id)
+		respondWithJSON(w, 202, result)
+	}
+}
+
+func isPlayerOperation(operation string) bool {
+	for _, o := range playerOperations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
 
-		respondWithJSON(w, 201, nil)
+// Position, in seconds, from which a play must start ('from' argument). 0 when not set or invalid.
+func startPosition(command PlayerCommand) int {
+	if from, ok := command.Args["from"]; ok && len(from) > 0 {
+		if seconds, err := strconv.Atoi(from[0]); err == nil && seconds > 0 {
+			return seconds
+		}
 	}
+	return 0
 }
 
+// Time a HTTP caller waits for the command to be executed before getting a 202 (pending) response
+const commandResultTimeout = 2 * time.Second
+
 // Commands received from user
 type PlayerCommand struct {
+	// Correlation ID, to query command result later
+	Id string
+
 	// play, stop, pause (toggle play/pause), forward, backward, position
 	Operation string
 
@@ -78,13 +179,22 @@ type PlayerCommand struct {
 
 	// Optional extra argument
 	Args map[string][]string
+
+	// User who requested the command, empty when authentication is disabled or for internal commands
+	User string
+	// Profile active when the command has been requested, empty for internal commands
+	Profile string
+
+	// Completed once the command has been executed (or rejected)
+	future *commandFuture
 }
 
 // Create a simple command
 // cmd is the name of the command (i.e.: play, stop, ...)
 // args first element can the the File on which executing the command ; then must be odd: [key1, value1, key2, value2]
 func NewPlayerCommand(cmd string, args ...interface{}) PlayerCommand {
-	command := PlayerCommand{Operation: cmd, Args: make(map[string][]string)}
+	future := newCommandFuture(cmd)
+	command := PlayerCommand{Id: future.Result().Id, Operation: cmd, Args: make(map[string][]string), future: future}
 
 	// Parse extra args...
 	if len(args) > 0 {
@@ -106,6 +216,16 @@ func NewPlayerCommand(cmd string, args ...interface{}) PlayerCommand {
 	return command
 }
 
+// Wait command to be executed, return the result as it is after timeout
+func (c *PlayerCommand) Wait(timeout time.Duration) CommandResult {
+	return c.future.Wait(timeout)
+}
+
+// Current command result, can be still pending
+func (c *PlayerCommand) Result() CommandResult {
+	return c.future.Result()
+}
+
 type Player interface {
 	// Can run file with given extension
 	Accept(ext string) bool
@@ -115,8 +235,16 @@ type Player interface {
 	GetStatus() PlayerStatus
 }
 
+type CommandGuard func(command PlayerCommand) error
+type CommandObserver func(command PlayerCommand, err error)
+
 type PlayerDispatcher struct {
+	// Name of the target this dispatcher serves
+	name string
+
 	stopIt chan bool
+	// Signaled once dispatching goroutine has returned
+	stopped chan bool
 
 	// Registered players
 	Players []Player
@@ -124,72 +252,174 @@ type PlayerDispatcher struct {
 	// Commands stack
 	commands chan PlayerCommand
 
+	// Recently dispatched commands, with their result
+	history *commandHistory
+
+	// Optional check of commands before their execution, reject the command when returning an error
+	guard CommandGuard
+	// Notified of each executed command, with its execution error
+	observers []CommandObserver
+
+	// Guard closed, currentPlayer, guard and observers, read from HTTP handlers
+	lock   sync.Mutex
+	closed bool
+	// Dispatching goroutine is running, and executing a command since that time (zero when idle)
+	running   bool
+	busySince time.Time
+
 	// Player currently in use
 	currentPlayer Player
+	// Playback state last read from player, or deduced from executed commands
+	lastState string
 }
 
+// Playback states
+const (
+	StateStopped = "stopped"
+	StatePlaying = "playing"
+	StatePaused  = "paused"
+)
+
 // Create and start the dispatcher
 func NewPlayerDispatcher(players ... Player) *PlayerDispatcher {
 	dispatcher := &PlayerDispatcher{
-		Players:  players,
-		commands: make(chan PlayerCommand, 10),
-		stopIt:   make(chan bool, 1),
+		Players:   players,
+		commands:  make(chan PlayerCommand, 10),
+		history:   newCommandHistory(),
+		stopIt:    make(chan bool, 1),
+		stopped:   make(chan bool, 1),
+		lastState: StateStopped,
 	}
 
 	return dispatcher
 }
 
-// Process asynchronously the command
+// Process asynchronously the command, never blocks: fail when the commands queue is full
 func (d *PlayerDispatcher) Dispatch(command PlayerCommand) error {
-	if d.commands == nil {
-		return fmt.Errorf("dispatcher is now closed and do not accept any other command")
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		command.future.complete(ErrDispatcherClosed)
+		return ErrDispatcherClosed
 	}
+
+	command.future.setDispatched(d.name, command.User, command.Profile)
 	select {
 	case d.commands <- command:
 		// command is stacked...
+		d.history.add(command.future)
 		return nil
 	default:
-		return fmt.Errorf("can't accept command %s: commands chanel already full", command)
+		command.future.complete(ErrDispatcherBusy)
+		return ErrDispatcherBusy
 	}
-
 }
 
 // Start dispatching in current process.
 func (d *PlayerDispatcher) StartDispatching() {
+	d.setBusy(true, false)
+	defer d.setBusy(false, false)
+
 	for {
 		select {
 		case command := <-d.commands:
-			glog.Info("Processing command ", command)
-
-			if command.File != nil {
-				// can start/replace a player
-				previousPlayer := d.currentPlayer
-
-				d.currentPlayer = d.findAppropriatePlayer(command.File)
+			glog.Info("Processing command ", command.Operation, " (", command.Id, ")")
+			d.setBusy(true, true)
+			err := d.execute(command)
+			d.setBusy(true, false)
+			if err == nil {
+				d.commandExecuted(command.Operation)
+			}
+			for _, observer := range d.getObservers() {
+				observer(command, err)
+			}
+			command.future.complete(err)
 
-				if previousPlayer != nil && previousPlayer != d.currentPlayer {
-					glog.Info("STOPPING previous player")
-					if err := previousPlayer.Execute(NewPlayerCommand("stop")); err != nil {
-						glog.Warning("Can't send STOP to running player: ", err)
+		case <-d.stopIt:
+			glog.Info("Stop processing commands as requested.")
+			// and do not accept any other commands
+			d.lock.Lock()
+			d.closed = true
+			d.lock.Unlock()
+
+			for {
+				select {
+				case command := <-d.commands:
+					command.future.complete(ErrDispatcherClosed)
+				default:
+					select {
+					case d.stopped <- true:
+					default:
 					}
+					return
 				}
-
 			}
+		}
+	}
+}
 
-			if d.currentPlayer != nil {
-				if err := d.currentPlayer.Execute(command); err != nil {
-					glog.Error("Player rejected command ", command, ":", err)
-				}
+func (d *PlayerDispatcher) setBusy(running bool, busy bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.running = running
+	if busy {
+		d.busySince = time.Now()
+	} else {
+		d.busySince = time.Time{}
+	}
+}
+
+// Dispatching goroutine is running, and for how long it's executing current command (0 when idle)
+func (d *PlayerDispatcher) Liveness() (bool, time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.busySince.IsZero() {
+		return d.running, 0
+	}
+	return d.running, time.Since(d.busySince)
+}
+
+// Find the right player and execute command with it
+func (d *PlayerDispatcher) execute(command PlayerCommand) error {
+	if guard := d.getGuard(); guard != nil {
+		if err := guard(command); err != nil {
+			glog.Warning("Command ", command.Operation, " (", command.Id, ") rejected: ", err)
+			return err
+		}
+	}
+
+	player := d.getCurrentPlayer()
+	if command.File != nil {
+		// can start/replace a player
+		previousPlayer := player
+
+		player = d.findAppropriatePlayer(command.File)
+		d.setCurrentPlayer(player)
+
+		if previousPlayer != nil && previousPlayer != player {
+			glog.Info("STOPPING previous player")
+			if err := previousPlayer.Execute(NewPlayerCommand("stop")); err != nil {
+				glog.Warning("Can't send STOP to running player: ", err)
 			}
+		}
 
-		case <-d.stopIt:
-			glog.Info("Stop processing commands as requested.")
-			// and do not accept any other commands
-			close(d.commands)
-			d.commands = nil
-			return
+		if player == nil {
+			return &UnsupportedCommandError{Operation: command.Operation, Player: "any player (" + command.File.Path().Name + ")"}
 		}
 	}
+
+	if player == nil {
+		return &ConflictError{"nothing is playing"}
+	}
+
+	if err := player.Execute(command); err != nil {
+		glog.Error("Player rejected command ", command.Operation, " (", command.Id, "): ", err)
+		return err
+	}
+	return nil
 }
 
 // Stop goroutine that dispatch & process commands
@@ -203,6 +433,22 @@ func (d *PlayerDispatcher) StopDispatching() {
 	}
 }
 
+// Stop dispatching, wait for the command being executed, then stop player if it's still playing
+func (d *PlayerDispatcher) Shutdown(timeout time.Duration) {
+	d.StopDispatching()
+	select {
+	case <-d.stopped:
+	case <-time.After(timeout):
+		glog.Warning("Dispatcher ", d.name, " didn't stop in time")
+	}
+
+	if player := d.getCurrentPlayer(); player != nil && player.GetStatus().Playing {
+		if err := player.Execute(NewPlayerCommand("stop")); err != nil {
+			glog.Warning("Can't stop player of ", d.name, ": ", err)
+		}
+	}
+}
+
 // return first player accepting requested type of file
 func (d *PlayerDispatcher) findAppropriatePlayer(file File) Player {
 	ext := file.Path().Ext()
@@ -215,16 +461,106 @@ func (d *PlayerDispatcher) findAppropriatePlayer(file File) Player {
 	return nil
 }
 func (d *PlayerDispatcher) PlayerStatus() PlayerStatus {
-	if d.currentPlayer == nil {
-		return NotPlayingStatus()
+	status := NotPlayingStatus()
+	if player := d.getCurrentPlayer(); player != nil {
+		status = player.GetStatus()
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	switch {
+	case !status.Playing:
+		d.lastState = StateStopped
+	case status.Paused:
+		d.lastState = StatePaused
+	default:
+		d.lastState = StatePlaying
+	}
+	return status
+}
+
+// Playback state as last known, without querying the player: it's updated by executed commands and status reads
+func (d *PlayerDispatcher) LastState() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.lastState
+}
+
+// Deduce playback state from a successful command
+func (d *PlayerDispatcher) commandExecuted(operation string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	switch {
+	case operation == "play":
+		d.lastState = StatePlaying
+	case operation == "stop":
+		d.lastState = StateStopped
+	case operation == "pause" && d.lastState == StatePlaying:
+		d.lastState = StatePaused
+	case operation == "pause" && d.lastState == StatePaused:
+		d.lastState = StatePlaying
 	}
+}
+
+func (d *PlayerDispatcher) getCurrentPlayer() Player {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.currentPlayer
+}
+
+func (d *PlayerDispatcher) setCurrentPlayer(player Player) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
 
-	return d.currentPlayer.GetStatus()
+	d.currentPlayer = player
 }
 
-// Assert if media is playable by main dispatcher
+// Check commands with guard before executing them
+func (d *PlayerDispatcher) SetGuard(guard CommandGuard) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.guard = guard
+}
+
+func (d *PlayerDispatcher) getGuard() CommandGuard {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.guard
+}
+
+// Notify observer of all executed commands
+func (d *PlayerDispatcher) AddObserver(observer CommandObserver) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.observers = append(d.observers, observer)
+}
+
+func (d *PlayerDispatcher) getObservers() []CommandObserver {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.observers
+}
+
+// Assert if media is playable by any target
 func IsPlayable(m *Media) bool {
-	return mainDispatcher != nil && mainDispatcher.findAppropriatePlayer(m) != nil
+	if playerTargets == nil {
+		return false
+	}
+
+	for _, d := range playerTargets.dispatchers {
+		if d.findAppropriatePlayer(m) != nil {
+			return true
+		}
+	}
+	return false
 }
 
 
@@ -6,6 +6,10 @@ import (
 	"github.com/stretchr/testify/mock"
 	"time"
 	"fmt"
+	"encoding/json"
+	"net/http/httptest"
+	"github.com/gorilla/mux"
+	"sync"
 )
 
 type MockPlayer struct {
@@ -231,4 +235,190 @@ func TestDispatcher_stopping(t *testing.T) {
 			t.Fatal("TIMEOUT - something went wrong on the path and either messages hasn't been consumed, or something is stuck.")
 		}
 	})
-}
\ No newline at end of file
+}
+func TestDispatcher_commandResults(t *testing.T) {
+	p1 := new(MockPlayer)
+	p1.On("Accept", "mp4").Return(true)
+	p1.On("Accept", mock.Anything).Return(false)
+	p1.On("Execute", mock.MatchedBy(func(c PlayerCommand) bool { return c.Operation == "play" })).Return(nil)
+	p1.On("Execute", mock.MatchedBy(func(c PlayerCommand) bool { return c.Operation == "pause" })).Return(fmt.Errorf("omxplayer is gone"))
+	p1.On("Execute", mock.Anything).Return(&UnsupportedCommandError{Operation: "position", Player: "mock"})
+
+	d := NewPlayerDispatcher(p1)
+	d.name = "hdmi"
+	go d.StartDispatching()
+	defer d.StopDispatching()
+
+	t.Run("it should conflict when nothing is playing", func(t *testing.T) {
+		command := NewPlayerCommand("pause")
+		assert.NoError(t, d.Dispatch(command))
+
+		result := command.Wait(time.Second)
+		assert.Equal(t, CommandFailed, result.State)
+		assert.Equal(t, ErrorConflict, result.Code)
+	})
+
+	tests := []struct {
+		name      string
+		command   PlayerCommand
+		wantState string
+	}{
+		{"it should succeed when player accepts command", NewPlayerCommand("play", NewMedia(Path{"", "data", "", "movie.mp4"})), CommandSucceeded},
+		{"it should fail when player returns an error", NewPlayerCommand("pause"), CommandFailed},
+		{"it should be unsupported when player doesn't implement it", NewPlayerCommand("position", "pos", "1:22:47"), CommandUnsupported},
+		{"it should be unsupported when no player accepts media", NewPlayerCommand("play", NewMedia(Path{"", "data", "", "notes.txt"})), CommandUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, d.Dispatch(tt.command))
+
+			result := tt.command.Wait(time.Second)
+			assert.Equal(t, tt.wantState, result.State, result.Error)
+			assert.Equal(t, "hdmi", result.Target)
+			assert.NotNil(t, result.Completed)
+
+			history, found := d.history.get(tt.command.Id)
+			assert.True(t, found)
+			assert.Equal(t, result, history)
+		})
+	}
+}
+
+func TestDispatcher_overload(t *testing.T) {
+	d := NewPlayerDispatcher(new(MockPlayer))
+
+	t.Run("dispatcher must reject commands without blocking when queue is full", func(t *testing.T) {
+		for i := 0; i < cap(d.commands); i++ {
+			assert.NoError(t, d.Dispatch(NewPlayerCommand("pause")))
+		}
+
+		overflow := NewPlayerCommand("pause")
+		assert.Equal(t, ErrDispatcherBusy, d.Dispatch(overflow))
+		assert.Equal(t, CommandFailed, overflow.Result().State)
+	})
+
+	t.Run("HTTP handler must answer 503 when dispatcher is full", func(t *testing.T) {
+		playerTargets = &PlayerTargets{kinds: map[string]string{}, dispatchers: map[string]*PlayerDispatcher{}}
+		playerTargets.add("hdmi", "mock", d)
+		defer func() { playerTargets = nil }()
+
+		recorder := httptest.NewRecorder()
+		commandHandler("pause")(recorder, httptest.NewRequest("POST", "/api/player/pause", nil))
+
+		assert.Equal(t, 503, recorder.Code)
+		assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	})
+}
+
+func TestCommandHandler_results(t *testing.T) {
+	p1 := new(MockPlayer)
+	p1.On("Accept", mock.Anything).Return(true)
+	p1.On("Execute", mock.Anything).Return(nil)
+
+	d := NewPlayerDispatcher(p1)
+	playerTargets = &PlayerTargets{kinds: map[string]string{}, dispatchers: map[string]*PlayerDispatcher{}}
+	playerTargets.add("hdmi", "mock", d)
+	defer func() { playerTargets = nil }()
+
+	r := mux.NewRouter()
+	r.Methods("POST").PathPrefix("/api/player/play").HandlerFunc(commandHandler("play"))
+	r.Methods("GET").Path("/api/player/commands/{id}").HandlerFunc(HandleCommandResult)
+
+	t.Run("it should answer 202 while command is pending", func(t *testing.T) {
+		roots = map[string]Path{"wd": {Root: "wd", localPath: workingDir()}}
+		recorder := httptest.NewRecorder()
+
+		// dispatcher isn't started yet: command will never complete
+		r.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/player/play?media=wd/model.go", nil))
+
+		var result CommandResult
+		assert.Equal(t, 202, recorder.Code)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+		assert.Equal(t, CommandPending, result.State)
+		assert.Equal(t, "/api/player/commands/"+result.Id, recorder.Header().Get("Location"))
+
+		go d.StartDispatching()
+		defer d.StopDispatching()
+		time.Sleep(10 * time.Millisecond)
+
+		recorder = httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/player/commands/"+result.Id, nil))
+		assert.Equal(t, 200, recorder.Code)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+		assert.Equal(t, CommandSucceeded, result.State)
+	})
+
+	t.Run("it should answer 404 on unknown command", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/player/commands/foobar", nil))
+		assert.Equal(t, 404, recorder.Code)
+	})
+	t.Run("it should answer failed commands with status and code of their error", func(t *testing.T) {
+		future := newCommandFuture("pause")
+		future.complete(&ConflictError{"nothing is playing"})
+
+		recorder := httptest.NewRecorder()
+		respondWithCommandResult(recorder, future.Result())
+
+		var dto ErrorDto
+		assert.Equal(t, 409, recorder.Code)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &dto))
+		assert.Equal(t, ErrorDto{Code: ErrorConflict, Error: "nothing is playing"}, dto)
+	})
+}
+
+// Run with -race: status is read from HTTP handlers while commands are dispatched
+func TestDispatcher_concurrentStatus(t *testing.T) {
+	p1 := new(MockPlayer)
+	p1.On("Accept", "mp3").Return(true)
+	p1.On("Accept", mock.Anything).Return(false)
+	p1.On("Execute", mock.Anything).Return(nil)
+	p1.On("GetStatus").Return(NotPlayingStatus())
+
+	p2 := new(MockPlayer)
+	p2.On("Accept", "mp4").Return(true)
+	p2.On("Accept", mock.Anything).Return(false)
+	p2.On("Execute", mock.Anything).Return(nil)
+	p2.On("GetStatus").Return(NotPlayingStatus())
+
+	d := NewPlayerDispatcher(p1, p2)
+	go d.StartDispatching()
+	defer d.StopDispatching()
+
+	stop := make(chan bool)
+	readers := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					d.PlayerStatus()
+				}
+			}
+		}()
+	}
+
+	var commands []PlayerCommand
+	for i := 0; i < 20; i++ {
+		media := "movie.mp4"
+		if i%2 == 0 {
+			media = "music.mp3"
+		}
+		cmd := NewPlayerCommand("play", NewMedia(Path{"", "data", "", media}))
+		for d.Dispatch(cmd) == ErrDispatcherBusy {
+			time.Sleep(time.Millisecond)
+			cmd = NewPlayerCommand("play", NewMedia(Path{"", "data", "", media}))
+		}
+		commands = append(commands, cmd)
+	}
+
+	for _, cmd := range commands {
+		assert.Equal(t, CommandSucceeded, cmd.Wait(time.Second).State)
+	}
+	close(stop)
+	readers.Wait()
+}
@@ -1,23 +1,53 @@
 package main
 
 import (
-	"os/exec"
 	"strings"
-	"errors"
-	"fmt"
 	"github.com/golang/glog"
 	"io"
 	"bufio"
 	"regexp"
 	"strconv"
+	"sync"
+	"fmt"
+	"time"
 )
 
 type OmxPlayer struct {
-	instance *omxPlaying
+	// Audio output: hdmi, local, both, alsa
+	output string
+
+	// Command used to start omxplayer, and ffmpeg binary (replaced by stubs in tests)
+	command []string
+	ffmpeg  string
+
+	// Restart once the play when omxplayer crashes
+	retry bool
+	// Time given to omxplayer to quit before being killed
+	stopGrace time.Duration
+
+	// Guard fields below, which are updated from process goroutines
+	lock        sync.Mutex
+	instance    *omxPlaying
+	lastFailure *PlayerFailureDto
+	restarts    int
 }
 
-func NewOmxPlayer() *OmxPlayer {
-	return &OmxPlayer{}
+func NewOmxPlayer(output string) *OmxPlayer {
+	return &OmxPlayer{
+		output:    output,
+		command:   []string{"stdbuf", "-oL", "-eL", "omxplayer"},
+		ffmpeg:    "ffmpeg",
+		stopGrace: 5 * time.Second,
+	}
+}
+
+// Executables required to play: command wrapper, omxplayer and ffmpeg
+func (player *OmxPlayer) Binaries() []string {
+	binaries := []string{player.command[0]}
+	if last := player.command[len(player.command)-1]; last != player.command[0] {
+		binaries = append(binaries, last)
+	}
+	return append(binaries, player.ffmpeg)
 }
 
 // Film files are playable
@@ -27,6 +57,9 @@ func (player *OmxPlayer) Accept(ext string) bool {
 }
 
 func (player *OmxPlayer) Execute(command PlayerCommand) error {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
 	// New play, or play of another media
 	playCmd := command.Operation == "play" && command.File != nil && (player.instance == nil || command.File.Path() != player.instance.playing.Path())
 
@@ -37,7 +70,8 @@ func (player *OmxPlayer) Execute(command PlayerCommand) error {
 		switch {
 		case ope == "stop" || playCmd:
 			glog.Info("Stopping ", player.instance.playing.Path().localPath)
-			player.instance.omxExec('q')
+			player.instance.stop(player.stopGrace)
+			player.instance = nil
 
 		case ope == "pause":
 			player.instance.omxExec('p')
@@ -56,66 +90,167 @@ func (player *OmxPlayer) Execute(command PlayerCommand) error {
 			player.instance.omxExec('\033', '[', 'B')
 
 		default:
-			return errors.New(fmt.Sprintf("Command %s is not implemented by OmxPlayer adapter.", command))
+			return &UnsupportedCommandError{Operation: command.Operation, Player: "OmxPlayer adapter"}
+		}
+
+	} else if !playCmd {
+		switch command.Operation {
+		case "pause", "forward", "backward", "bigForward", "bigBackward":
+			return &ConflictError{"nothing is playing"}
 		}
 	}
 
 	if playCmd {
-		file := command.File.Path().localPath
-		glog.Info("Start to play ", file)
-
-		process := exec.Command("stdbuf", "-oL", "-eL", "omxplayer", "-b", "-o", "hdmi", file)
-		reader, _ := process.StdoutPipe()
-		process.Stderr = process.Stdout
-
-		player.instance = &omxPlaying{
-			playing:  command.File,
-			process:  process,
-			position: NewTimePosition(0, 0, 0, false),
-			Length:   NewTimePosition(0, 0, 0, true),
-		}
+		player.lastFailure = nil
+		return player.start(command.File, startPosition(command), false)
+	}
 
-		// Start listening for updates (position in media)
-		current := player.instance
-		go player.instance.readOutput(bufio.NewScanner(reader), func() {
-			glog.Info("Finished to play ", file)
-			if player.instance == current {
-				player.instance = nil
-			}
-		})
-		go player.instance.readMediaLength(file)
-
-		var err error
-		if player.instance.stdin, err = process.StdinPipe(); err != nil {
-			return err
-		}
+	return nil
+}
 
-		if err := process.Start(); err != nil {
-			return err
-		}
+// Start omxplayer on file, from position (in seconds). Lock must be held.
+func (player *OmxPlayer) start(media File, fromSeconds int, retried bool) error {
+	file := media.Path().localPath
+	glog.Info("Start to play ", file)
+
+	args := append(append([]string{}, player.command...), "-b", "-o", player.output)
+	if fromSeconds > 0 {
+		args = append(args, "--pos", fmt.Sprintf("%02d:%02d:%02d", fromSeconds/3600, (fromSeconds%3600)/60, fromSeconds%60))
+	}
+	args = append(args, file)
+
+	process, err := StartProcess(ProcessSpec{Args: args, MergeStderr: true, Stdin: true})
+	if err != nil {
+		return err
 	}
 
+	instance := &omxPlaying{
+		playing:  media,
+		process:  process,
+		stdin:    process.Stdin(),
+		retried:  retried,
+		position: NewTimePosition(fromSeconds/3600, (fromSeconds%3600)/60, fromSeconds%60, false),
+		Length:   NewTimePosition(0, 0, 0, true),
+	}
+	player.instance = instance
+
+	// Start listening for updates (position in media)
+	go instance.readOutput(bufio.NewScanner(process.Output()), func() {
+		player.finished(instance, process.Wait())
+	})
+	go instance.readMediaLength(player.ffmpeg, file)
+
 	return nil
 }
 
+// Forget instance once it's finished, and restart it once if it crashed
+func (player *OmxPlayer) finished(instance *omxPlaying, exit ProcessExit) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	file := instance.playing.Path().localPath
+	if player.instance != instance {
+		// already stopped or replaced
+		glog.Info("Finished to play ", file, " (", exit, ")")
+		return
+	}
+	player.instance = nil
+
+	if !exit.Failed() {
+		glog.Info("Finished to play ", file)
+		return
+	}
+
+	glog.Error("omxplayer failed to play ", file, " (", exit, "): ", exit.Stderr)
+	mediaDto := NewFileDto(instance.playing)
+	player.lastFailure = &PlayerFailureDto{
+		Media:    &mediaDto,
+		Message:  "omxplayer " + exit.String(),
+		ExitCode: exit.Code,
+		Stderr:   exit.Stderr,
+		Time:     time.Now(),
+	}
+
+	if player.retry && !instance.retried {
+		lastPosition := instance.Position()
+		position := lastPosition.GetSeconds()
+		glog.Warning("Restarting play of ", file, " at ", position, "s")
+
+		player.restarts++
+		player.lastFailure.Retried = true
+		if err := player.start(instance.playing, position, true); err != nil {
+			glog.Error("Can't restart play of ", file, ": ", err)
+			player.lastFailure.Message += ", restart failed: " + err.Error()
+		}
+	}
+}
+
 // Return status of OMX Player
 func (player *OmxPlayer) GetStatus() PlayerStatus {
-	if player.instance == nil {
-		return NotPlayingStatus()
+	player.lock.Lock()
+	instance := player.instance
+	failure := player.lastFailure
+	player.lock.Unlock()
+
+	status := NotPlayingStatus()
+	if instance != nil {
+		status = instance.status()
 	}
 
-	return NewPlayerStatus(player.instance.playing, player.instance.Paused, player.instance.position, player.instance.Length)
+	status.Failure = failure
+	return status
 }
 
-// Playing instance of OMX Player
+// Number of times omxplayer has been restarted after a crash
+func (player *OmxPlayer) Restarts() int {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	return player.restarts
+}
+
+// Playing instance of OMX Player.
+// Immutable fields are set before goroutines start, others are guarded by lock.
 type omxPlaying struct {
-	process  *exec.Cmd
-	playing  File
-	stdin    io.WriteCloser
+	process *SupervisedProcess
+	playing File
+	stdin   io.WriteCloser
+	// This instance is already a restart after a crash
+	retried bool
+
+	lock     sync.Mutex
 	position TimePosition
+	Paused   bool
+	Length   TimePosition
+}
+
+// Snapshot of current state
+func (player *omxPlaying) status() PlayerStatus {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	return NewPlayerStatus(player.playing, player.Paused, player.position, player.Length)
+}
+
+func (player *omxPlaying) Position() TimePosition {
+	player.lock.Lock()
+	defer player.lock.Unlock()
 
-	Paused bool
-	Length TimePosition
+	return player.position
+}
+
+func (player *omxPlaying) setPosition(position TimePosition) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	player.position = position
+}
+
+func (player *omxPlaying) setLength(length TimePosition) {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	player.Length = length
 }
 
 // Pass a command (key) to OMX Player
@@ -123,6 +258,12 @@ func (player *omxPlaying) omxExec(key ...byte) {
 	player.stdin.Write(key)
 }
 
+// Ask OMX Player to quit, kill it if it doesn't in time
+func (player *omxPlaying) stop(grace time.Duration) {
+	player.omxExec('q')
+	go player.process.Stop(grace)
+}
+
 // Read OMX Player output
 func (player *omxPlaying) readOutput(scanner *bufio.Scanner, callback func()) {
 	for scanner.Scan() {
@@ -130,7 +271,7 @@ func (player *omxPlaying) readOutput(scanner *bufio.Scanner, callback func()) {
 		glog.V(2).Info("[omxplayer] stdout: ", line)
 
 		if strings.HasPrefix(line, "Seek") {
-			player.position = NewOmxTimePosition(line, false)
+			player.setPosition(NewOmxTimePosition(line, false))
 		}
 	}
 
@@ -138,17 +279,14 @@ func (player *omxPlaying) readOutput(scanner *bufio.Scanner, callback func()) {
 }
 
 // Use ffmepg to get media length
-func (player *omxPlaying) readMediaLength(file string) {
-
-	process := exec.Command("ffmpeg", "-i", file)
-	reader, _ := process.StdoutPipe()
-	process.Stderr = process.Stdout
-
-	if err := process.Start(); err != nil {
+func (player *omxPlaying) readMediaLength(ffmpeg string, file string) {
+	process, err := StartProcess(ProcessSpec{Args: []string{ffmpeg, "-i", file}, Timeout: 30 * time.Second, MergeStderr: true})
+	if err != nil {
 		glog.Error("Can't determine media length with ffmpeg (start): ", err)
+		return
 	}
 
-	scanner := bufio.NewScanner(reader)
+	scanner := bufio.NewScanner(process.Output())
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -156,16 +294,22 @@ func (player *omxPlaying) readMediaLength(file string) {
 		glog.V(2).Info("[ffmpeg] stdout: ", line)
 
 		if strings.Index(line, "Duration") >= 0 {
-			player.Length = NewOmxTimePosition(line, true)
-			glog.Info("Media ", file, " length is ", player.Length)
+			length := NewOmxTimePosition(line, true)
+			player.setLength(length)
+			glog.Info("Media ", file, " length is ", length)
 		}
 	}
 
+	// ffmpeg always fails without output file, exit status doesn't matter
+	process.Wait()
 	glog.V(2).Info("ffmpeg goroutine ends.")
 }
 
 // Toggle pause and fix position to not keep it running
 func (player *omxPlaying) TogglePause() {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
 	player.Paused = !player.Paused
 	player.position = player.position.Absolute(player.Paused)
 }
@@ -3,10 +3,12 @@ package main
 import (
 	"testing"
 	"io"
+	"io/ioutil"
 	"bufio"
 	"github.com/stretchr/testify/assert"
 	"time"
 	"fmt"
+	"sync"
 )
 
 func Test_parseInt(t *testing.T) {
@@ -73,12 +75,12 @@ func Test_omxPlaying_readOutput(t *testing.T) {
 
 		select {
 		case <-finished:
-			assert.Equal(t, 3723, player.position.seconds)
+			assert.Equal(t, 3723, player.Position().seconds)
 			assert.Equal(t, true, calledBack)
 
 		case <-time.After(1 * time.Second):
 			assert.Fail(t, "Failure to end readOutput goroutine in time.")
-			assert.Equal(t, 3723, player.position.seconds)
+			assert.Equal(t, 3723, player.Position().seconds)
 		}
 	})
 
@@ -103,11 +105,11 @@ func Test_omxPlaying_readOutput(t *testing.T) {
 		for {
 			select {
 			case <-timer.C:
-				assert.FailNow(t, fmt.Sprint("Position haven't been updated in expected time. Current position: ", player.position))
+				assert.FailNow(t, fmt.Sprint("Position haven't been updated in expected time. Current position: ", player.Position()))
 				break found
 
 			default:
-				if player.position.seconds == 3724 {
+				if player.Position().seconds == 3724 {
 					break found
 
 				} else {
@@ -124,11 +126,82 @@ func Test_omxPlaying_readOutput(t *testing.T) {
 
 		select {
 		case <-finished:
-			assert.Equal(t, 3726, player.position.seconds)
+			assert.Equal(t, 3726, player.Position().seconds)
 
 		case <-time.After(1 * time.Second):
 			assert.Fail(t, "Failure to end readOutput goroutine in time.")
-			assert.Equal(t, 3726, player.position.seconds)
+			assert.Equal(t, 3726, player.Position().seconds)
 		}
 	})
-}
\ No newline at end of file
+}
+// Run with -race: status is read from HTTP handlers while omxplayer output is parsed
+func TestOmxPlayer_concurrentStatus(t *testing.T) {
+	player := NewOmxPlayer("hdmi")
+
+	output, outputWriter := io.Pipe()
+	keys, keysWriter := io.Pipe()
+	go io.Copy(ioutil.Discard, keys)
+
+	instance := &omxPlaying{
+		playing:  NewMedia(Path{"/mnt/data/movie.mp4", "data", "", "movie.mp4"}),
+		stdin:    keysWriter,
+		position: NewTimePosition(0, 0, 0, false),
+		Length:   NewTimePosition(0, 0, 0, true),
+	}
+	player.instance = instance
+
+	finished := make(chan bool)
+	go func() {
+		instance.readOutput(bufio.NewScanner(output), func() { player.finished(instance, ProcessExit{}) })
+		close(finished)
+	}()
+
+	// Hammer status reads...
+	stop := make(chan bool)
+	readers := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					player.GetStatus()
+				}
+			}
+		}()
+	}
+
+	// ... while playing, seeking, pausing...
+	go func() {
+		for i := 0; i < 20; i++ {
+			instance.setLength(NewTimePosition(1, 30, i, true))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(outputWriter, "Seek 00:01:%02d\n", i)
+		assert.NoError(t, player.Execute(NewPlayerCommand("forward")))
+		assert.NoError(t, player.Execute(NewPlayerCommand("pause")))
+	}
+
+	// ... and until media ends
+	outputWriter.Close()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("readOutput should have ended when omxplayer output is closed")
+	}
+
+	close(stop)
+	readers.Wait()
+	keysWriter.Close()
+
+	assert.False(t, player.GetStatus().Playing)
+
+	// nothing to pause nor seek once ended
+	assert.IsType(t, &ConflictError{}, player.Execute(NewPlayerCommand("pause")))
+	assert.IsType(t, &ConflictError{}, player.Execute(NewPlayerCommand("forward")))
+	assert.NoError(t, player.Execute(NewPlayerCommand("stop")))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	avTransportType = "urn:schemas-upnp-org:service:AVTransport:1"
+
+	rendererSmallSeek = 30
+	rendererBigSeek   = 600
+)
+
+// Remote UPnP MediaRenderer (TV, speaker, ...) controlled through its AVTransport service.
+// Media are streamed from this server.
+type RendererPlayer struct {
+	controlUrl string
+	client     *http.Client
+
+	// Base URL of this server, as reachable from the renderer. Resolved on first play when empty.
+	baseUrl string
+
+	lock    sync.Mutex
+	playing File
+}
+
+func NewRendererPlayer(controlUrl string) *RendererPlayer {
+	return &RendererPlayer{
+		controlUrl: controlUrl,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Renderers are expected to play any video or audio
+func (player *RendererPlayer) Accept(ext string) bool {
+	mime := mediaMimeType(ext)
+	return strings.HasPrefix(mime, "video/") || strings.HasPrefix(mime, "audio/")
+}
+
+func (player *RendererPlayer) Execute(command PlayerCommand) error {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+
+	switch command.Operation {
+	case "play":
+		if command.File == nil {
+			_, err := player.call("Play", "Speed", "1")
+			return err
+		}
+		return player.play(command.File, startPosition(command), parentalViewer{User: command.User, Profile: command.Profile})
+
+	case "pause":
+		state, err := player.transportState()
+		if err != nil {
+			return err
+		}
+		if state == "PAUSED_PLAYBACK" {
+			_, err = player.call("Play", "Speed", "1")
+		} else {
+			_, err = player.call("Pause")
+		}
+		return err
+
+	case "stop":
+		player.playing = nil
+		_, err := player.call("Stop")
+		return err
+
+	case "forward":
+		return player.seek(rendererSmallSeek)
+
+	case "backward":
+		return player.seek(-rendererSmallSeek)
+
+	case "bigForward":
+		return player.seek(rendererBigSeek)
+
+	case "bigBackward":
+		return player.seek(-rendererBigSeek)
+
+	default:
+		return &UnsupportedCommandError{Operation: command.Operation, Player: "renderer adapter"}
+	}
+}
+
+// Ask renderer what it's doing. Lock isn't held while calling the renderer: status is polled by health, metrics,
+// profiles and sleep timer, a slow renderer must not block them nor commands.
+func (player *RendererPlayer) GetStatus() PlayerStatus {
+	player.lock.Lock()
+	playing := player.playing
+	player.lock.Unlock()
+
+	if playing == nil {
+		return NotPlayingStatus()
+	}
+
+	state, err := player.transportState()
+	if err != nil {
+		glog.Warning("Can't get renderer ", player.controlUrl, " state: ", err)
+		return NotPlayingStatus()
+	}
+	if state == "STOPPED" || state == "NO_MEDIA_PRESENT" {
+		return NotPlayingStatus()
+	}
+
+	info, err := player.call("GetPositionInfo")
+	if err != nil {
+		glog.Warning("Can't get renderer ", player.controlUrl, " position: ", err)
+		return NotPlayingStatus()
+	}
+
+	paused := state == "PAUSED_PLAYBACK"
+	return NewPlayerStatus(playing, paused, NewOmxTimePosition(info["RelTime"], true), NewOmxTimePosition(info["TrackDuration"], true))
+}
+
+func (player *RendererPlayer) play(file File, fromSeconds int, viewer parentalViewer) error {
+	if player.baseUrl == "" {
+		baseUrl, err := serverUrlFor(player.controlUrl)
+		if err != nil {
+			return err
+		}
+		player.baseUrl = baseUrl
+	}
+
+	didl := newDidlLite(viewer)
+	if file.IsDir() || !didl.add(file, player.baseUrl) || len(didl.Items) == 0 {
+		return &UnsupportedError{file.Path().PathId() + " can't be streamed to a renderer"}
+	}
+	metadata, err := didl.marshal()
+	if err != nil {
+		return err
+	}
+
+	glog.Info("Start to play ", file.Path().PathId(), " on renderer ", player.controlUrl)
+	if _, err := player.call("SetAVTransportURI", "CurrentURI", didl.Items[0].Res.Url, "CurrentURIMetaData", metadata); err != nil {
+		return err
+	}
+	if _, err := player.call("Play", "Speed", "1"); err != nil {
+		return err
+	}
+
+	player.playing = file
+	if fromSeconds > 0 {
+		return player.seekTo(fromSeconds)
+	}
+	return nil
+}
+
+// Seek relatively to current position
+func (player *RendererPlayer) seek(deltaSeconds int) error {
+	info, err := player.call("GetPositionInfo")
+	if err != nil {
+		return err
+	}
+
+	position := NewOmxTimePosition(info["RelTime"], true)
+	seconds := position.GetSeconds() + deltaSeconds
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	return player.seekTo(seconds)
+}
+
+// Seek to absolute position
+func (player *RendererPlayer) seekTo(seconds int) error {
+	_, err := player.call("Seek", "Unit", "REL_TIME", "Target", fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds%3600)/60, seconds%60))
+	return err
+}
+
+func (player *RendererPlayer) transportState() (string, error) {
+	info, err := player.call("GetTransportInfo")
+	if err != nil {
+		return "", err
+	}
+	return info["CurrentTransportState"], nil
+}
+
+// Call AVTransport action on instance 0 ; values are pairs of argument name and value
+func (player *RendererPlayer) call(action string, values ...string) (map[string]string, error) {
+	body := soapEnvelopeXml(soapActionXml(avTransportType, action, append([]string{"InstanceID", "0"}, values...)...))
+
+	request, err := http.NewRequest("POST", player.controlUrl, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPACTION", `"`+avTransportType+"#"+action+`"`)
+
+	response, err := player.client.Do(request)
+	if err != nil {
+		return nil, &PlayerUnavailableError{"renderer is unreachable: " + err.Error()}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return nil, &PlayerUnavailableError{fmt.Sprintf("renderer rejected %s action with status %d", action, response.StatusCode)}
+	}
+
+	result, err := readSoapAction(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]string, len(result.Args))
+	for _, arg := range result.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+	return args, nil
+}
+
+// Base URL of this server, using the local address which can reach the renderer
+func serverUrlFor(controlUrl string) (string, error) {
+	u, err := url.Parse(controlUrl)
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	remote, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := localIpFor(remote)
+	if err != nil {
+		return "", err
+	}
+
+	plainPort := GetMmConfig().PlainPort()
+	if plainPort == 0 {
+		return "", fmt.Errorf("renderers require -http-port with -tls: they only support plain HTTP")
+	}
+	return fmt.Sprintf("http://%s:%d", ip, plainPort), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fake UPnP renderer recording received AVTransport actions
+type fakeRenderer struct {
+	lock    sync.Mutex
+	actions []*soapAction
+	state   string
+}
+
+func (f *fakeRenderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action, err := readSoapAction(r.Body)
+	if err != nil {
+		soapFault(w, 401, "Invalid Action")
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.actions = append(f.actions, action)
+
+	switch action.XMLName.Local {
+	case "GetTransportInfo":
+		soapResponse(w, avTransportType, "GetTransportInfo", "CurrentTransportState", f.state)
+	case "GetPositionInfo":
+		soapResponse(w, avTransportType, "GetPositionInfo", "RelTime", "0:01:10", "TrackDuration", "1:30:00")
+	default:
+		soapResponse(w, avTransportType, action.XMLName.Local)
+	}
+}
+
+func (f *fakeRenderer) received() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var names []string
+	for _, a := range f.actions {
+		names = append(names, a.XMLName.Local)
+	}
+	return names
+}
+
+func TestRendererPlayer_Execute(t *testing.T) {
+	renderer := &fakeRenderer{state: "PLAYING"}
+	server := httptest.NewServer(renderer)
+	defer server.Close()
+
+	player := NewRendererPlayer(server.URL)
+	player.baseUrl = "http://192.168.0.11:8080"
+
+	t.Run("it should set transport URI to stream URL and play", func(t *testing.T) {
+		err := player.Execute(NewPlayerCommand("play", NewMedia(Path{"", "data", "films", "Iron Man.mkv"})))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"SetAVTransportURI", "Play"}, renderer.received())
+		assert.Equal(t, "0", renderer.actions[0].Arg("InstanceID"))
+		assert.Equal(t, "http://192.168.0.11:8080/api/stream/data/films/Iron%20Man.mkv", renderer.actions[0].Arg("CurrentURI"))
+		assert.Contains(t, renderer.actions[0].Arg("CurrentURIMetaData"), "object.item.videoItem")
+	})
+
+	t.Run("it should report renderer position", func(t *testing.T) {
+		status := player.GetStatus()
+
+		assert.True(t, status.Playing)
+		assert.False(t, status.Paused)
+		assert.Equal(t, "Iron Man.mkv", status.Media.Name)
+		assert.Equal(t, &TimePositionDto{0, 1, 10}, status.Position)
+		assert.Equal(t, &TimePositionDto{1, 30, 0}, status.Length)
+	})
+
+	t.Run("it should seek from current position", func(t *testing.T) {
+		renderer.actions = nil
+		assert.NoError(t, player.Execute(NewPlayerCommand("forward")))
+
+		assert.Equal(t, []string{"GetPositionInfo", "Seek"}, renderer.received())
+		assert.Equal(t, "0:01:40", renderer.actions[1].Arg("Target"))
+	})
+
+	t.Run("it should resume when paused", func(t *testing.T) {
+		renderer.actions = nil
+		renderer.state = "PAUSED_PLAYBACK"
+		assert.NoError(t, player.Execute(NewPlayerCommand("pause")))
+
+		assert.Equal(t, []string{"GetTransportInfo", "Play"}, renderer.received())
+	})
+
+	t.Run("it should stop and not be playing anymore", func(t *testing.T) {
+		renderer.actions = nil
+		assert.NoError(t, player.Execute(NewPlayerCommand("stop")))
+
+		assert.Equal(t, []string{"Stop"}, renderer.received())
+		assert.False(t, player.GetStatus().Playing)
+	})
+
+	t.Run("it should refuse a directory named as a video", func(t *testing.T) {
+		renderer.actions = nil
+		err := player.Execute(NewPlayerCommand("play", NewDir(Path{"", "data", "films", "Movie.mkv"})))
+
+		assert.IsType(t, &UnsupportedError{}, err)
+		assert.Empty(t, renderer.received())
+	})
+
+	t.Run("it should be unavailable when renderer rejects actions", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			soapFault(w, 501, "Action Failed")
+		}))
+		defer failing.Close()
+
+		err := NewRendererPlayer(failing.URL).Execute(NewPlayerCommand("stop"))
+		assert.IsType(t, &PlayerUnavailableError{}, err)
+	})
+
+	t.Run("it should accept only video and audio", func(t *testing.T) {
+		assert.True(t, player.Accept("mkv"))
+		assert.True(t, player.Accept("MP3"))
+		assert.False(t, player.Accept("jpg"))
+		assert.False(t, player.Accept("txt"))
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Named playback targets (output zones), each one with its own dispatcher, commands queue and status
+type PlayerTargets struct {
+	// Target names, in configuration order. First one is the default target.
+	names       []string
+	kinds       map[string]string
+	dispatchers map[string]*PlayerDispatcher
+}
+
+// Parse targets configuration: coma separated list of name:kind[:option]
+// Supported kinds are:
+//   - omx: local omxplayer, option is the audio output (hdmi by default, local, both, ...)
+//   - renderer: remote UPnP renderer, option is the URL of its AVTransport control endpoint
+//
+// When playerRetry is set, local players are restarted once after a crash.
+func NewPlayerTargets(config string, playerRetry bool) (*PlayerTargets, error) {
+	targets := &PlayerTargets{
+		kinds:       make(map[string]string),
+		dispatchers: make(map[string]*PlayerDispatcher),
+	}
+
+	for _, targetConfig := range strings.Split(config, ",") {
+		t := strings.SplitN(strings.TrimSpace(targetConfig), ":", 3)
+		if len(t) < 2 || t[0] == "" {
+			return nil, fmt.Errorf("targets configuration invalid '%s', it must be name:kind[:option]", targetConfig)
+		}
+		if _, exists := targets.dispatchers[t[0]]; exists {
+			return nil, fmt.Errorf("target '%s' is configured twice", t[0])
+		}
+
+		option := ""
+		if len(t) == 3 {
+			option = t[2]
+		}
+
+		var player Player
+		switch t[1] {
+		case "omx":
+			if option == "" {
+				option = "hdmi"
+			}
+			omx := NewOmxPlayer(option)
+			omx.retry = playerRetry
+			player = omx
+
+		case "renderer":
+			if option == "" {
+				return nil, fmt.Errorf("target '%s' requires the URL of the renderer AVTransport control", t[0])
+			}
+			player = NewRendererPlayer(option)
+
+		default:
+			return nil, fmt.Errorf("target '%s' has an unknown kind: %s", t[0], t[1])
+		}
+
+		glog.V(1).Infoln("Configure target ", t[0], " (", t[1], " ", option, ")")
+		targets.add(t[0], t[1], NewPlayerDispatcher(player))
+	}
+
+	return targets, nil
+}
+
+func (t *PlayerTargets) add(name string, kind string, dispatcher *PlayerDispatcher) {
+	dispatcher.name = name
+	t.names = append(t.names, name)
+	t.kinds[name] = kind
+	t.dispatchers[name] = dispatcher
+}
+
+// Start a dispatching goroutine per target
+func (t *PlayerTargets) StartDispatching() {
+	for _, d := range t.dispatchers {
+		go d.StartDispatching()
+	}
+}
+
+// Stop all dispatching goroutines
+func (t *PlayerTargets) StopDispatching() {
+	for _, d := range t.dispatchers {
+		d.StopDispatching()
+	}
+}
+
+// Stop all dispatching goroutines and players, in parallel
+func (t *PlayerTargets) Shutdown(timeout time.Duration) {
+	wg := new(sync.WaitGroup)
+	wg.Add(len(t.dispatchers))
+	for _, d := range t.dispatchers {
+		go func(d *PlayerDispatcher) {
+			defer wg.Done()
+			d.Shutdown(timeout)
+		}(d)
+	}
+	wg.Wait()
+}
+
+// Get dispatcher of a target, or of the default one when name is empty
+func (t *PlayerTargets) Get(name string) (*PlayerDispatcher, error) {
+	if name == "" {
+		name = t.DefaultTarget()
+	}
+
+	if d, ok := t.dispatchers[name]; ok {
+		return d, nil
+	}
+	return nil, &NotFoundError{"unknown player target: " + name}
+}
+
+func (t *PlayerTargets) DefaultTarget() string {
+	if len(t.names) == 0 {
+		return ""
+	}
+	return t.names[0]
+}
+
+// Target DTO, built for REST API
+type PlayerTargetDto struct {
+	Name    string       `json:"name"`
+	Kind    string       `json:"kind"`
+	Default bool         `json:"default"`
+	Status  PlayerStatus `json:"status"`
+}
+
+// Status of all targets, in configuration order
+func (t *PlayerTargets) Status() []PlayerTargetDto {
+	targets := make([]PlayerTargetDto, len(t.names))
+	for i, name := range t.names {
+		targets[i] = PlayerTargetDto{
+			Name:    name,
+			Kind:    t.kinds[name],
+			Default: i == 0,
+			Status:  t.dispatchers[name].PlayerStatus(),
+		}
+	}
+	return targets
+}
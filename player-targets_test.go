@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPlayerTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantTargets []string
+		wantErr     bool
+	}{
+		{"it should configure a single omx target", "hdmi:omx:hdmi", []string{"hdmi"}, false},
+		{"it should configure several targets in order", "hdmi:omx, audio-jack:omx:local,tv:renderer:http://192.168.0.20:1400/AVTransport/Control", []string{"hdmi", "audio-jack", "tv"}, false},
+		{"it should reject unknown kind", "hdmi:vlc", nil, true},
+		{"it should reject target without kind", "hdmi", nil, true},
+		{"it should reject renderer without URL", "tv:renderer", nil, true},
+		{"it should reject duplicated names", "hdmi:omx,hdmi:omx:local", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := NewPlayerTargets(tt.config, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPlayerTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				assert.Equal(t, tt.wantTargets, targets.names)
+			}
+		})
+	}
+}
+
+func TestPlayerTargets_Get(t *testing.T) {
+	targets, _ := NewPlayerTargets("hdmi:omx:hdmi,audio-jack:omx:local", false)
+
+	t.Run("it should return default target when name is empty", func(t *testing.T) {
+		d, err := targets.Get("")
+		assert.NoError(t, err)
+		assert.Equal(t, targets.dispatchers["hdmi"], d)
+	})
+
+	t.Run("it should return named target", func(t *testing.T) {
+		d, err := targets.Get("audio-jack")
+		assert.NoError(t, err)
+		assert.Equal(t, targets.dispatchers["audio-jack"], d)
+	})
+
+	t.Run("it should fail on unknown target", func(t *testing.T) {
+		_, err := targets.Get("kitchen")
+		assert.Error(t, err)
+	})
+
+	t.Run("it should list targets with their status", func(t *testing.T) {
+		status := targets.Status()
+		if assert.Len(t, status, 2) {
+			assert.Equal(t, PlayerTargetDto{Name: "hdmi", Kind: "omx", Default: true, Status: NotPlayingStatus()}, status[0])
+			assert.Equal(t, PlayerTargetDto{Name: "audio-jack", Kind: "omx", Default: false, Status: NotPlayingStatus()}, status[1])
+		}
+	})
+}
@@ -65,6 +65,19 @@ type PlayerStatus struct {
 	Media    *FileDto         `json:"media"`
 	Position *TimePositionDto `json:"position"`
 	Length   *TimePositionDto `json:"length"`
+
+	// Last play failure, when the player crashed
+	Failure *PlayerFailureDto `json:"failure,omitempty"`
+}
+
+// Why the player stopped playing, built for REST API
+type PlayerFailureDto struct {
+	Media    *FileDto  `json:"media"`
+	Message  string    `json:"message"`
+	ExitCode int       `json:"exitCode"`
+	Stderr   string    `json:"stderr,omitempty"`
+	Retried  bool      `json:"retried"`
+	Time     time.Time `json:"time"`
 }
 
 // Status when playing
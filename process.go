@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Size of captured stderr, only the end is kept
+const processStderrTail = 4096
+
+// How to run a supervised child process
+type ProcessSpec struct {
+	// Command and its arguments
+	Args []string
+
+	// Kill process (and its children) when it runs longer. No timeout when 0.
+	Timeout time.Duration
+
+	// Send stderr to Output as well, in addition to capture it
+	MergeStderr bool
+
+	// Open a pipe to write on process input
+	Stdin bool
+}
+
+// How a supervised process ended
+type ProcessExit struct {
+	// Exit code, -1 when process has been killed by a signal or couldn't run
+	Code int
+	// Last bytes written on stderr
+	Stderr string
+
+	// Stop has been requested: exit is expected
+	Stopped bool
+	// Killed because it ran longer than its timeout
+	TimedOut bool
+
+	Err error
+}
+
+// True when process ended on its own, with a failure
+func (e ProcessExit) Failed() bool {
+	return !e.Stopped && (e.TimedOut || e.Code != 0 || e.Err != nil)
+}
+
+func (e ProcessExit) String() string {
+	switch {
+	case e.Stopped:
+		return "stopped"
+	case e.TimedOut:
+		return "killed after timeout"
+	case e.Err != nil && e.Code == -1:
+		return e.Err.Error()
+	default:
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+}
+
+// Child process which is always reaped, within its own process group to be killed with its children
+type SupervisedProcess struct {
+	name   string
+	cmd    *exec.Cmd
+	output *os.File
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+
+	stderrDone chan bool
+	done       chan bool
+
+	lock     sync.Mutex
+	stopped  bool
+	timedOut bool
+	exit     ProcessExit
+}
+
+// Start process and its reaper goroutine
+func StartProcess(spec ProcessSpec) (*SupervisedProcess, error) {
+	if len(spec.Args) == 0 {
+		return nil, fmt.Errorf("no command to run")
+	}
+
+	cmd := exec.Command(spec.Args[0], spec.Args[1:]...)
+	setProcessGroup(cmd)
+
+	// Own pipes: reading output must not be closed by Wait, nor block it
+	output, outputWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	errors, errorsWriter, err := os.Pipe()
+	if err != nil {
+		output.Close()
+		outputWriter.Close()
+		return nil, err
+	}
+	closeAll := func() {
+		output.Close()
+		outputWriter.Close()
+		errors.Close()
+		errorsWriter.Close()
+	}
+
+	p := &SupervisedProcess{
+		name:       spec.Args[0],
+		cmd:        cmd,
+		output:     output,
+		stderr:     &tailBuffer{max: processStderrTail},
+		stderrDone: make(chan bool),
+		done:       make(chan bool),
+	}
+	cmd.Stdout = outputWriter
+	cmd.Stderr = errorsWriter
+
+	if spec.Stdin {
+		if p.stdin, err = cmd.StdinPipe(); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		closeAll()
+		return nil, err
+	}
+	glog.V(1).Infoln("Started ", spec.Args, " (pid ", cmd.Process.Pid, ")")
+
+	// Only the child keeps writing sides: output ends with the process (and its children)
+	errorsWriter.Close()
+	if spec.MergeStderr {
+		go p.captureStderr(errors, outputWriter)
+	} else {
+		outputWriter.Close()
+		go p.captureStderr(errors, nil)
+	}
+
+	if spec.Timeout > 0 {
+		timer := time.AfterFunc(spec.Timeout, func() {
+			p.lock.Lock()
+			p.timedOut = true
+			p.lock.Unlock()
+
+			glog.Warning(p.name, " (pid ", cmd.Process.Pid, ") ran longer than ", spec.Timeout, ", killing it.")
+			killProcessGroup(cmd, true)
+		})
+		go func() {
+			<-p.done
+			timer.Stop()
+		}()
+	}
+
+	go p.reap()
+	return p, nil
+}
+
+// Keep end of stderr, and forward it to output when merged
+func (p *SupervisedProcess) captureStderr(errors *os.File, merged *os.File) {
+	defer close(p.stderrDone)
+	defer errors.Close()
+
+	if merged == nil {
+		io.Copy(p.stderr, errors)
+	} else {
+		io.Copy(io.MultiWriter(p.stderr, merged), errors)
+		merged.Close()
+	}
+}
+
+// Wait process end, and keep how it ended
+func (p *SupervisedProcess) reap() {
+	err := p.cmd.Wait()
+
+	// Orphan children could still write in output: make sure they are gone
+	killProcessGroup(p.cmd, true)
+	select {
+	case <-p.stderrDone:
+	case <-time.After(time.Second):
+		glog.Warning(p.name, " (pid ", p.cmd.Process.Pid, ") stderr is still open after its end.")
+	}
+
+	exit := ProcessExit{Code: -1, Stderr: p.stderr.String()}
+	if p.cmd.ProcessState != nil {
+		if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			exit.Code = status.ExitStatus()
+		}
+	}
+	if _, isExitError := err.(*exec.ExitError); err != nil && !isExitError {
+		exit.Err = err
+	}
+
+	p.lock.Lock()
+	exit.Stopped = p.stopped
+	exit.TimedOut = p.timedOut
+	p.exit = exit
+	p.lock.Unlock()
+
+	glog.V(1).Infoln(p.name, " (pid ", p.cmd.Process.Pid, ") ended: ", exit)
+	close(p.done)
+}
+
+// Process stdout (and stderr when merged). Reaches EOF once process and its children are gone.
+func (p *SupervisedProcess) Output() io.Reader {
+	return p.output
+}
+
+// Process stdin, nil unless requested in spec
+func (p *SupervisedProcess) Stdin() io.WriteCloser {
+	return p.stdin
+}
+
+// Closed once process has been reaped
+func (p *SupervisedProcess) Done() <-chan bool {
+	return p.done
+}
+
+// Wait process to be reaped
+func (p *SupervisedProcess) Wait() ProcessExit {
+	<-p.done
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.exit
+}
+
+// Stop process: give it some time to end by itself, then terminate and finally kill its process group
+func (p *SupervisedProcess) Stop(grace time.Duration) {
+	p.lock.Lock()
+	p.stopped = true
+	p.lock.Unlock()
+
+	select {
+	case <-p.done:
+		return
+	case <-time.After(grace):
+	}
+
+	glog.Warning(p.name, " (pid ", p.cmd.Process.Pid, ") didn't stop in time, terminating it.")
+	killProcessGroup(p.cmd, false)
+
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		killProcessGroup(p.cmd, true)
+		<-p.done
+	}
+}
+
+// Keep only the end of what's written
+type tailBuffer struct {
+	max int
+
+	lock sync.Mutex
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return string(b.data)
+}
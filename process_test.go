@@ -0,0 +1,240 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartProcess(t *testing.T) {
+	dir := stubDir(t)
+	defer os.RemoveAll(dir)
+
+	t.Run("it should capture exit code and stderr", func(t *testing.T) {
+		stub := stubBinary(t, dir, "crash", `echo "starting"; echo "failed to open vchiq instance" >&2; exit 3`)
+
+		process, err := StartProcess(ProcessSpec{Args: []string{stub}})
+		assert.NoError(t, err)
+
+		output, _ := ioutil.ReadAll(process.Output())
+		exit := waitExit(t, process)
+
+		assert.Equal(t, "starting\n", string(output))
+		assert.Equal(t, 3, exit.Code)
+		assert.Equal(t, "failed to open vchiq instance\n", exit.Stderr)
+		assert.True(t, exit.Failed())
+	})
+
+	t.Run("it should merge stderr into output when requested", func(t *testing.T) {
+		stub := stubBinary(t, dir, "merged", `echo "out"; echo "  Duration: 01:30:00.00" >&2`)
+
+		process, _ := StartProcess(ProcessSpec{Args: []string{stub}, MergeStderr: true})
+		output, _ := ioutil.ReadAll(process.Output())
+		exit := waitExit(t, process)
+
+		assert.Contains(t, string(output), "out\n")
+		assert.Contains(t, string(output), "Duration: 01:30:00.00")
+		assert.Equal(t, 0, exit.Code)
+		assert.False(t, exit.Failed())
+	})
+
+	t.Run("it should kill process running longer than timeout", func(t *testing.T) {
+		stub := stubBinary(t, dir, "hang", `sleep 30`)
+
+		process, _ := StartProcess(ProcessSpec{Args: []string{stub}, Timeout: 100 * time.Millisecond})
+		exit := waitExit(t, process)
+
+		assert.True(t, exit.TimedOut)
+		assert.True(t, exit.Failed())
+	})
+
+	t.Run("it should kill the whole process group on stop", func(t *testing.T) {
+		pidFile := filepath.Join(dir, "child.pid")
+		stub := stubBinary(t, dir, "parent", `trap "" TERM; sleep 30 & echo $! > `+pidFile+`; wait`)
+
+		process, _ := StartProcess(ProcessSpec{Args: []string{stub}})
+		childPid := waitPid(t, pidFile)
+
+		stopped := make(chan bool)
+		go func() {
+			process.Stop(50 * time.Millisecond)
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop should have killed the process group")
+		}
+
+		exit := process.Wait()
+		assert.True(t, exit.Stopped)
+		assert.False(t, exit.Failed())
+		assert.False(t, processAliveAfter(childPid, time.Second), "child process should be dead")
+	})
+
+	t.Run("it should fail when binary doesn't exist", func(t *testing.T) {
+		_, err := StartProcess(ProcessSpec{Args: []string{filepath.Join(dir, "missing")}})
+		assert.Error(t, err)
+	})
+}
+
+func TestOmxPlayer_supervision(t *testing.T) {
+	dir := stubDir(t)
+	defer os.RemoveAll(dir)
+
+	ffmpeg := stubBinary(t, dir, "ffmpeg", `echo "  Duration: 01:30:00.00, start: 0.000000" >&2; exit 1`)
+	media := NewMedia(Path{filepath.Join(dir, "movie.mp4"), "data", "", "movie.mp4"})
+
+	newPlayer := func(script string) *OmxPlayer {
+		player := NewOmxPlayer("hdmi")
+		player.command = []string{stubBinary(t, dir, "omxplayer", script)}
+		player.ffmpeg = ffmpeg
+		player.stopGrace = 100 * time.Millisecond
+		return player
+	}
+
+	t.Run("it should report omxplayer crash in status", func(t *testing.T) {
+		player := newPlayer(`echo "Seek 00:00:05"; echo "HDMI output is gone" >&2; exit 1`)
+
+		assert.NoError(t, player.Execute(NewPlayerCommand("play", media)))
+		status := waitNotPlaying(t, player)
+
+		if assert.NotNil(t, status.Failure) {
+			assert.Equal(t, 1, status.Failure.ExitCode)
+			assert.Contains(t, status.Failure.Stderr, "HDMI output is gone")
+			assert.Equal(t, "movie.mp4", status.Failure.Media.Name)
+			assert.False(t, status.Failure.Retried)
+		}
+		assert.Equal(t, 0, player.Restarts())
+	})
+
+	t.Run("it should restart play once from last position when configured", func(t *testing.T) {
+		argsFile := filepath.Join(dir, "args")
+		player := newPlayer(`echo "$@" >> ` + argsFile + `; echo "Seek 00:10:00"; exit 1`)
+		player.retry = true
+
+		assert.NoError(t, player.Execute(NewPlayerCommand("play", media)))
+		time.Sleep(100 * time.Millisecond)
+		status := waitNotPlaying(t, player)
+
+		calls, _ := ioutil.ReadFile(argsFile)
+		lines := strings.Split(strings.TrimSpace(string(calls)), "\n")
+		if assert.Len(t, lines, 2, "omxplayer should have been restarted exactly once") {
+			assert.NotContains(t, lines[0], "--pos")
+			assert.Contains(t, lines[1], "--pos 00:10:00")
+		}
+		if assert.NotNil(t, status.Failure) {
+			assert.False(t, status.Failure.Retried, "last failure is the one of the restarted play")
+		}
+		assert.Equal(t, 1, player.Restarts())
+	})
+
+	t.Run("it should not report a failure when stopped", func(t *testing.T) {
+		player := newPlayer(`trap "" TERM; while true; do sleep 1; done`)
+
+		assert.NoError(t, player.Execute(NewPlayerCommand("play", media)))
+		assert.True(t, player.GetStatus().Playing)
+		assert.NoError(t, player.Execute(NewPlayerCommand("stop")))
+
+		status := waitNotPlaying(t, player)
+		assert.Nil(t, status.Failure)
+	})
+
+	t.Run("it should read media length with ffmpeg", func(t *testing.T) {
+		player := newPlayer(`sleep 1`)
+
+		assert.NoError(t, player.Execute(NewPlayerCommand("play", media)))
+		time.Sleep(100 * time.Millisecond)
+
+		status := player.GetStatus()
+		assert.Equal(t, &TimePositionDto{1, 30, 0}, status.Length)
+		player.Execute(NewPlayerCommand("stop"))
+	})
+}
+
+// Temporary directory for stub binaries, which are shell scripts
+func stubDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "medima-stubs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Write an executable shell script
+func stubBinary(t *testing.T, dir string, name string, script string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func waitExit(t *testing.T, process *SupervisedProcess) ProcessExit {
+	select {
+	case <-process.Done():
+		return process.Wait()
+	case <-time.After(5 * time.Second):
+		t.Fatal("process should have been reaped")
+		return ProcessExit{}
+	}
+}
+
+func waitPid(t *testing.T, pidFile string) int {
+	for i := 0; i < 100; i++ {
+		if content, err := ioutil.ReadFile(pidFile); err == nil && len(content) > 0 {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+				return pid
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("child process never wrote its pid")
+	return 0
+}
+
+// Process exists and isn't a zombie (orphans may not be reaped in containers)
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// Wait for the process to be dead, killed processes aren't reaped instantly
+func processAliveAfter(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func waitNotPlaying(t *testing.T, player *OmxPlayer) PlayerStatus {
+	for i := 0; i < 500; i++ {
+		if status := player.GetStatus(); !status.Playing {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("player should have stopped playing")
+	return PlayerStatus{}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run process in its own group, to be able to kill its children with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Send SIGTERM, or SIGKILL when forced, to the whole process group
+func killProcessGroup(cmd *exec.Cmd, force bool) {
+	signal := syscall.SIGTERM
+	if force {
+		signal = syscall.SIGKILL
+	}
+
+	if cmd.Process != nil {
+		syscall.Kill(-cmd.Process.Pid, signal)
+	}
+}
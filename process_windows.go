@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+// Process groups are not supported: only the process itself is managed
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// Kill the process (there is no graceful termination signal)
+func killProcessGroup(cmd *exec.Cmd, force bool) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// Profile cookie is kept one year
+const profileCookieDuration = 365 * 24 * time.Hour
+
+// Household profiles: watch history, resume points, favorites. Must be registered after PlayerController.
+func ProfilesController(r *mux.Router) error {
+	glog.V(1).Infoln("Registering Profiles Controller")
+
+	var err error
+	if profiles, err = NewProfiles(dataFile(profilesFile)); err != nil {
+		return err
+	}
+	for name, d := range playerTargets.dispatchers {
+		d.AddObserver(profiles.observer(name))
+	}
+	stopMonitor := make(chan bool)
+	go profiles.Monitor(playerTargets, profileTrackPeriod, stopMonitor)
+	onShutdown("profiles", func() {
+		stopMonitor <- true
+		// save positions of running plays, to resume them after restart
+		profiles.track(playerTargets)
+	})
+
+	r.Methods("GET").Path("/api/profiles").HandlerFunc(HandleProfiles)
+	r.Methods("POST").Path("/api/profiles").HandlerFunc(restricted(PermissionManageUsers, HandleCreateProfile))
+	r.Methods("POST").Path("/api/profiles/import").HandlerFunc(restricted(PermissionManageUsers, HandleImportProfile))
+	r.Methods("GET").Path("/api/profiles/{id}").HandlerFunc(HandleProfile)
+	r.Methods("DELETE").Path("/api/profiles/{id}").HandlerFunc(restricted(PermissionManageUsers, HandleDeleteProfile))
+	r.Methods("POST").Path("/api/profiles/{id}/select").HandlerFunc(HandleSelectProfile)
+	r.Methods("GET").Path("/api/profiles/{id}/export").HandlerFunc(HandleExportProfile)
+	r.Methods("PUT", "DELETE").Path("/api/profiles/{id}/watched").Queries("media", "").HandlerFunc(HandleProfileWatched)
+	r.Methods("PUT", "DELETE").Path("/api/profiles/{id}/favorites").Queries("media", "").HandlerFunc(HandleProfileFavorite)
+
+	glog.Info("Profiles controller loaded")
+	return nil
+}
+
+// Profile ID from URL, 'current' being the active profile of request. Error when user can't use it.
+func profileId(r *http.Request) (string, error) {
+	id := mux.Vars(r)["id"]
+	if id == "current" {
+		return profiles.Active(r), nil
+	}
+	if !profileAllowed(r, id) {
+		return id, &ForbiddenError{"profile '" + id + "' belongs to another user"}
+	}
+	return id, nil
+}
+
+// Active profile of request, empty if profiles aren't enabled
+func activeProfile(r *http.Request) string {
+	if profiles == nil {
+		return ""
+	}
+	return profiles.Active(r)
+}
+
+type profilesDto struct {
+	Active   string              `json:"active"`
+	Profiles []ProfileSummaryDto `json:"profiles"`
+}
+
+// Profiles the user can use
+func HandleProfiles(w http.ResponseWriter, r *http.Request) {
+	allowed := []ProfileSummaryDto{}
+	for _, profile := range profiles.List() {
+		if profileAllowed(r, profile.Id) {
+			allowed = append(allowed, profile)
+		}
+	}
+	respondWithJSON(w, 200, profilesDto{Active: profiles.Active(r), Profiles: allowed})
+}
+
+func HandleProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := profileId(r)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	if profile, ok := profiles.Get(id); ok {
+		respondWithJSON(w, 200, profile)
+	} else {
+		failureResponse(r, &NotFoundError{"unknown profile: " + id}, w)
+	}
+}
+
+// Create a profile from JSON body: {"name": "..."}
+func HandleCreateProfile(w http.ResponseWriter, r *http.Request) {
+	var request ProfileSummaryDto
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid profile: " + err.Error()}, w)
+		return
+	}
+
+	profile, err := profiles.Create(request.Name)
+	if err != nil {
+		failureResponse(r, err, w)
+	} else {
+		respondWithJSON(w, 201, profile)
+	}
+}
+
+// Create or replace a profile from an export
+func HandleImportProfile(w http.ResponseWriter, r *http.Request) {
+	var profile Profile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid profile: " + err.Error()}, w)
+		return
+	}
+
+	imported, err := profiles.Import(profile)
+	if err != nil {
+		failureResponse(r, err, w)
+	} else {
+		respondWithJSON(w, 200, imported)
+	}
+}
+
+func HandleDeleteProfile(w http.ResponseWriter, r *http.Request) {
+	id, _ := profileId(r)
+
+	found, err := profiles.Remove(id)
+	if _, invalid := err.(*InvalidProfileError); invalid {
+		failureResponse(r, &ConflictError{err.Error()}, w)
+	} else if err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown profile: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+// Make profile the active one of this browser
+func HandleSelectProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := profileId(r)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	profile, ok := profiles.Get(id)
+	if !ok {
+		failureResponse(r, &NotFoundError{"unknown profile: " + id}, w)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     profileCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  time.Now().Add(profileCookieDuration),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	respondWithJSON(w, 200, ProfileSummaryDto{Id: profile.Id, Name: profile.Name})
+}
+
+// Download whole profile as a JSON file, which can be imported back
+func HandleExportProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := profileId(r)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	profile, ok := profiles.Get(id)
+	if !ok {
+		failureResponse(r, &NotFoundError{"unknown profile: " + id}, w)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"medima-profile-"+id+".json\"")
+	respondWithJSON(w, 200, profile)
+}
+
+// PUT flags media as watched, DELETE as not watched
+func HandleProfileWatched(w http.ResponseWriter, r *http.Request) {
+	handleProfileFlag(w, r, profiles.SetWatched)
+}
+
+// PUT adds media to favorites, DELETE removes it
+func HandleProfileFavorite(w http.ResponseWriter, r *http.Request) {
+	handleProfileFlag(w, r, profiles.SetFavorite)
+}
+
+func handleProfileFlag(w http.ResponseWriter, r *http.Request, set func(id string, pathId string, flag bool) (bool, error)) {
+	id, err := profileId(r)
+	var path Path
+	if err == nil {
+		path, err = NewPathFromId(r.URL.Query().Get("media"))
+	}
+	if err == nil {
+		err = checkRootAccess(r, path)
+	}
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	if found, err := set(id, path.PathId(), r.Method == "PUT"); err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown profile: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
+
+// Set command start position from resume point of active profile, unless it's already given
+func resumeFromProfile(cmd *PlayerCommand) {
+	if profiles == nil || cmd.File == nil || cmd.Args["from"] != nil {
+		return
+	}
+	if resume, ok := profiles.ResumePoint(cmd.Profile, cmd.File.Path().PathId()); ok {
+		cmd.Args["from"] = []string{strconv.Itoa(resume.Seconds)}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// File, in data directory, where profiles are persisted
+const profilesFile = "profiles.json"
+
+const (
+	profileCookie  = "medima_profile"
+	profileHeader  = "X-Profile"
+	defaultProfile = "default"
+
+	// Number of searches remembered per profile
+	recentSearchesSize = 20
+	// Media is watched once this part has been played
+	watchedRatio = 0.9
+	// Resume points are only kept after that time (seconds), starting again is easier otherwise
+	resumeMinimum = 30
+	// How often positions of running plays are saved
+	profileTrackPeriod = 30 * time.Second
+)
+
+// Position where to resume a media
+type ResumePoint struct {
+	Seconds int       `json:"seconds"`
+	Length  int       `json:"length,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Household member preferences and history. Medias are referenced by their PathId.
+type Profile struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+
+	Watched        map[string]time.Time   `json:"watched"`
+	Resume         map[string]ResumePoint `json:"resume"`
+	Favorites      []string               `json:"favorites"`
+	RecentSearches []string               `json:"recentSearches"`
+}
+
+func newProfile(id string, name string) *Profile {
+	p := &Profile{Id: id, Name: name, Created: time.Now()}
+	p.init()
+	return p
+}
+
+// Make sure collections are never nil
+func (p *Profile) init() {
+	if p.Watched == nil {
+		p.Watched = make(map[string]time.Time)
+	}
+	if p.Resume == nil {
+		p.Resume = make(map[string]ResumePoint)
+	}
+	if p.Favorites == nil {
+		p.Favorites = []string{}
+	}
+	if p.RecentSearches == nil {
+		p.RecentSearches = []string{}
+	}
+}
+
+// Deep copy, safe to use without lock
+func (p *Profile) copy() Profile {
+	c := *p
+	c.Watched = make(map[string]time.Time, len(p.Watched))
+	for k, v := range p.Watched {
+		c.Watched[k] = v
+	}
+	c.Resume = make(map[string]ResumePoint, len(p.Resume))
+	for k, v := range p.Resume {
+		c.Resume[k] = v
+	}
+	c.Favorites = append([]string{}, p.Favorites...)
+	c.RecentSearches = append([]string{}, p.RecentSearches...)
+	return c
+}
+
+// Profile summary, built for REST API
+type ProfileSummaryDto struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// All profiles, persisted in a JSON file, and the ones watching each target
+type Profiles struct {
+	file string
+
+	lock     sync.Mutex
+	profiles map[string]*Profile
+	// Profile which started current play, per target
+	viewers map[string]string
+}
+
+var profiles *Profiles
+
+// Load profiles from file, a default profile is always available
+func NewProfiles(file string) (*Profiles, error) {
+	p := &Profiles{file: file, profiles: make(map[string]*Profile), viewers: make(map[string]string)}
+	if _, err := readJsonFile(file, &p.profiles); err != nil {
+		return nil, fmt.Errorf("can't load profiles from %s: %s", file, err.Error())
+	}
+
+	for _, profile := range p.profiles {
+		profile.init()
+	}
+	if _, ok := p.profiles[defaultProfile]; !ok {
+		p.profiles[defaultProfile] = newProfile(defaultProfile, "Default")
+	}
+
+	glog.V(1).Infoln("Loaded ", len(p.profiles), " profiles from ", file)
+	return p, nil
+}
+
+// Non admin users can only use their own profile, named as them, and the default one
+func profileAllowed(r *http.Request, id string) bool {
+	principal := currentPrincipal(r)
+	if principal == nil || hasPermission(r, PermissionManageUsers) {
+		return true
+	}
+	return id == defaultProfile || id == principal.User
+}
+
+// Active profile of request: X-Profile header, profile cookie, profile named as the user, or default profile.
+// Profiles the user isn't allowed to use are ignored.
+func (p *Profiles) Active(r *http.Request) string {
+	candidates := []string{r.Header.Get(profileHeader)}
+	if cookie, err := r.Cookie(profileCookie); err == nil {
+		candidates = append(candidates, cookie.Value)
+	}
+	candidates = append(candidates, requestUser(r))
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, id := range candidates {
+		if _, ok := p.profiles[id]; ok && id != "" && profileAllowed(r, id) {
+			return id
+		}
+	}
+	return defaultProfile
+}
+
+// Profile summaries, sorted by name
+func (p *Profiles) List() []ProfileSummaryDto {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	list := make([]ProfileSummaryDto, 0, len(p.profiles))
+	for _, profile := range p.profiles {
+		list = append(list, ProfileSummaryDto{Id: profile.Id, Name: profile.Name})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if a, b := strings.ToLower(list[i].Name), strings.ToLower(list[j].Name); a != b {
+			return a < b
+		}
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+func (p *Profiles) Get(id string) (Profile, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if profile, ok := p.profiles[id]; ok {
+		return profile.copy(), true
+	}
+	return Profile{}, false
+}
+
+// Create a new profile, its ID is built from its name
+func (p *Profiles) Create(name string) (Profile, error) {
+	name = strings.TrimSpace(name)
+	base := slugId(name)
+	if base == "" {
+		return Profile{}, &InvalidProfileError{"profile name is required"}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	id := base
+	for i := 2; p.profiles[id] != nil; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	profile := newProfile(id, name)
+	p.profiles[id] = profile
+	return profile.copy(), p.save()
+}
+
+// Create or replace a profile with exported data
+func (p *Profiles) Import(profile Profile) (Profile, error) {
+	if profile.Id == "" || slugId(profile.Id) != profile.Id {
+		return Profile{}, &InvalidProfileError{"invalid profile id: '" + profile.Id + "'"}
+	}
+	if profile.Created.IsZero() {
+		profile.Created = time.Now()
+	}
+	profile.init()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.profiles[profile.Id] = &profile
+	return profile.copy(), p.save()
+}
+
+// Remove a profile, default one can't be removed. Return false if it doesn't exist.
+func (p *Profiles) Remove(id string) (bool, error) {
+	if id == defaultProfile {
+		return true, &InvalidProfileError{"default profile can't be removed"}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.profiles[id]; !ok {
+		return false, nil
+	}
+	delete(p.profiles, id)
+	return true, p.save()
+}
+
+// Apply a change on a profile and save it. Return false if profile doesn't exist.
+func (p *Profiles) update(id string, change func(profile *Profile)) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	profile, ok := p.profiles[id]
+	if !ok {
+		return false, nil
+	}
+	change(profile)
+	return true, p.save()
+}
+
+// Flag media as watched, or not watched
+func (p *Profiles) SetWatched(id string, pathId string, watched bool) (bool, error) {
+	return p.update(id, func(profile *Profile) {
+		if watched {
+			profile.Watched[pathId] = time.Now()
+			delete(profile.Resume, pathId)
+		} else {
+			delete(profile.Watched, pathId)
+		}
+	})
+}
+
+// Add media to favorites, or remove it
+func (p *Profiles) SetFavorite(id string, pathId string, favorite bool) (bool, error) {
+	return p.update(id, func(profile *Profile) {
+		favorites := profile.Favorites[:0]
+		for _, f := range profile.Favorites {
+			if f != pathId {
+				favorites = append(favorites, f)
+			}
+		}
+		if favorite {
+			favorites = append(favorites, pathId)
+		}
+		profile.Favorites = favorites
+	})
+}
+
+// Remember a search, most recent first, without duplicates
+func (p *Profiles) AddSearch(id string, pattern string) {
+	_, err := p.update(id, func(profile *Profile) {
+		searches := []string{pattern}
+		for _, s := range profile.RecentSearches {
+			if s != pattern && len(searches) < recentSearchesSize {
+				searches = append(searches, s)
+			}
+		}
+		profile.RecentSearches = searches
+	})
+	if err != nil {
+		glog.Error("Can't save search of profile ", id, ": ", err)
+	}
+}
+
+// Position where profile stopped watching media
+func (p *Profiles) ResumePoint(id string, pathId string) (ResumePoint, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if profile, ok := p.profiles[id]; ok {
+		resume, ok := profile.Resume[pathId]
+		return resume, ok
+	}
+	return ResumePoint{}, false
+}
+
+// Observer for a target dispatcher: remember which profile started current play
+func (p *Profiles) observer(target string) CommandObserver {
+	return func(command PlayerCommand, err error) {
+		if err != nil || command.Operation != "play" || command.File == nil {
+			return
+		}
+
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if command.Profile == "" {
+			delete(p.viewers, target)
+		} else {
+			p.viewers[target] = command.Profile
+		}
+	}
+}
+
+// Save positions of running plays periodically, until stopped
+func (p *Profiles) Monitor(targets *PlayerTargets, period time.Duration, stop chan bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.track(targets)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Update resume points and watched flags from players status
+func (p *Profiles) track(targets *PlayerTargets) {
+	p.lock.Lock()
+	viewers := make(map[string]string, len(p.viewers))
+	for target, id := range p.viewers {
+		viewers[target] = id
+	}
+	p.lock.Unlock()
+
+	// players are queried without lock, they may be slow to answer
+	statuses := make(map[string]PlayerStatus, len(viewers))
+	for target := range viewers {
+		if dispatcher, err := targets.Get(target); err == nil {
+			statuses[target] = dispatcher.PlayerStatus()
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	changed := false
+	for target, id := range viewers {
+		if p.viewers[target] != id {
+			// another play has started meanwhile
+			continue
+		}
+
+		profile, ok := p.profiles[id]
+		status, known := statuses[target]
+		if !ok || !known || !status.Playing || status.Media == nil || status.Position == nil {
+			delete(p.viewers, target)
+			continue
+		}
+
+		position := dtoSeconds(status.Position)
+		length := dtoSeconds(status.Length)
+		pathId := status.Media.PathId
+		switch {
+		case length > 0 && float64(position) >= watchedRatio*float64(length):
+			if _, watched := profile.Watched[pathId]; !watched {
+				profile.Watched[pathId] = time.Now()
+				delete(profile.Resume, pathId)
+				changed = true
+			}
+
+		case position >= resumeMinimum && profile.Resume[pathId].Seconds != position:
+			profile.Resume[pathId] = ResumePoint{Seconds: position, Length: length, Updated: time.Now()}
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := p.save(); err != nil {
+			glog.Error("Can't save profiles: ", err)
+		}
+	}
+}
+
+// Write profiles in file. Lock must be held.
+func (p *Profiles) save() error {
+	return writeJsonFile(p.file, p.profiles)
+}
+
+func dtoSeconds(position *TimePositionDto) int {
+	if position == nil {
+		return 0
+	}
+	return position.Hours*3600 + position.Minutes*60 + position.Seconds
+}
+
+// Profile can't be created, imported or removed
+type InvalidProfileError struct {
+	Reason string
+}
+
+func (e *InvalidProfileError) Error() string {
+	return e.Reason
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProfiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-profiles")
+	defer os.RemoveAll(dir)
+
+	media := filepath.Join(dir, "media")
+	os.MkdirAll(filepath.Join(media, "Films"), 0755)
+	ioutil.WriteFile(filepath.Join(media, "Films", "Cars.mp4"), []byte("fake movie"), 0644)
+	roots = map[string]Path{"media": {Root: "media", localPath: media}}
+
+	file := filepath.Join(dir, "profiles.json")
+	p, err := NewProfiles(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	t.Run("profiles are created with an unique id", func(t *testing.T) {
+		alice, err := p.Create(" Alice Smith ")
+		assert.NoError(t, err)
+		assert.Equal(t, "alice-smith", alice.Id)
+		assert.Equal(t, "Alice Smith", alice.Name)
+
+		again, _ := p.Create("alice smith")
+		assert.Equal(t, "alice-smith-2", again.Id)
+
+		_, err = p.Create("  ")
+		assert.IsType(t, &InvalidProfileError{}, err)
+
+		assert.Equal(t, []ProfileSummaryDto{{"alice-smith", "Alice Smith"}, {"alice-smith-2", "alice smith"}, {"default", "Default"}}, p.List())
+	})
+
+	t.Run("active profile is selected from header, cookie or user", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/profiles", nil)
+		assert.Equal(t, defaultProfile, p.Active(r))
+
+		r.Header.Set(profileHeader, "unknown")
+		assert.Equal(t, defaultProfile, p.Active(r))
+
+		r.Header.Set("Cookie", profileCookie+"=alice-smith-2")
+		assert.Equal(t, "alice-smith-2", p.Active(r))
+
+		r.Header.Set(profileHeader, "alice-smith")
+		assert.Equal(t, "alice-smith", p.Active(r))
+	})
+
+	t.Run("watched, favorites and searches are persisted", func(t *testing.T) {
+		found, err := p.SetWatched("alice-smith", "media/Films/Cars.mp4", true)
+		assert.True(t, found)
+		assert.NoError(t, err)
+		p.SetFavorite("alice-smith", "media/Films", true)
+		p.SetFavorite("alice-smith", "media/Films/Cars.mp4", true)
+		p.SetFavorite("alice-smith", "media/Films", false)
+		p.AddSearch("alice-smith", "cars")
+		p.AddSearch("alice-smith", "alien")
+		p.AddSearch("alice-smith", "cars")
+
+		found, _ = p.SetWatched("nobody", "media/Films/Cars.mp4", true)
+		assert.False(t, found)
+
+		reloaded, err := NewProfiles(file)
+		assert.NoError(t, err)
+		alice, ok := reloaded.Get("alice-smith")
+		if assert.True(t, ok) {
+			assert.Contains(t, alice.Watched, "media/Films/Cars.mp4")
+			assert.Equal(t, []string{"media/Films/Cars.mp4"}, alice.Favorites)
+			assert.Equal(t, []string{"cars", "alien"}, alice.RecentSearches)
+		}
+	})
+
+	t.Run("default profile can't be removed", func(t *testing.T) {
+		_, err := p.Remove(defaultProfile)
+		assert.IsType(t, &InvalidProfileError{}, err)
+
+		found, err := p.Remove("alice-smith-2")
+		assert.True(t, found)
+		assert.NoError(t, err)
+		_, ok := p.Get("alice-smith-2")
+		assert.False(t, ok)
+	})
+
+	t.Run("exported profile can be imported back", func(t *testing.T) {
+		alice, _ := p.Get("alice-smith")
+		alice.Id = "alice"
+		imported, err := p.Import(alice)
+		assert.NoError(t, err)
+		assert.Equal(t, alice.Favorites, imported.Favorites)
+
+		alice.Id = "../alice"
+		_, err = p.Import(alice)
+		assert.IsType(t, &InvalidProfileError{}, err)
+	})
+
+	t.Run("plays are tracked to resume them later", func(t *testing.T) {
+		path, _ := NewPathFromId("media/Films/Cars.mp4")
+		cars, _ := path.ToFile(true)
+
+		player := new(MockPlayer)
+		player.On("GetStatus").Return(NewPlayerStatus(cars, false, NewTimePosition(0, 10, 0, true), NewTimePosition(1, 40, 0, true))).Once()
+		player.On("GetStatus").Return(NewPlayerStatus(cars, false, NewTimePosition(1, 35, 0, true), NewTimePosition(1, 40, 0, true))).Once()
+		player.On("Accept", mock.Anything).Return(true)
+		dispatcher := NewPlayerDispatcher(player)
+		dispatcher.setCurrentPlayer(player)
+		targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+		targets.add("hdmi", "omx", dispatcher)
+
+		p.SetWatched("alice", "media/Films/Cars.mp4", false)
+		play := NewPlayerCommand("play", cars)
+		play.Profile = "alice"
+		p.observer("hdmi")(play, nil)
+
+		p.track(targets)
+		resume, ok := p.ResumePoint("alice", "media/Films/Cars.mp4")
+		assert.True(t, ok)
+		assert.Equal(t, ResumePoint{Seconds: 600, Length: 6000, Updated: resume.Updated}, resume)
+
+		resumed := NewPlayerCommand("play", cars)
+		resumed.Profile = "alice"
+		profiles = p
+		defer func() { profiles = nil }()
+		resumeFromProfile(&resumed)
+		assert.Equal(t, 600, startPosition(resumed))
+
+		p.track(targets)
+		_, ok = p.ResumePoint("alice", "media/Films/Cars.mp4")
+		assert.False(t, ok)
+		alice, _ := p.Get("alice")
+		assert.Contains(t, alice.Watched, "media/Films/Cars.mp4")
+	})
+
+	t.Run("users only use their own profile and the default one", func(t *testing.T) {
+		profiles = p
+		defer func() { profiles = nil }()
+
+		request := func(id string, role string) *http.Request {
+			r := httptest.NewRequest("GET", "/api/profiles/"+id+"/export", nil)
+			r.Header.Set(profileHeader, "alice-smith")
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			return r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: "alice", Role: role}))
+		}
+
+		assert.Equal(t, "alice", p.Active(request("current", RoleAdult)))
+		assert.Equal(t, "alice-smith", p.Active(request("current", RoleAdmin)))
+
+		for id, status := range map[string]int{"alice": 200, "default": 200, "current": 200, "alice-smith": 403} {
+			w := httptest.NewRecorder()
+			HandleExportProfile(w, request(id, RoleAdult))
+			assert.Equal(t, status, w.Code, id)
+		}
+
+		w := httptest.NewRecorder()
+		HandleProfiles(w, request("", RoleChild))
+		assert.JSONEq(t, `{"active": "alice", "profiles": [{"id": "alice", "name": "Alice Smith"}, {"id": "default", "name": "Default"}]}`, w.Body.String())
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+var scheduler *Scheduler
+
+// Sleep timer of each target
+var sleepTimers map[string]*SleepTimer
+
+// Sleep timers and scheduled commands. Must be registered after PlayerController.
+func ScheduleController(r *mux.Router) error {
+	glog.V(1).Infoln("Registering Schedule Controller")
+
+	var err error
+	if scheduler, err = NewScheduler(dataFile(schedulesFile), playerTargets); err != nil {
+		return err
+	}
+	go scheduler.Start()
+	onShutdown("scheduler", func() {
+		scheduler.Stop()
+		for _, timer := range sleepTimers {
+			timer.Cancel()
+		}
+	})
+
+	sleepTimers = make(map[string]*SleepTimer)
+	for name, d := range playerTargets.dispatchers {
+		sleepTimers[name] = NewSleepTimer(d)
+	}
+
+	r.Methods("GET").Path("/api/player/sleep").HandlerFunc(HandleSleepTimer)
+	r.Methods("POST").Path("/api/player/sleep").HandlerFunc(restricted(PermissionPlay, HandleSleepTimer))
+	r.Methods("DELETE").Path("/api/player/sleep").HandlerFunc(restricted(PermissionPlay, HandleSleepTimer))
+
+	r.Methods("GET").Path("/api/schedules").HandlerFunc(HandleSchedules)
+	r.Methods("POST").Path("/api/schedules").HandlerFunc(restricted(PermissionSchedule, HandlePutSchedule))
+	r.Methods("PUT").Path("/api/schedules/{id}").HandlerFunc(restricted(PermissionSchedule, HandlePutSchedule))
+	r.Methods("DELETE").Path("/api/schedules/{id}").HandlerFunc(restricted(PermissionSchedule, HandleDeleteSchedule))
+
+	glog.Info("Schedule controller loaded with ", len(scheduler.schedules), " schedules")
+	return nil
+}
+
+// Get (GET), set (POST) or cancel (DELETE) sleep timer of a target.
+// Timer is set with 'duration' parameter (i.e. 45m, 1h30m) or with 'endOfMedia=true'.
+func HandleSleepTimer(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = playerTargets.DefaultTarget()
+	}
+
+	timer, ok := sleepTimers[target]
+	if !ok {
+		failureResponse(r, &NotFoundError{"unknown player target: " + target}, w)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		if r.URL.Query().Get("endOfMedia") == "true" {
+			if err := timer.StopAtEndOfMedia(); err != nil {
+				failureResponse(r, err, w)
+				return
+			}
+		} else {
+			duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+			if err != nil || duration <= 0 {
+				failureResponse(r, &InvalidRequestError{"a positive 'duration' (i.e. 45m) or 'endOfMedia=true' is required"}, w)
+				return
+			}
+			timer.StopAfter(duration)
+		}
+
+	case "DELETE":
+		timer.Cancel()
+	}
+
+	respondWithJSON(w, 200, timer.Status())
+}
+
+func HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, 200, visibleSchedules(r, scheduler.List()))
+}
+
+// Schedules without those playing media of roots the caller can't see
+func visibleSchedules(r *http.Request, schedules []ScheduleDto) []ScheduleDto {
+	visible := visibleRoots(r)
+	if visible == nil {
+		return schedules
+	}
+
+	filtered := make([]ScheduleDto, 0, len(schedules))
+	for _, schedule := range schedules {
+		if schedule.Media != "" {
+			if path, err := NewPathFromId(schedule.Media); err != nil || !visible(path.Root) {
+				continue
+			}
+		}
+		filtered = append(filtered, schedule)
+	}
+	return filtered
+}
+
+// Create (POST) or replace (PUT) a schedule from JSON body
+func HandlePutSchedule(w http.ResponseWriter, r *http.Request) {
+	var schedule Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		failureResponse(r, &InvalidRequestError{"invalid schedule: " + err.Error()}, w)
+		return
+	}
+	schedule.Id = mux.Vars(r)["id"]
+	schedule.LastRun = nil
+	schedule.LastCommand = ""
+
+	if err := schedule.validate(playerTargets); err != nil {
+		failureResponse(r, &InvalidRequestError{err.Error()}, w)
+		return
+	}
+	if schedule.Media != "" {
+		path, _ := NewPathFromId(schedule.Media)
+		if err := checkRootAccess(r, path); err != nil {
+			failureResponse(r, err, w)
+			return
+		}
+	}
+
+	saved, err := scheduler.Put(schedule)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	code := 200
+	if r.Method == "POST" {
+		code = 201
+	}
+	dto := ScheduleDto{Schedule: saved}
+	if saved.Enabled {
+		dto.Next = saved.spec.Next(time.Now())
+	}
+	respondWithJSON(w, code, dto)
+}
+
+func HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	found, err := scheduler.Remove(id)
+	if err != nil {
+		failureResponse(r, err, w)
+	} else if !found {
+		failureResponse(r, &NotFoundError{"unknown schedule: " + id}, w)
+	} else {
+		respondWithJSON(w, 204, nil)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// File, in data directory, where schedules are persisted
+const schedulesFile = "schedules.json"
+
+// Player command to dispatch periodically, i.e. play music every weekday morning
+type Schedule struct {
+	Id   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	// When to run: cron-like expression, evaluated in local time
+	Cron string `json:"cron"`
+
+	// Command to dispatch: operation, on which target, and media (PathId) for 'play'
+	Operation string `json:"operation"`
+	Target    string `json:"target,omitempty"`
+	Media     string `json:"media,omitempty"`
+
+	Enabled bool `json:"enabled"`
+
+	// Last time the command has been dispatched, and its ID to query its result
+	LastRun     *time.Time `json:"lastRun,omitempty"`
+	LastCommand string     `json:"lastCommand,omitempty"`
+
+	spec *cronSpec
+}
+
+// Check the schedule can be run, and prepare its cron expression
+func (s *Schedule) validate(targets *PlayerTargets) error {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+
+	if !isPlayerOperation(s.Operation) {
+		return fmt.Errorf("unknown operation: '%s'", s.Operation)
+	}
+	if s.Operation == "play" && s.Media == "" {
+		return fmt.Errorf("media is required to schedule a play")
+	}
+	if s.Media != "" {
+		if _, err := NewPathFromId(s.Media); err != nil {
+			return err
+		}
+	}
+	if targets != nil {
+		if _, err := targets.Get(s.Target); err != nil {
+			return err
+		}
+	}
+
+	s.spec = spec
+	return nil
+}
+
+// Schedule DTO, built for REST API
+type ScheduleDto struct {
+	Schedule
+	Next *time.Time `json:"next,omitempty"`
+}
+
+// Dispatch commands of schedules at the time they are due. Schedules are persisted in a JSON file.
+type Scheduler struct {
+	file    string
+	targets *PlayerTargets
+	stopIt  chan bool
+
+	lock      sync.Mutex
+	schedules []*Schedule
+}
+
+// Create scheduler and load schedules previously saved in file
+func NewScheduler(file string, targets *PlayerTargets) (*Scheduler, error) {
+	s := &Scheduler{file: file, targets: targets, stopIt: make(chan bool, 1)}
+
+	var saved []*Schedule
+	if _, err := readJsonFile(file, &saved); err != nil {
+		return nil, fmt.Errorf("can't load schedules from %s: %s", file, err.Error())
+	}
+
+	for _, schedule := range saved {
+		if err := schedule.validate(targets); err != nil {
+			// Configuration may have changed (targets, roots), keep it but don't run it
+			glog.Warning("Schedule ", schedule.Id, " is disabled: ", err)
+			schedule.Enabled = false
+			if schedule.spec, err = parseCron(schedule.Cron); err != nil {
+				continue
+			}
+		}
+		s.schedules = append(s.schedules, schedule)
+	}
+
+	glog.V(1).Infoln("Loaded ", len(s.schedules), " schedules from ", file)
+	return s, nil
+}
+
+// Check schedules at the beginning of every minute, until stopped
+func (s *Scheduler) Start() {
+	for {
+		now := time.Now()
+		select {
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+			s.tick(time.Now())
+
+		case <-s.stopIt:
+			glog.Info("Scheduler stopped.")
+			return
+		}
+	}
+}
+
+func (s *Scheduler) Stop() {
+	select {
+	case s.stopIt <- true:
+	default:
+	}
+}
+
+// Dispatch commands of schedules due at that minute
+func (s *Scheduler) tick(now time.Time) {
+	minute := now.Truncate(time.Minute)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ran := false
+	for _, schedule := range s.schedules {
+		if !schedule.Enabled || !schedule.spec.Matches(minute) {
+			continue
+		}
+		if schedule.LastRun != nil && !schedule.LastRun.Before(minute) {
+			// already dispatched during this minute
+			continue
+		}
+
+		ran = true
+		schedule.LastRun = &minute
+		command, err := s.dispatch(schedule)
+		if err != nil {
+			glog.Error("Can't run schedule ", schedule.Id, " (", schedule.Name, "): ", err)
+			continue
+		}
+		schedule.LastCommand = command.Id
+		glog.Info("Schedule ", schedule.Id, " (", schedule.Name, ") dispatched ", command.Operation, " (", command.Id, ")")
+	}
+
+	if ran {
+		if err := s.save(); err != nil {
+			glog.Error("Can't save schedules: ", err)
+		}
+	}
+}
+
+// Build the command as if it was requested from the API, and dispatch it to schedule target
+func (s *Scheduler) dispatch(schedule *Schedule) (PlayerCommand, error) {
+	command := NewPlayerCommand(schedule.Operation)
+
+	dispatcher, err := s.targets.Get(schedule.Target)
+	if err != nil {
+		return command, err
+	}
+
+	if schedule.Media != "" {
+		path, err := NewPathFromId(schedule.Media)
+		if err != nil {
+			return command, err
+		}
+		if command.File, err = path.ToFile(true); err != nil {
+			return command, err
+		}
+	}
+
+	return command, dispatcher.Dispatch(command)
+}
+
+// All schedules, with their next run
+func (s *Scheduler) List() []ScheduleDto {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	schedules := make([]ScheduleDto, len(s.schedules))
+	for i, schedule := range s.schedules {
+		schedules[i] = ScheduleDto{Schedule: *schedule}
+		if schedule.Enabled {
+			schedules[i].Next = schedule.spec.Next(now)
+		}
+	}
+	return schedules
+}
+
+// Add a new schedule, or replace the one with same ID. Schedule must have been validated.
+func (s *Scheduler) Put(schedule Schedule) (Schedule, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if schedule.Id == "" {
+		schedule.Id = newCommandId()
+	}
+
+	replaced := false
+	for i, existing := range s.schedules {
+		if existing.Id == schedule.Id {
+			s.schedules[i] = &schedule
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.schedules = append(s.schedules, &schedule)
+	}
+
+	return schedule, s.save()
+}
+
+// Remove a schedule, return false if it doesn't exist
+func (s *Scheduler) Remove(id string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, schedule := range s.schedules {
+		if schedule.Id == id {
+			s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+			return true, s.save()
+		}
+	}
+	return false, nil
+}
+
+// Write schedules in file. Lock must be held.
+func (s *Scheduler) save() error {
+	return writeJsonFile(s.file, s.schedules)
+}
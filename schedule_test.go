@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-schedules")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "schedules.json")
+
+	dispatcher := NewPlayerDispatcher(new(MockPlayer))
+	targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+	targets.add("hdmi", "omx", dispatcher)
+
+	scheduler, err := NewScheduler(file, targets)
+	assert.NoError(t, err)
+
+	schedule := Schedule{Name: "bed time", Cron: "0 23 * * *", Operation: "stop", Enabled: true}
+	assert.NoError(t, schedule.validate(targets))
+	saved, err := scheduler.Put(schedule)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, saved.Id)
+
+	t.Run("it should dispatch command when schedule is due", func(t *testing.T) {
+		scheduler.tick(time.Date(2018, 10, 1, 22, 59, 0, 0, time.Local))
+		assert.Len(t, dispatcher.commands, 0)
+
+		scheduler.tick(time.Date(2018, 10, 1, 23, 0, 1, 0, time.Local))
+		if assert.Len(t, dispatcher.commands, 1) {
+			command := <-dispatcher.commands
+			assert.Equal(t, "stop", command.Operation)
+			assert.Equal(t, "hdmi", command.Result().Target)
+			assert.Equal(t, command.Id, scheduler.List()[0].LastCommand)
+		}
+	})
+
+	t.Run("it should dispatch command only once per minute", func(t *testing.T) {
+		scheduler.tick(time.Date(2018, 10, 1, 23, 0, 30, 0, time.Local))
+		assert.Len(t, dispatcher.commands, 0)
+	})
+
+	t.Run("it should reload schedules after restart", func(t *testing.T) {
+		reloaded, err := NewScheduler(file, targets)
+		if assert.NoError(t, err) && assert.Len(t, reloaded.List(), 1) {
+			dto := reloaded.List()[0]
+			assert.Equal(t, saved.Id, dto.Id)
+			assert.Equal(t, "bed time", dto.Name)
+			assert.True(t, dto.Enabled)
+			assert.Equal(t, 23, dto.Next.Hour())
+			assert.NotNil(t, dto.LastRun)
+		}
+	})
+
+	t.Run("it should disable schedules on unknown target after restart", func(t *testing.T) {
+		otherTargets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+		otherTargets.add("kitchen", "renderer", NewPlayerDispatcher())
+
+		scheduler.Put(Schedule{Id: saved.Id, Cron: "0 23 * * *", Operation: "stop", Target: "hdmi", Enabled: true, spec: saved.spec})
+		reloaded, err := NewScheduler(file, otherTargets)
+		if assert.NoError(t, err) && assert.Len(t, reloaded.List(), 1) {
+			assert.False(t, reloaded.List()[0].Enabled)
+		}
+	})
+
+	t.Run("it should remove schedule", func(t *testing.T) {
+		found, err := scheduler.Remove(saved.Id)
+		assert.True(t, found)
+		assert.NoError(t, err)
+
+		reloaded, _ := NewScheduler(file, targets)
+		assert.Empty(t, reloaded.List())
+	})
+}
+
+func Test_visibleSchedules(t *testing.T) {
+	previousRoots := roots
+	roots = map[string]Path{"music": {Root: "music", localPath: "/mnt/music"}, "films": {Root: "films", localPath: "/mnt/films"}}
+	defer func() { roots = previousRoots }()
+	assert.NoError(t, ConfigureRoleRoots("child:music"))
+	defer ConfigureRoleRoots("")
+
+	schedules := []ScheduleDto{
+		{Schedule: Schedule{Id: "bed-time", Operation: "stop"}},
+		{Schedule: Schedule{Id: "wake-up", Operation: "play", Media: "music/Morning.mp3"}},
+		{Schedule: Schedule{Id: "movie-night", Operation: "play", Media: "films/Alien.mkv"}},
+	}
+
+	r := httptest.NewRequest("GET", "/api/schedules", nil)
+	assert.Len(t, visibleSchedules(r, schedules), 3, "all schedules are visible without authentication")
+
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{User: "kid", Role: RoleChild}))
+	visible := visibleSchedules(r, schedules)
+	if assert.Len(t, visible, 2) {
+		assert.Equal(t, "bed-time", visible[0].Id)
+		assert.Equal(t, "wake-up", visible[1].Id)
+	}
+}
+
+func TestSchedule_validate(t *testing.T) {
+	targets := &PlayerTargets{kinds: make(map[string]string), dispatchers: make(map[string]*PlayerDispatcher)}
+	targets.add("hdmi", "omx", NewPlayerDispatcher())
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		valid    bool
+	}{
+		{"valid stop", Schedule{Cron: "0 23 * * *", Operation: "stop"}, true},
+		{"invalid cron", Schedule{Cron: "0 25 * * *", Operation: "stop"}, false},
+		{"unknown operation", Schedule{Cron: "0 23 * * *", Operation: "shutdown"}, false},
+		{"play without media", Schedule{Cron: "0 7 * * *", Operation: "play"}, false},
+		{"unknown target", Schedule{Cron: "0 23 * * *", Operation: "stop", Target: "kitchen"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schedule.validate(targets)
+			assert.Equal(t, tt.valid, err == nil, "error: %v", err)
+		})
+	}
+}
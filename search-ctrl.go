@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/golang/glog"
 	"net/http"
 	"os"
 	"sync"
-	"path/filepath"
 	"time"
 	"strings"
 	"sort"
@@ -16,40 +17,103 @@ import (
 func SearchController(r *mux.Router) error {
 	glog.V(1).Infoln("Registering Search Controller")
 
+	r.PathPrefix("/api/search").Queries("q", "").HandlerFunc(SearchMedia)
 	r.PathPrefix("/api/search").Queries("pattern", "").HandlerFunc(SearchMedia)
 
 	return nil
 }
 
+// Search with an expression in 'q' (see parseSearchQuery), or with a simple name 'pattern'. Results are sorted and
+// paginated with 'sort', 'order', 'offset' and 'limit'; or streamed while they are found with 'stream=ndjson' or
+// 'stream=sse' (or matching Accept header), then 'limit' stops the search early. Words are searched in relative paths
+// with 'match=path'.
 func SearchMedia(writer http.ResponseWriter, request *http.Request) {
-	patterns := request.URL.Query()["pattern"]
-	if len(patterns) <= 0 || len(patterns[0]) < 3 {
-		respondWithJSON(writer, 400, map[string]string{"error": "'pattern' query parameter is required and must at least have 3 chars"})
+	search := request.URL.Query().Get("q")
+	var query *searchQuery
+	if search != "" {
+		var err error
+		if query, err = parseSearchQuery(search); err != nil {
+			failureResponse(request, err, writer)
+			return
+		}
+	} else {
+		search = request.URL.Query().Get("pattern")
+		query = newSearchQuery(search)
+	}
+
+	switch request.URL.Query().Get("match") {
+	case "", "name":
+	case "path":
+		query.paths = true
+	default:
+		failureResponse(request, &InvalidRequestError{"'match' must be name or path"}, writer)
 		return
 	}
 
-	filter := func(name string) bool {
-		return filterName(patterns[0], name)
+	if !query.isSelective(3) {
+		failureResponse(request, &InvalidRequestError{"'q' or 'pattern' query parameter is required and must at least have 3 chars or a filter"}, writer)
+		return
 	}
 
-	files := StartSearching(filter, getRoots())
-	glog.Info("Search of ", patterns[0], " returned ", len(files), " medias.")
-	respondWithJSON(writer, 200, files)
+	page, err := parsePage(request)
+	var less fileLess
+	if err == nil {
+		less, err = parseFileOrder(request, sortRelevance, sortRelevance, sortName, sortNatural, sortModified, sortSize)
+	}
+	var fields fileFields
+	if err == nil {
+		fields, err = parseFileFields(request)
+	}
+	if err != nil {
+		failureResponse(request, &InvalidRequestError{err.Error()}, writer)
+		return
+	}
+
+	viewer := requestViewer(request)
+	options := searchOptions{
+		ctx:        request.Context(),
+		less:       less,
+		fields:     fields,
+		showHidden: showHidden(request),
+		visible: func(file File) bool {
+			return parental.Visible(viewer, file) == nil
+		},
+	}
+	if profiles != nil {
+		profiles.AddSearch(profiles.Active(request), search)
+	}
+
+	stream := newResultStream(writer, request)
+	if stream == nil {
+		files := StartSearching(query, getRoots(visibleRoots(request)), options)
+		glog.Info("Search of ", search, " returned ", len(files), " medias.")
+		respondWithJSON(writer, 200, paginate(writer, page, files))
+		return
+	}
+
+	// Streamed results can't be sorted nor counted: limit stops the search instead
+	options.stream = stream.send
+	options.limit = page.limit
+	StartSearching(query, getRoots(visibleRoots(request)), options)
+	stream.end()
+	glog.Info("Search of ", search, " streamed ", stream.count, " medias.")
 }
 
-// Get roots using public model functions
-func getRoots() map[string]string {
+// Get roots using public model functions, only the visible ones
+func getRoots(visible RootPredicate) map[string]string {
 	r := make(map[string]string, len(roots))
 	for name, p := range roots {
-		r[name] = p.localPath
+		if visible == nil || visible(name) {
+			r[name] = p.localPath
+		}
 	}
 
 	return r
 }
 
-// Test if pattern is found in given name
+// Test if all words of pattern are found in given name, ignoring case, accents and typos
 func filterName(pattern string, name string) bool {
-	return strings.Contains(strings.ToLower(name), strings.ToLower(pattern))
+	return newSearchQuery(pattern).matches(name)
 }
 
 const (
@@ -58,7 +122,26 @@ const (
 	searchBatch         = 10
 )
 
-type NamePredicate func(name string) bool
+// Returned by walkers to stop walking when search is cancelled
+var errSearchCancelled = errors.New("search cancelled")
+
+// Options of a search run
+type searchOptions struct {
+	// Cancels the search when done (client gone away), optional
+	ctx context.Context
+	// Walk stops once this number of results is found, 0 for no limit
+	limit int
+	// Hide some results, all are visible when nil
+	visible func(file File) bool
+	// Receives results as soon as they are found, unsorted. StartSearching returns sorted results otherwise.
+	stream func(dto FileDto)
+	// Order of returned results, by relevance when nil
+	less fileLess
+	// Details of results
+	fields fileFields
+	// Search ignored files too
+	showHidden bool
+}
 
 type fileSearch struct {
 	searchLoaderRoutine int
@@ -70,14 +153,57 @@ type fileSearch struct {
 
 	// Media loader function
 	mediaLoader func(buffer [64]string, length int, files chan File)
+
+	// Relevance of a found media, from its path relative to root. Results are sorted by name when nil
+	scorer func(path string) float64
+
+	// Closed when search is cancelled: routines stop without waiting the end of the walk
+	done   <-chan struct{}
+	cancel func()
+
+	limit   int
+	visible func(file File) bool
+	stream  func(dto FileDto)
+	less    fileLess
+	fields  fileFields
+
+	showHidden bool
 }
 
-func StartSearching(acceptanceCriteria NamePredicate, roots map[string]string) []FileDto {
+func StartSearching(query *searchQuery, roots map[string]string, options searchOptions) []FileDto {
+	start := time.Now()
+
+	parent := options.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	count := 0
+	stream := options.stream
+	if stream != nil {
+		stream = func(dto FileDto) {
+			count++
+			options.stream(dto)
+		}
+	}
 
 	fileSearch := fileSearch{
 		foundFileIds: make(chan string, searchBuffer),
 		foundMedia:   make(chan File, searchBuffer),
 		mediaLoader:  loadBatch,
+		scorer:       query.scorePath,
+
+		done:    ctx.Done(),
+		cancel:  cancel,
+		limit:   options.limit,
+		visible: options.visible,
+		stream:  stream,
+		less:    options.less,
+		fields:  options.fields,
+
+		showHidden: options.showHidden,
 
 		searchLoaderRoutine: searchLoaderRoutine,
 		searchBuffer:        searchBuffer,
@@ -88,18 +214,19 @@ func StartSearching(acceptanceCriteria NamePredicate, roots map[string]string) [
 	response := make(chan []FileDto)
 	go fileSearch.buildResponse(response)
 
-	// Start loaders routines - stop driven by global quit
+	// Start loaders routines - stop when file ids channel is closed, or search is cancelled
 	wgLoaders := new(sync.WaitGroup)
 	wgLoaders.Add(searchLoaderRoutine)
 	for i := 0; i < searchLoaderRoutine; i++ {
 		go fileSearch.startLoading(wgLoaders)
 	}
 
-	// Start file walkers - stop by themselves
+	// Start file walkers - stop by themselves, or when search is cancelled
+	roots = query.filterRoots(roots)
 	wgWalkers := new(sync.WaitGroup)
 	wgWalkers.Add(len(roots))
 	for root, path := range roots {
-		go fileSearch.walkThrow(root, path, acceptanceCriteria, wgWalkers)
+		go fileSearch.walkThrow(root, path, query.accept, wgWalkers)
 	}
 
 	// Waiting end of routines
@@ -109,33 +236,57 @@ func StartSearching(acceptanceCriteria NamePredicate, roots map[string]string) [
 	close(fileSearch.foundMedia)
 
 	// Return completed results
-	return <-response
+	medias := <-response
+	if stream == nil {
+		count = len(medias)
+	}
+	searchDuration.Observe(time.Since(start).Seconds())
+	searchResults.Observe(float64(count))
+	return medias
+}
+
+// Search has been cancelled: client has gone away, or limit is reached
+func (s *fileSearch) cancelled() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
 }
 
-// scan recursively all files, add in channel non-dir file accepted by criteria
-func (s *fileSearch) walkThrow(root string, rootPath string, acceptanceCriteria NamePredicate, group *sync.WaitGroup) {
+// scan recursively all files, add in channel files and directories accepted by criteria. Ignored files are skipped.
+func (s *fileSearch) walkThrow(root string, rootPath string, acceptanceCriteria FilePredicate, group *sync.WaitGroup) {
 	defer group.Done()
 
-	err := filepath.Walk(rootPath, func(path string, f os.FileInfo, err error) error {
+	err := walkRoot(root, rootPath, s.showHidden, func(path string, relative string, f os.FileInfo, err error) error {
+		if s.cancelled() {
+			return errSearchCancelled
+		}
+
 		switch {
 		case f == nil:
 			glog.Warning("Can't stats file '"+path+"': ", err)
 			return nil
 
-		case f.IsDir() && strings.HasPrefix(path, "."):
-			// Skip hidden files
-			return filepath.SkipDir
+		case relative == "":
+			// root itself is never a result
+			return nil
+		}
 
-		case !f.IsDir() && acceptanceCriteria(f.Name()):
-			s.foundFileIds <- root + "/" + strings.Trim(strings.TrimPrefix(path, rootPath), "/")
+		if !acceptanceCriteria(root, relative, f) {
 			return nil
+		}
 
-		default:
+		select {
+		case s.foundFileIds <- root + "/" + relative:
 			return nil
+		case <-s.done:
+			return errSearchCancelled
 		}
 	})
 
-	if err != nil {
+	if err != nil && err != errSearchCancelled {
 		glog.Error("Couldn't complete ", rootPath, " scan because: ", err, ".")
 	}
 }
@@ -176,29 +327,114 @@ func (s *fileSearch) startLoading(group *sync.WaitGroup) {
 			}
 		}
 
-		// Load batch
+		// Load batch, unless nobody is waiting for it anymore
+		if s.cancelled() {
+			return
+		}
 		s.mediaLoader(buffer, length, s.foundMedia)
 	}
 }
 func (s *fileSearch) buildResponse(response chan []FileDto) {
-	// TRUE if f1 > f2 FIXME this code already somewhere else
-	compareFile := func(f1, f2 FileDto) bool { return strings.ToLower(f1.Name) > strings.ToLower(f2.Name) }
+	less := s.less
+	if less == nil {
+		less = thenByName(fileOrders[sortRelevance].less, true)
+	}
 
-	// Note: would certainly be faster to sort at the end using sorting algorithm!
 	var medias []FileDto
+	var keys []sortKey
+	count := 0
 	for media := range s.foundMedia {
-		dto := NewFileDto(media)
+		// keep draining channel so that loaders are never blocked
+		if s.cancelled() || (s.limit > 0 && count >= s.limit) || (s.visible != nil && !s.visible(media)) {
+			continue
+		}
+
+		dto := NewFileDtoWith(media, s.fields)
+		if s.scorer != nil {
+			dto.Score = s.scorer(joinNotEmpty([]string{media.Path().MiddlePath, media.Path().Name}, "/"))
+		}
+
+		count++
+		if s.limit > 0 && count >= s.limit && s.cancel != nil {
+			s.cancel()
+		}
+		if s.stream != nil {
+			s.stream(dto)
+			continue
+		}
 
-		index := sort.Search(len(medias), func(i int) bool { return compareFile(medias[i], dto) })
-		medias = append(medias, FileDto{})
-		copy(medias[index+1:], medias[index:])
-		medias[index] = dto
+		medias = append(medias, dto)
+		keys = append(keys, fileSortKey(media, dto.Score))
 	}
 
-	response <- medias
+	sort.Sort(&resultSorter{medias, keys, less})
+	response <- groupByFolder(medias)
 	glog.Info("Ends of buildResponse")
 }
 
+// Nest results found in a matching directory as its children, so that a series folder isn't drowned among its
+// episodes. Results are nested in their top most matching directory, keeping their order.
+func groupByFolder(results []FileDto) []FileDto {
+	dirs := make(map[string]bool)
+	for _, r := range results {
+		if r.Type == "dir" {
+			dirs[r.PathId] = true
+		}
+	}
+
+	var grouped []FileDto
+	children := make(map[string][]FileDto)
+	for _, r := range results {
+		top := ""
+		for parent := parentPathId(r.PathId); parent != ""; parent = parentPathId(parent) {
+			if dirs[parent] {
+				top = parent
+			}
+		}
+
+		if top == "" {
+			grouped = append(grouped, r)
+		} else {
+			r.Children = nil
+			children[top] = append(children[top], r)
+		}
+	}
+
+	for i := range grouped {
+		if c, ok := children[grouped[i].PathId]; ok {
+			grouped[i].Children = c
+		}
+	}
+	return grouped
+}
+
+func parentPathId(pathId string) string {
+	if i := strings.LastIndex(pathId, "/"); i > 0 {
+		return pathId[:i]
+	}
+	return ""
+}
+
+// Sort search results, with their sort keys
+type resultSorter struct {
+	medias []FileDto
+	keys   []sortKey
+	less   fileLess
+}
+
+func (s *resultSorter) Len() int {
+	return len(s.medias)
+}
+
+func (s *resultSorter) Swap(i, j int) {
+	s.medias[i], s.medias[j] = s.medias[j], s.medias[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s *resultSorter) Less(i, j int) bool {
+	return s.less(s.keys[i], s.keys[j])
+}
+
 func loadBatch(buffer [64]string, length int, files chan File) {
 	for i := 0; i < length; i++ {
 		pathId := buffer[i]
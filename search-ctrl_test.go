@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -24,8 +31,8 @@ func Test_fileSearch_walkThrow(t *testing.T) {
 		}()
 
 		// When
-		predicate := func(name string) bool {
-			return strings.HasPrefix(name, "search-ctrl")
+		predicate := func(root string, _ string, f os.FileInfo) bool {
+			return root == "foobar" && strings.HasPrefix(f.Name(), "search-ctrl")
 		}
 		s.walkThrow("foobar", workingDir(), predicate, wg)
 
@@ -167,6 +174,29 @@ func Test_fileSearch_buildResponse(t *testing.T) {
 	}
 }
 
+func Test_fileSearch_buildResponse_relevance(t *testing.T) {
+	s := fileSearch{
+		foundMedia: make(chan File, 10),
+		scorer:     newSearchQuery("amelie").score,
+	}
+
+	resp := make(chan []FileDto)
+	go s.buildResponse(resp)
+
+	s.foundMedia <- NewMedia(Path{Name: "Amélie.Making.Of.mkv"})
+	s.foundMedia <- NewMedia(Path{Name: "Amelei.mkv"})
+	s.foundMedia <- NewMedia(Path{Name: "Amélie.mkv"})
+	close(s.foundMedia)
+
+	medias := <-resp
+	if assert.Len(t, medias, 3) {
+		for i, v := range []string{"Amélie.mkv", "Amélie.Making.Of.mkv", "Amelei.mkv"} {
+			assert.Equal(t, v, medias[i].Name)
+			assert.True(t, medias[i].Score > 0)
+		}
+	}
+}
+
 func Test_filterName(t *testing.T) {
 	type args struct {
 		pattern string
@@ -183,6 +213,9 @@ func Test_filterName(t *testing.T) {
 		{"it should match when middle", args{"bar", "foobarbaz"}, true},
 		{"it should be case insensitive", args{"BAr", "fooBaRbaz"}, true},
 		{"it should not match when not contained", args{"fobar", "foobarbaz"}, false},
+		{"it should ignore accents", args{"amelie", "Amélie.2001.mkv"}, true},
+		{"it should match words in any order", args{"rings lord", "The.Lord.of.the.Rings.mkv"}, true},
+		{"it should tolerate typos", args{"amelei", "Amélie.2001.mkv"}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -192,3 +225,138 @@ func Test_filterName(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchMedia_syntaxError(t *testing.T) {
+	w := httptest.NewRecorder()
+	SearchMedia(w, httptest.NewRequest("GET", "/api/search?q="+url.QueryEscape("type:video year:>abc"), nil))
+
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"code": "invalid_request", "error": "year must have 4 digits", "token": "year:>abc", "position": 11}`, w.Body.String())
+}
+
+func TestStartSearching_limit(t *testing.T) {
+	_, clean := rootFixture(t, "films", "Cars.mp4", "Cars 2.mp4", "Cars 3.mp4", "Carsten.mp4", "Up.mp4")
+	defer clean()
+
+	all := StartSearching(newSearchQuery("cars"), getRoots(nil), searchOptions{})
+	assert.Len(t, all, 4)
+
+	limited := StartSearching(newSearchQuery("cars"), getRoots(nil), searchOptions{limit: 2})
+	assert.Len(t, limited, 2)
+
+	hidden := StartSearching(newSearchQuery("cars"), getRoots(nil), searchOptions{
+		limit:   2,
+		visible: func(file File) bool { return file.Path().Name != "Cars.mp4" },
+	})
+	if assert.Len(t, hidden, 2, "limit counts visible results only") {
+		assert.NotEqual(t, "Cars.mp4", hidden[0].Name)
+		assert.NotEqual(t, "Cars.mp4", hidden[1].Name)
+	}
+}
+
+func TestStartSearching_cancelled(t *testing.T) {
+	_, clean := rootFixture(t, "films", "Cars.mp4", "Cars 2.mp4")
+	defer clean()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	finished := make(chan []FileDto)
+	go func() {
+		finished <- StartSearching(newSearchQuery("cars"), getRoots(nil), searchOptions{ctx: ctx})
+	}()
+
+	select {
+	case medias := <-finished:
+		assert.Empty(t, medias)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Search should stop when its context is cancelled")
+	}
+}
+
+func TestSearchMedia_stream(t *testing.T) {
+	_, clean := rootFixture(t, "films", "Cars.mp4", "Cars 2.mp4", "Up.mp4")
+	defer clean()
+
+	w := httptest.NewRecorder()
+	SearchMedia(w, httptest.NewRequest("GET", "/api/search?q=cars&stream=ndjson", nil))
+
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var dto FileDto
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &dto))
+		assert.Contains(t, dto.Name, "Cars")
+	}
+
+	r := httptest.NewRequest("GET", "/api/search?q=cars&limit=1", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w = httptest.NewRecorder()
+	SearchMedia(w, r)
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "event: result\n"))
+	assert.Contains(t, w.Body.String(), "event: end\ndata: {\"count\":1}\n\n")
+}
+
+func Test_groupByFolder(t *testing.T) {
+	results := []FileDto{
+		{Type: "dir", PathId: "films/Breaking Bad", Name: "Breaking Bad"},
+		{Type: "media", PathId: "films/Breaking.Bad.Making.Of.mkv", Name: "Breaking.Bad.Making.Of.mkv"},
+		{Type: "media", PathId: "films/Breaking Bad/Season 1/S01E01.mkv", Name: "S01E01.mkv"},
+		{Type: "dir", PathId: "films/Breaking Bad/Season 1", Name: "Season 1"},
+		{Type: "media", PathId: "films/Breaking Bad/S02E01.mkv", Name: "S02E01.mkv"},
+	}
+
+	grouped := groupByFolder(results)
+	if assert.Len(t, grouped, 2) {
+		assert.Equal(t, "films/Breaking Bad", grouped[0].PathId)
+		assert.Equal(t, "films/Breaking.Bad.Making.Of.mkv", grouped[1].PathId)
+
+		var children []string
+		for _, c := range grouped[0].Children {
+			children = append(children, c.Name)
+		}
+		assert.Equal(t, []string{"S01E01.mkv", "Season 1", "S02E01.mkv"}, children, "nested in top most folder, in same order")
+	}
+}
+
+func TestStartSearching_directories(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-search")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "Breaking Bad", "Season 1"), 0755)
+	os.MkdirAll(filepath.Join(dir, "Other"), 0755)
+	for _, name := range []string{"Breaking Bad/Season 1/S01E01.mkv", "Breaking.Bad.Making.Of.mkv", "Other/S01E01.mkv"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("fake movie"), 0644)
+	}
+	roots = map[string]Path{"films": {Root: "films", localPath: dir}}
+
+	search := func(expression string, paths bool) []FileDto {
+		query, err := parseSearchQuery(expression)
+		assert.NoError(t, err)
+		query.paths = paths
+		return StartSearching(query, getRoots(nil), searchOptions{})
+	}
+
+	byName := search("breaking bad", false)
+	if assert.Len(t, byName, 2) {
+		assert.Equal(t, "dir", byName[0].Type)
+		assert.Equal(t, "films/Breaking Bad", byName[0].PathId)
+		assert.Empty(t, byName[0].Children)
+		assert.Equal(t, "films/Breaking.Bad.Making.Of.mkv", byName[1].PathId)
+	}
+
+	inPaths := search("breaking s01e01", true)
+	if assert.Len(t, inPaths, 1) {
+		assert.Equal(t, "films/Breaking Bad/Season 1/S01E01.mkv", inPaths[0].PathId)
+	}
+
+	grouped := search("breaking bad", true)
+	if assert.Len(t, grouped, 2) {
+		assert.Equal(t, "films/Breaking Bad", grouped[0].PathId)
+		assert.Len(t, grouped[0].Children, 2, "season and episode are grouped in series folder")
+	}
+
+	assert.Len(t, search("type:dir season", false), 1)
+	assert.Len(t, search("type:video s01e01", false), 2)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Latin letters folded to ASCII, so that "amelie" finds "Amélie"
+var foldedLetters = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ŕ': "r", 'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Lower case text, without accents. Combining marks of decomposed names (as written by macOS) are dropped.
+func foldText(text string) string {
+	folded := make([]rune, 0, len(text))
+	for _, r := range strings.ToLower(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if ascii, ok := foldedLetters[r]; ok {
+			folded = append(folded, []rune(ascii)...)
+		} else {
+			folded = append(folded, r)
+		}
+	}
+	return string(folded)
+}
+
+// Words of folded text: anything but letters and digits is a separator (dots, underscores, dashes, spaces, ...)
+func tokenize(text string) []string {
+	return strings.FieldsFunc(foldText(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Tokens of a search, all of them must be found in a name (or in relative path when paths is set). Filters and
+// roots come from search expressions.
+type searchQuery struct {
+	tokens  []string
+	phrases [][]string
+	filters []FilePredicate
+	roots   []string
+	paths   bool
+}
+
+func newSearchQuery(pattern string) *searchQuery {
+	tokens := tokenize(pattern)
+	if len(tokens) == 0 && strings.TrimSpace(pattern) != "" {
+		// only separators: look for them as is
+		tokens = []string{foldText(strings.TrimSpace(pattern))}
+	}
+	return &searchQuery{tokens: tokens}
+}
+
+// Name contains all tokens, in any order, with typos. Phrases must be found as is.
+func (q *searchQuery) matches(name string) bool {
+	if len(q.tokens) == 0 {
+		return true
+	}
+	if q.score(name) == 0 {
+		return false
+	}
+
+	if len(q.phrases) > 0 {
+		words := " " + strings.Join(tokenize(name), " ") + " "
+		for _, phrase := range q.phrases {
+			if !strings.Contains(words, " "+strings.Join(phrase, " ")+" ") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Relevance of name, between 0 (no match) and 1 (same words). Words found as is score better than the ones found
+// in other words or with typos; names made of less other words and with words in query order score better.
+func (q *searchQuery) score(name string) float64 {
+	if len(q.tokens) == 0 {
+		return 0
+	}
+
+	folded := foldText(name)
+	words := tokenize(folded)
+
+	total := 0.0
+	matched := make(map[int]bool)
+	inOrder, last := true, -1
+	for _, token := range q.tokens {
+		score, word := tokenScore(token, words, folded)
+		if score == 0 {
+			return 0
+		}
+		total += score
+
+		if word >= 0 {
+			matched[word] = true
+			inOrder = inOrder && word > last
+			last = word
+		}
+	}
+
+	relevance := 0.7 * total / float64(len(q.tokens))
+	if len(words) > 0 {
+		relevance += 0.2 * float64(len(matched)) / float64(len(words))
+	}
+	if inOrder {
+		relevance += 0.1
+	}
+	return math.Floor(relevance*1000+0.5) / 1000
+}
+
+// Best score of a query token in a name, with index of the word it's found in (-1 when made of separators only)
+func tokenScore(token string, words []string, folded string) (float64, int) {
+	best, bestWord := 0.0, -1
+	for i, word := range words {
+		score := 0.0
+		switch {
+		case word == token:
+			score = 1
+		case strings.HasPrefix(word, token):
+			score = 0.9
+		case strings.Contains(word, token):
+			score = 0.7
+		default:
+			if d := typos(token, word); d <= allowedTypos(token) {
+				score = 0.6 - 0.15*float64(d)
+			}
+		}
+
+		if score > best {
+			best, bestWord = score, i
+		}
+	}
+
+	if best == 0 && strings.Contains(folded, token) {
+		best = 0.6
+	}
+	return best, bestWord
+}
+
+// Short tokens must be exact, longer ones tolerate one typo, two from 8 letters
+func allowedTypos(token string) int {
+	switch n := len([]rune(token)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Typos between a token and a word: edit distance with the whole word, or with its beginning when token is shorter
+func typos(token string, word string) int {
+	t, w := []rune(token), []rune(word)
+	d := editDistance(t, w)
+	if len(w) > len(t) {
+		if prefix := editDistance(t, w[:len(t)]); prefix < d {
+			d = prefix
+		}
+	}
+	return d
+}
+
+// Optimal string alignment distance: insertions, deletions, substitutions and transpositions of adjacent letters
+func editDistance(a []rune, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := min3(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && rows[i-2][j-2]+1 < d {
+				d = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
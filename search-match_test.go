@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_foldText(t *testing.T) {
+	assert.Equal(t, "amelie poulain", foldText("Amélie Poulain"))
+	assert.Equal(t, "amelie", foldText("Amélie"), "combining accent of decomposed name")
+	assert.Equal(t, "coeur strasse", foldText("Cœur Straße"))
+}
+
+func Test_tokenize(t *testing.T) {
+	assert.Equal(t, []string{"the", "lord", "of", "the", "rings", "2001", "mkv"}, tokenize("The.Lord.of.the_Rings-2001.mkv"))
+	assert.Empty(t, tokenize("._-"))
+}
+
+func Test_searchQuery_matches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"amelie", "Amélie.2001.mkv", true},
+		{"lord rings", "The.Lord.of.the.Rings.mkv", true},
+		{"rings lord", "The.Lord.of.the.Rings.mkv", true},
+		{"lord of the", "The.Lord.of.the.Rings.mkv", true},
+		{"lord hobbit", "The.Lord.of.the.Rings.mkv", false},
+		{"amelei", "Amélie.mkv", true},
+		{"intersteller", "Interstellar (2014).mkv", true},
+		{"intersellr", "Interstellar (2014).mkv", true},
+		{"cra", "Cars.mp4", false},
+		{"ring.lord", "The.Lord.of.the.Rings.mkv", true},
+		{"of.the", "The.Lord.of.the.Rings.mkv", true},
+		{"...", "Cars...mp4", true},
+		{"", "Cars.mp4", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, newSearchQuery(tt.pattern).matches(tt.name), "'%s' in '%s'", tt.pattern, tt.name)
+	}
+}
+
+func Test_searchQuery_score(t *testing.T) {
+	query := newSearchQuery("amelie")
+
+	exact := query.score("Amélie.mkv")
+	longer := query.score("Amélie.Making.Of.mkv")
+	prefix := query.score("Amelies.mkv")
+	typo := query.score("Amelei.mkv")
+
+	assert.True(t, exact <= 1)
+	assert.True(t, exact > longer, "less other words is better")
+	assert.True(t, longer > prefix, "whole word is better than prefix")
+	assert.True(t, prefix > typo, "prefix is better than typo")
+	assert.True(t, typo > 0)
+
+	ordered := newSearchQuery("lord rings").score("The.Lord.of.the.Rings.mkv")
+	reversed := newSearchQuery("rings lord").score("The.Lord.of.the.Rings.mkv")
+	assert.True(t, ordered > reversed, "words in query order are better")
+}
+
+func Test_editDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance([]rune("cars"), []rune("cars")))
+	assert.Equal(t, 1, editDistance([]rune("cras"), []rune("cars")), "transposition")
+	assert.Equal(t, 1, editDistance([]rune("car"), []rune("cars")))
+	assert.Equal(t, 3, editDistance([]rune("kitten"), []rune("sitting")))
+}
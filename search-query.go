@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search expressions mix words, "quoted phrases" and filters on file stats and names:
+//   type:video ext:mkv year:>2010 size:>2GB root:data modified:<30d "star wars"
+
+// Criteria on a file or directory found while walking roots, evaluated with the stats given by the walk. Path is
+// relative to root, slash separated.
+type FilePredicate func(root string, path string, f os.FileInfo) bool
+
+// Invalid search expression, pointing at the offending token
+type SearchSyntaxError struct {
+	Token    string `json:"token"`
+	Position int    `json:"position"`
+	Reason   string `json:"error"`
+}
+
+func (e *SearchSyntaxError) Error() string {
+	return fmt.Sprintf("%s at '%s' (position %d)", e.Reason, e.Token, e.Position)
+}
+
+// Filters parsers, by name. They add their criteria to the query, or return the reason why value is invalid.
+var searchFilters = map[string]func(q *searchQuery, value string, now time.Time) error{
+	"type":     parseTypeFilter,
+	"ext":      parseExtFilter,
+	"year":     parseYearFilter,
+	"size":     parseSizeFilter,
+	"root":     parseRootFilter,
+	"modified": parseModifiedFilter,
+}
+
+// Media types, as prefix of their MIME type, and directories
+var searchTypes = []string{"video", "audio", "image", "dir"}
+
+// Parse a search expression. Words are matched as for a simple pattern, phrases must be found as consecutive words.
+func parseSearchQuery(expression string) (*searchQuery, error) {
+	q := &searchQuery{}
+	now := time.Now()
+
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &SearchSyntaxError{Token: string(runes[start:]), Position: start, Reason: "unterminated quote"}
+			}
+
+			if phrase := tokenize(string(runes[start+1 : end])); len(phrase) > 0 {
+				q.tokens = append(q.tokens, phrase...)
+				q.phrases = append(q.phrases, phrase)
+			}
+			i = end + 1
+			continue
+		}
+
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		term := string(runes[start:i])
+		if err := q.parseTerm(term, now); err != nil {
+			return nil, &SearchSyntaxError{Token: term, Position: start, Reason: err.Error()}
+		}
+	}
+
+	return q, nil
+}
+
+// Add a word or a filter (name:value) to the query
+func (q *searchQuery) parseTerm(term string, now time.Time) error {
+	colon := strings.Index(term, ":")
+	if colon <= 0 || strings.IndexFunc(term[:colon], func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+		q.addText(term)
+		return nil
+	}
+
+	name, value := strings.ToLower(term[:colon]), term[colon+1:]
+	parse, ok := searchFilters[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown filter '%s'", name)
+	case value == "":
+		return fmt.Errorf("missing value of filter '%s'", name)
+	}
+	return parse(q, value, now)
+}
+
+func (q *searchQuery) addText(text string) {
+	if tokens := tokenize(text); len(tokens) > 0 {
+		q.tokens = append(q.tokens, tokens...)
+	} else {
+		// only separators: look for them as is
+		q.tokens = append(q.tokens, foldText(text))
+	}
+}
+
+// Query has criteria, other than less than minLength letters
+func (q *searchQuery) isSelective(minLength int) bool {
+	return len(q.filters) > 0 || len(q.roots) > 0 || len([]rune(strings.Join(q.tokens, ""))) >= minLength
+}
+
+// Remove roots excluded by root filter
+func (q *searchQuery) filterRoots(roots map[string]string) map[string]string {
+	if len(q.roots) == 0 {
+		return roots
+	}
+
+	filtered := make(map[string]string)
+	for _, name := range q.roots {
+		if path, ok := roots[name]; ok {
+			filtered[name] = path
+		}
+	}
+	return filtered
+}
+
+// Test a file found while walking: filters first since they only read stats, then name (or path)
+func (q *searchQuery) accept(root string, path string, f os.FileInfo) bool {
+	for _, filter := range q.filters {
+		if !filter(root, path, f) {
+			return false
+		}
+	}
+	return q.matches(q.matchedText(path))
+}
+
+// Relevance of a found file, from its path relative to root
+func (q *searchQuery) scorePath(path string) float64 {
+	return q.score(q.matchedText(path))
+}
+
+// Text on which words are searched: name, or relative path when searching in paths
+func (q *searchQuery) matchedText(path string) string {
+	if q.paths {
+		return path
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func parseTypeFilter(q *searchQuery, value string, _ time.Time) error {
+	kinds := strings.Split(strings.ToLower(value), ",")
+	for _, kind := range kinds {
+		if !containsString(searchTypes, kind) {
+			return fmt.Errorf("unknown type '%s', expected one of %s", kind, strings.Join(searchTypes, ", "))
+		}
+	}
+
+	q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+		if f.IsDir() {
+			return containsString(kinds, "dir")
+		}
+		path := Path{Name: f.Name()}
+		mime := mediaMimeType(path.Ext())
+		return mime != "" && containsString(kinds, mime[:strings.Index(mime, "/")])
+	})
+	return nil
+}
+
+func parseExtFilter(q *searchQuery, value string, _ time.Time) error {
+	var extensions []string
+	for _, ext := range strings.Split(strings.ToLower(value), ",") {
+		if ext = strings.TrimPrefix(ext, "."); ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	if len(extensions) == 0 {
+		return fmt.Errorf("missing extension")
+	}
+
+	q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+		path := Path{Name: f.Name()}
+		return !f.IsDir() && containsString(extensions, path.Ext())
+	})
+	return nil
+}
+
+func parseRootFilter(q *searchQuery, value string, _ time.Time) error {
+	for _, root := range strings.Split(value, ",") {
+		if root != "" {
+			q.roots = append(q.roots, root)
+		}
+	}
+	if len(q.roots) == 0 {
+		return fmt.Errorf("missing root name")
+	}
+	return nil
+}
+
+// Year is read from name: the last one between 1900 and 2099, as in "Blade Runner (1982).mkv"
+var nameYearPattern = regexp.MustCompile(`(^|[^0-9])((19|20)[0-9]{2})([^0-9]|$)`)
+
+func parseYearFilter(q *searchQuery, value string, _ time.Time) error {
+	op, number := splitComparison(value)
+	year, err := strconv.Atoi(number)
+	if err != nil || len(number) != 4 {
+		return fmt.Errorf("year must have 4 digits")
+	}
+
+	q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+		found, ok := nameYear(f.Name())
+		return ok && compare(op, float64(found), float64(year))
+	})
+	return nil
+}
+
+func nameYear(name string) (int, bool) {
+	matches := nameYearPattern.FindAllStringSubmatch(name, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	year, _ := strconv.Atoi(matches[len(matches)-1][2])
+	return year, true
+}
+
+func parseSizeFilter(q *searchQuery, value string, _ time.Time) error {
+	op, text := splitComparison(value)
+	size, err := parseSize(text)
+	if err != nil {
+		return err
+	}
+
+	q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+		return !f.IsDir() && compare(op, float64(f.Size()), size)
+	})
+	return nil
+}
+
+// Size units, powers of 1024
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// Parse a size like 700MB or 1.5G
+func parseSize(text string) (float64, error) {
+	lower := strings.ToLower(text)
+	end := strings.IndexFunc(lower, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if end < 0 {
+		end = len(lower)
+	}
+
+	number, err := strconv.ParseFloat(lower[:end], 64)
+	unit, ok := sizeUnits[lower[end:]]
+	if err != nil || !ok {
+		return 0, fmt.Errorf("invalid size '%s', expected a number with unit B, KB, MB, GB or TB", text)
+	}
+	return number * unit, nil
+}
+
+var ageUnits = map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+
+// Modification is compared either to an age (modified:<30d, or modified:30d, are files modified in last 30 days,
+// units h, d, w, y), or to a date (modified:>2020-01-31)
+func parseModifiedFilter(q *searchQuery, value string, now time.Time) error {
+	op, text := splitComparison(value)
+
+	if date, err := time.ParseInLocation("2006-01-02", text, time.Local); err == nil {
+		day := dayNumber(date)
+		q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+			return compare(op, dayNumber(f.ModTime()), day)
+		})
+		return nil
+	}
+
+	if len(text) >= 2 {
+		unit, ok := ageUnits[text[len(text)-1]]
+		if count, err := strconv.Atoi(text[:len(text)-1]); ok && err == nil {
+			age := time.Duration(count) * unit
+			if op == "=" {
+				op = "<="
+			}
+			q.filters = append(q.filters, func(_ string, _ string, f os.FileInfo) bool {
+				return compare(op, float64(now.Sub(f.ModTime())), float64(age))
+			})
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid modification '%s', expected an age (30d, 12h, 2w, 1y) or a date (2020-01-31)", text)
+}
+
+// Comparable number of a day (20200131), in local time
+func dayNumber(t time.Time) float64 {
+	year, month, day := t.Date()
+	return float64(year*10000 + int(month)*100 + day)
+}
+
+// Split comparison operator (>, >=, <, <=, =) from compared value. Operator is '=' when omitted.
+func splitComparison(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+func compare(op string, value float64, reference float64) bool {
+	switch op {
+	case ">":
+		return value > reference
+	case ">=":
+		return value >= reference
+	case "<":
+		return value < reference
+	case "<=":
+		return value <= reference
+	default:
+		return value == reference
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// File stats, as given by the walk
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (f fakeFileInfo) Name() string       { return f.name }
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (f fakeFileInfo) ModTime() time.Time { return f.modTime }
+func (f fakeFileInfo) IsDir() bool        { return f.dir }
+func (f fakeFileInfo) Sys() interface{}   { return nil }
+
+func Test_parseSearchQuery(t *testing.T) {
+	recent := time.Now().Add(-48 * time.Hour)
+	old := time.Date(2015, 6, 1, 12, 0, 0, 0, time.Local)
+
+	starWars := fakeFileInfo{name: "Star.Wars.Episode.IV.(1977).mkv", size: 3 << 30, modTime: recent}
+	avatar := fakeFileInfo{name: "Avatar (2009).mp4", size: 1 << 30, modTime: old}
+	song := fakeFileInfo{name: "Star Wars Theme.mp3", size: 5 << 20, modTime: old}
+
+	tests := []struct {
+		expression string
+		file       fakeFileInfo
+		want       bool
+	}{
+		{`"star wars"`, starWars, true},
+		{`"wars star"`, starWars, false},
+		{`wars star`, starWars, true},
+		{`type:video "star wars"`, starWars, true},
+		{`type:video "star wars"`, song, false},
+		{`type:audio,video star`, song, true},
+		{`ext:mkv`, starWars, true},
+		{`ext:.MP4,avi`, starWars, false},
+		{`year:>2000`, avatar, true},
+		{`year:>2000`, starWars, false},
+		{`year:1977`, starWars, true},
+		{`year:<=2000`, song, false},
+		{`size:>2GB`, starWars, true},
+		{`size:>2GB`, avatar, false},
+		{`size:<=1g`, avatar, true},
+		{`size:<10MiB`, song, true},
+		{`modified:<30d`, starWars, true},
+		{`modified:30d`, avatar, false},
+		{`modified:>1y`, avatar, true},
+		{`modified:2015-06-01`, avatar, true},
+		{`modified:>2015-06-01`, avatar, false},
+		{`star 10:30`, starWars, false},
+	}
+	for _, tt := range tests {
+		q, err := parseSearchQuery(tt.expression)
+		if assert.NoError(t, err, tt.expression) {
+			assert.Equal(t, tt.want, q.accept("data", "films/"+tt.file.name, tt.file), "'%s' on '%s'", tt.expression, tt.file.name)
+		}
+	}
+}
+
+func Test_parseSearchQuery_errors(t *testing.T) {
+	tests := []struct {
+		expression string
+		token      string
+		position   int
+	}{
+		{`type:video "star wars`, `"star wars`, 11},
+		{`star genre:scifi`, "genre:scifi", 5},
+		{`type:movie`, "type:movie", 0},
+		{`year:>20`, "year:>20", 0},
+		{`size:>2XB`, "size:>2XB", 0},
+		{`size:`, "size:", 0},
+		{`modified:<30x`, "modified:<30x", 0},
+		{`été ext:mkv root:`, "root:", 12},
+	}
+	for _, tt := range tests {
+		_, err := parseSearchQuery(tt.expression)
+		if syntax, ok := err.(*SearchSyntaxError); assert.True(t, ok, "%s should be invalid", tt.expression) {
+			assert.Equal(t, tt.token, syntax.Token)
+			assert.Equal(t, tt.position, syntax.Position)
+			assert.NotEmpty(t, syntax.Reason)
+		}
+	}
+}
+
+func Test_searchQuery_filterRoots(t *testing.T) {
+	roots := map[string]string{"data": "/mnt/data", "films": "/mnt/films"}
+
+	q, _ := parseSearchQuery("root:data,missing star")
+	assert.Equal(t, map[string]string{"data": "/mnt/data"}, q.filterRoots(roots))
+
+	q, _ = parseSearchQuery("star")
+	assert.Equal(t, roots, q.filterRoots(roots))
+}
+
+func Test_searchQuery_isSelective(t *testing.T) {
+	q, _ := parseSearchQuery("ab")
+	assert.False(t, q.isSelective(3))
+
+	q, _ = parseSearchQuery("type:video")
+	assert.True(t, q.isSelective(3), "filters are enough")
+
+	q, _ = parseSearchQuery(`"star wars"`)
+	assert.True(t, q.isSelective(3))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Stream formats of search results
+const (
+	streamNdjson = "ndjson"
+	streamSse    = "sse"
+)
+
+// Write search results one by one, as soon as they are found: one JSON object per line (NDJSON), or one 'result'
+// event per media followed by an 'end' event (Server-Sent Events)
+type resultStream struct {
+	writer  http.ResponseWriter
+	flusher http.Flusher
+	format  string
+	count   int
+}
+
+// Stream requested with 'stream' parameter or Accept header, nil when results must be returned as a JSON array
+func newResultStream(w http.ResponseWriter, r *http.Request) *resultStream {
+	format := r.URL.Query().Get("stream")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "application/x-ndjson"):
+			format = streamNdjson
+		case strings.Contains(accept, "text/event-stream"):
+			format = streamSse
+		}
+	}
+
+	stream := &resultStream{writer: w, format: format}
+	switch format {
+	case streamNdjson:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case streamSse:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		return nil
+	}
+
+	stream.flusher, _ = w.(http.Flusher)
+	w.WriteHeader(200)
+	return stream
+}
+
+func (s *resultStream) send(dto FileDto) {
+	data, err := json.Marshal(dto)
+	if err != nil {
+		glog.Warning("Can't marshal search result ", dto.PathId, ": ", err)
+		return
+	}
+
+	s.count++
+	if s.format == streamSse {
+		fmt.Fprintf(s.writer, "event: result\ndata: %s\n\n", data)
+	} else {
+		fmt.Fprintf(s.writer, "%s\n", data)
+	}
+	s.flush()
+}
+
+// Signal end of results, only for SSE since NDJSON ends with the response
+func (s *resultStream) end() {
+	if s.format == streamSse {
+		fmt.Fprintf(s.writer, "event: end\ndata: {\"count\":%d}\n\n", s.count)
+		s.flush()
+	}
+}
+
+func (s *resultStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Time given to running requests to complete before connections are closed (streams can last hours)
+	httpShutdownTimeout = 10 * time.Second
+	// Time given to dispatchers to complete the command they're executing
+	dispatcherShutdownTimeout = 5 * time.Second
+)
+
+type shutdownHook struct {
+	name string
+	hook func()
+}
+
+var (
+	shutdownLock  sync.Mutex
+	shutdownHooks []shutdownHook
+)
+
+// Register a function called on graceful shutdown, once HTTP servers are stopped.
+// Hooks are called in reverse order of registration: controllers registered first are stopped last.
+func onShutdown(name string, hook func()) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+
+	shutdownHooks = append(shutdownHooks, shutdownHook{name, hook})
+}
+
+func runShutdownHooks() {
+	shutdownLock.Lock()
+	hooks := shutdownHooks
+	shutdownHooks = nil
+	shutdownLock.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		glog.Info("Stopping ", hooks[i].name, "...")
+		hooks[i].hook()
+	}
+}
+
+// Serve HTTP until SIGINT or SIGTERM is received, then stop gracefully: drain HTTP connections and run shutdown hooks.
+// Each server is given with its serving function (Serve or ServeTLS). Systemd is notified once all addresses are bound.
+func serveUntilSignal(servers []*http.Server, serve []func(net.Listener) error) {
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		listener, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			glog.Error("Can't listen on ", srv.Addr, ": ", err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			runShutdownHooks()
+			glog.Flush()
+			return
+		}
+		listeners = append(listeners, listener)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	failures := make(chan error, len(servers))
+	for i, s := range serve {
+		go func(s func(net.Listener) error, listener net.Listener) {
+			if err := s(listener); err != http.ErrServerClosed {
+				failures <- err
+			}
+		}(s, listeners[i])
+	}
+
+	sdNotify("READY=1")
+	stopWatchdog := make(chan bool)
+	go sdWatchdog(stopWatchdog)
+
+	select {
+	case s := <-signals:
+		glog.Info("Received ", s, ", shutting down...")
+	case err := <-failures:
+		glog.Error("Server failed: ", err)
+	}
+	sdNotify("STOPPING=1")
+	close(stopWatchdog)
+
+	shutdown(servers)
+	glog.Info("Medima PI stopped.")
+	glog.Flush()
+}
+
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			glog.Warning("Closing remaining connections of ", srv.Addr, ": ", err)
+			srv.Close()
+		}
+	}
+
+	runShutdownHooks()
+}
+
+// Send a state to systemd (sd_notify protocol), ignored when not started by systemd with Type=notify
+func sdNotify(state string) bool {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false
+	}
+	if socket[0] == '@' {
+		// abstract namespace
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		glog.Warning("Can't notify systemd: ", err)
+		return false
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err == nil
+}
+
+// Period at which systemd expects watchdog pings, 0 when watchdog is disabled
+func sdWatchdogPeriod() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Ping systemd watchdog twice per period while server is alive, until stopped
+func sdWatchdog(stop chan bool) {
+	period := sdWatchdogPeriod()
+	if period == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(period / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if isAlive() {
+				sdNotify("WATCHDOG=1")
+			} else {
+				glog.Error("Server isn't alive anymore, watchdog isn't notified")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
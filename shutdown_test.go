@@ -0,0 +1,77 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_runShutdownHooks(t *testing.T) {
+	var calls []string
+	onShutdown("first", func() { calls = append(calls, "first") })
+	onShutdown("second", func() { calls = append(calls, "second") })
+
+	runShutdownHooks()
+	runShutdownHooks()
+	assert.Equal(t, []string{"second", "first"}, calls)
+}
+
+func Test_sdNotify(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-notify")
+	defer os.RemoveAll(dir)
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.False(t, sdNotify("READY=1"), "not started by systemd")
+
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	assert.True(t, sdNotify("READY=1"))
+	buffer := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ := conn.Read(buffer)
+	assert.Equal(t, "READY=1", string(buffer[:n]))
+
+	os.Setenv("WATCHDOG_USEC", "20000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(t, 20*time.Second, sdWatchdogPeriod())
+	os.Setenv("WATCHDOG_PID", "1")
+	defer os.Unsetenv("WATCHDOG_PID")
+	assert.Equal(t, time.Duration(0), sdWatchdogPeriod(), "watchdog is for another process")
+}
+
+func TestPlayerDispatcher_Shutdown(t *testing.T) {
+	player := new(MockPlayer)
+	player.On("Accept", mock.Anything).Return(true)
+	player.On("GetStatus").Return(NewPlayerStatus(NewMedia(Path{"", "data", "", "movie.mp4"}), false, NewTimePosition(0, 0, 0, true), NewTimePosition(0, 0, 0, true)))
+	player.On("Execute", mock.Anything).Return(nil)
+
+	d := NewPlayerDispatcher(player)
+	go d.StartDispatching()
+	play := NewPlayerCommand("play", NewMedia(Path{"", "data", "", "movie.mp4"}))
+	d.Dispatch(play)
+	assert.Equal(t, CommandSucceeded, play.Wait(time.Second).State)
+
+	d.Shutdown(time.Second)
+
+	assert.Equal(t, ErrDispatcherClosed, d.Dispatch(NewPlayerCommand("pause")))
+	calls := player.Calls
+	last := calls[len(calls)-1]
+	assert.Equal(t, "Execute", last.Method)
+	assert.Equal(t, "stop", last.Arguments.Get(0).(PlayerCommand).Operation)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// How often the status is checked to detect the end of current media
+const sleepTimerPoll = 5 * time.Second
+
+// Stop a target after a duration, or when current media ends
+type SleepTimer struct {
+	dispatcher *PlayerDispatcher
+	poll       time.Duration
+
+	lock       sync.Mutex
+	deadline   *time.Time
+	endOfMedia bool
+	// Media playing when the timer has been armed on its end
+	media  string
+	cancel chan bool
+}
+
+func NewSleepTimer(dispatcher *PlayerDispatcher) *SleepTimer {
+	return &SleepTimer{dispatcher: dispatcher, poll: sleepTimerPoll}
+}
+
+// Sleep timer DTO, built for REST API
+type SleepTimerDto struct {
+	Target     string     `json:"target"`
+	Active     bool       `json:"active"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	EndOfMedia bool       `json:"endOfMedia"`
+	Media      string     `json:"media,omitempty"`
+}
+
+// Stop playing after duration. Replace any previous timer.
+func (t *SleepTimer) StopAfter(duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.reset()
+	t.cancel = make(chan bool)
+	deadline := time.Now().Add(duration)
+	t.deadline = &deadline
+	glog.Info("Target ", t.dispatcher.name, " will stop at ", deadline.Format(time.Kitchen))
+
+	cancel := t.cancel
+	go func() {
+		select {
+		case <-time.After(duration):
+			t.fire(cancel)
+		case <-cancel:
+		}
+	}()
+}
+
+// Stop playing when the current media is finished. Replace any previous timer.
+func (t *SleepTimer) StopAtEndOfMedia() error {
+	status := t.dispatcher.PlayerStatus()
+	if !status.Playing || status.Media == nil {
+		return &ConflictError{"nothing is playing on " + t.dispatcher.name}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.reset()
+	t.cancel = make(chan bool)
+	t.endOfMedia = true
+	t.media = status.Media.PathId
+	glog.Info("Target ", t.dispatcher.name, " will stop after ", t.media)
+
+	cancel, media := t.cancel, t.media
+	go func() {
+		ticker := time.NewTicker(t.poll)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				status := t.dispatcher.PlayerStatus()
+				if !status.Playing {
+					// media ended by itself, nothing to stop
+					t.disarm(cancel)
+					return
+				}
+				if status.Media == nil || status.Media.PathId != media {
+					t.fire(cancel)
+					return
+				}
+			case <-cancel:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Cancel current timer, if any
+func (t *SleepTimer) Cancel() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.reset()
+}
+
+func (t *SleepTimer) Status() SleepTimerDto {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return SleepTimerDto{
+		Target:     t.dispatcher.name,
+		Active:     t.cancel != nil,
+		Deadline:   t.deadline,
+		EndOfMedia: t.endOfMedia,
+		Media:      t.media,
+	}
+}
+
+// Stop the target, unless the timer has been cancelled or replaced meanwhile
+func (t *SleepTimer) fire(cancel chan bool) {
+	if !t.disarm(cancel) {
+		return
+	}
+
+	glog.Info("Sleep timer is over, stopping ", t.dispatcher.name)
+	if err := t.dispatcher.Dispatch(NewPlayerCommand("stop")); err != nil {
+		glog.Error("Sleep timer can't stop ", t.dispatcher.name, ": ", err)
+	}
+}
+
+// Forget timer if it's still the current one
+func (t *SleepTimer) disarm(cancel chan bool) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.cancel != cancel {
+		return false
+	}
+
+	t.cancel = nil
+	t.deadline = nil
+	t.endOfMedia = false
+	t.media = ""
+	return true
+}
+
+// Cancel running timer. Lock must be held.
+func (t *SleepTimer) reset() {
+	if t.cancel != nil {
+		close(t.cancel)
+	}
+
+	t.cancel = nil
+	t.deadline = nil
+	t.endOfMedia = false
+	t.media = ""
+}
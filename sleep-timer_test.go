@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSleepTimer(t *testing.T) {
+	playing := func(name string) PlayerStatus {
+		return NewPlayerStatus(NewMedia(Path{"", "data", "", name}), false, NewTimePosition(0, 0, 0, true), NewTimePosition(0, 0, 0, true))
+	}
+
+	t.Run("it should stop after duration", func(t *testing.T) {
+		d := NewPlayerDispatcher()
+		timer := NewSleepTimer(d)
+
+		timer.StopAfter(50 * time.Millisecond)
+		assert.True(t, timer.Status().Active)
+		assert.NotNil(t, timer.Status().Deadline)
+
+		select {
+		case command := <-d.commands:
+			assert.Equal(t, "stop", command.Operation)
+		case <-time.After(time.Second):
+			t.Error("stop command should have been dispatched")
+		}
+		assert.False(t, timer.Status().Active)
+	})
+
+	t.Run("it should not stop once cancelled", func(t *testing.T) {
+		d := NewPlayerDispatcher()
+		timer := NewSleepTimer(d)
+
+		timer.StopAfter(50 * time.Millisecond)
+		timer.Cancel()
+		assert.False(t, timer.Status().Active)
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Len(t, d.commands, 0)
+	})
+
+	t.Run("it should stop when next media starts", func(t *testing.T) {
+		player := new(MockPlayer)
+		player.On("GetStatus").Return(playing("first.mp3")).Once()
+		player.On("GetStatus").Return(playing("first.mp3")).Once()
+		player.On("GetStatus").Return(playing("second.mp3"))
+
+		d := NewPlayerDispatcher(player)
+		d.setCurrentPlayer(player)
+		timer := NewSleepTimer(d)
+		timer.poll = 10 * time.Millisecond
+
+		assert.NoError(t, timer.StopAtEndOfMedia())
+		assert.True(t, timer.Status().EndOfMedia)
+		assert.Equal(t, "data/first.mp3", timer.Status().Media)
+
+		select {
+		case command := <-d.commands:
+			assert.Equal(t, "stop", command.Operation)
+		case <-time.After(time.Second):
+			t.Error("stop command should have been dispatched")
+		}
+	})
+
+	t.Run("it should not arm end of media when nothing is playing", func(t *testing.T) {
+		player := new(MockPlayer)
+		player.On("GetStatus").Return(NotPlayingStatus())
+		player.On("Accept", mock.Anything).Return(true)
+
+		d := NewPlayerDispatcher(player)
+		d.setCurrentPlayer(player)
+		timer := NewSleepTimer(d)
+
+		assert.Error(t, timer.StopAtEndOfMedia())
+		assert.False(t, timer.Status().Active)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+const STREAM_PREFIX = "/api/stream"
+
+// Serve raw media content, used by DLNA renderers and any HTTP client able to play a URL
+func StreamController(r *mux.Router) error {
+	glog.V(1).Infoln("Registering Stream Controller")
+
+	r.Methods("GET", "HEAD").PathPrefix(STREAM_PREFIX).HandlerFunc(StreamMedia)
+
+	return nil
+}
+
+// Stream a media file, supporting HTTP range requests (seeking)
+func StreamMedia(w http.ResponseWriter, r *http.Request) {
+	path, err := NewPathFromId(strings.Trim(strings.TrimPrefix(r.URL.Path, STREAM_PREFIX), "/"))
+	if err == nil {
+		err = checkRootAccess(r, path)
+	}
+	if err == nil {
+		err = path.checkContainment()
+	}
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	if path.IsIndex() {
+		failureResponse(r, &InvalidIdError{"a media must be specified"}, w)
+		return
+	}
+
+	// signed URLs are fetched without credentials, for the viewer they have been signed for
+	viewer := requestViewer(r)
+	if currentPrincipal(r) == nil && authenticator != nil {
+		viewer = streamViewer(r)
+	}
+
+	file, err := os.Open(path.localPath)
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+	if stat.IsDir() {
+		failureResponse(r, &UnsupportedError{path.PathId() + " is a directory and can't be streamed"}, w)
+		return
+	}
+	if err := parental.CheckStream(viewer, NewMedia(path)); err != nil {
+		failureResponse(r, err, w)
+		return
+	}
+
+	if mime := mediaMimeType(path.Ext()); mime != "" {
+		w.Header().Set("Content-Type", mime)
+	}
+	// DLNA renderers refuse to play content without those headers
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", dlnaContentFeatures)
+
+	glog.V(1).Infoln("Streaming ", path.localPath, " to ", r.RemoteAddr)
+	http.ServeContent(w, r, path.Name, stat.ModTime(), file)
+}
+
+// URL to stream a media to a viewer, signed when authentication is enabled: renderers can't authenticate
+func streamUrl(baseUrl string, path Path, viewer parentalViewer) string {
+	streamUrl := baseUrl + STREAM_PREFIX + "/" + escapePathId(path.PathId())
+	if authenticator == nil {
+		return streamUrl
+	}
+
+	query := url.Values{}
+	if viewer.User != "" {
+		query.Set("user", viewer.User)
+	}
+	if viewer.Profile != "" {
+		query.Set("profile", viewer.Profile)
+	}
+	query.Set("sig", authenticator.streamSignature(path.PathId(), viewer))
+	return streamUrl + "?" + query.Encode()
+}
+
+// Viewer a stream URL has been signed for
+func streamViewer(r *http.Request) parentalViewer {
+	query := r.URL.Query()
+	return parentalViewer{User: query.Get("user"), Profile: query.Get("profile")}
+}
+
+// MIME types of known media, by lower case extension
+var mediaMimeTypes = map[string]string{
+	"avi":  "video/x-msvideo",
+	"flv":  "video/x-flv",
+	"m4v":  "video/x-m4v",
+	"mkv":  "video/x-matroska",
+	"mov":  "video/quicktime",
+	"mp4":  "video/mp4",
+	"mpeg": "video/mpeg",
+	"mpg":  "video/mpeg",
+	"ts":   "video/mp2t",
+	"webm": "video/webm",
+	"wmv":  "video/x-ms-wmv",
+
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"m4a":  "audio/mp4",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"opus": "audio/opus",
+	"wav":  "audio/wav",
+	"wma":  "audio/x-ms-wma",
+
+	"bmp":  "image/bmp",
+	"gif":  "image/gif",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// Get MIME type from extension (as returned by Path.Ext), empty string when it's not a known media
+func mediaMimeType(ext string) string {
+	return mediaMimeTypes[strings.ToLower(ext)]
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Policies applied to symbolic links found in roots
+const (
+	// Follow links which target a file or a directory of the same root
+	SymlinksInside = "inside"
+	// Follow all links, wherever they point
+	SymlinksAll = "all"
+	// Never follow links: they are neither listed nor served
+	SymlinksNever = "never"
+)
+
+var symlinkPolicy = SymlinksInside
+
+func isSymlinkPolicy(policy string) bool {
+	return policy == SymlinksInside || policy == SymlinksAll || policy == SymlinksNever
+}
+
+// Error when a path segment could be used to escape the root (.., empty, NUL, platform separator)
+func checkPathSegments(path string, name string) error {
+	if strings.Contains(name, "/") {
+		return &InvalidIdError{fmt.Sprintf("invalid name '%s'", name)}
+	}
+
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+	if name != "" {
+		segments = append(segments, name)
+	}
+
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." || strings.ContainsRune(s, 0) ||
+			(filepath.Separator != '/' && strings.ContainsRune(s, filepath.Separator)) {
+			return &InvalidIdError{fmt.Sprintf("invalid path segment '%s'", s)}
+		}
+	}
+	return nil
+}
+
+// Test if path is the directory or is in it, both being cleaned absolute or relative paths
+func isWithin(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// Path of root with its symbolic links resolved: root itself may be a link (to a mount point)
+func realRootPath(root string) (string, error) {
+	return filepath.EvalSymlinks(roots[root].localPath)
+}
+
+// Error when path is outside of its root, or goes through a symbolic link not allowed by policy
+func (path *Path) checkContainment() error {
+	if path.IsIndex() {
+		return nil
+	}
+
+	rootPath := filepath.Clean(roots[path.Root].localPath)
+	if !isWithin(filepath.Clean(path.localPath), rootPath) {
+		return &ForbiddenError{fmt.Sprintf("'%s' is outside of root '%s'", path.PathId(), path.Root)}
+	}
+	if symlinkPolicy == SymlinksAll {
+		return nil
+	}
+
+	realRoot, err := realRootPath(path.Root)
+	if err != nil {
+		return err
+	}
+	real, err := filepath.EvalSymlinks(path.localPath)
+	if err != nil {
+		return err
+	}
+
+	relative := strings.TrimPrefix(filepath.Clean(path.localPath), rootPath)
+	if real == filepath.Join(realRoot, relative) {
+		// no link on the way
+		return nil
+	}
+	if symlinkPolicy == SymlinksNever {
+		return &ForbiddenError{fmt.Sprintf("'%s' goes through a symbolic link", path.PathId())}
+	}
+	if !isWithin(real, realRoot) {
+		return &ForbiddenError{fmt.Sprintf("'%s' links outside of root '%s'", path.PathId(), path.Root)}
+	}
+	return nil
+}
+
+// Stats of a link target, error when policy doesn't allow to follow it
+func followSymlink(local string, realRoot string) (os.FileInfo, error) {
+	if symlinkPolicy == SymlinksNever {
+		return nil, &ForbiddenError{fmt.Sprintf("symbolic link '%s' isn't followed", local)}
+	}
+
+	real, err := filepath.EvalSymlinks(local)
+	if err != nil {
+		return nil, err
+	}
+	if symlinkPolicy == SymlinksInside && !isWithin(real, realRoot) {
+		return nil, &ForbiddenError{fmt.Sprintf("symbolic link '%s' points outside of root", local)}
+	}
+	return os.Stat(real)
+}
+
+// Stats of directory entries: links are replaced by their target, and skipped when they can't be followed
+func readDirFollowing(dir string, realRoot string) ([]os.FileInfo, []string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		info := entry
+		if entry.Mode()&os.ModeSymlink != 0 {
+			if info, err = followSymlink(filepath.Join(dir, entry.Name()), realRoot); err != nil {
+				glog.V(1).Infoln("Skip ", filepath.Join(dir, entry.Name()), ": ", err)
+				continue
+			}
+		}
+		infos = append(infos, info)
+		names = append(names, entry.Name())
+	}
+	return infos, names, nil
+}
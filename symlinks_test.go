@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Root 'films' with links to a file and a directory of the root, to a secret outside of it, and a loop
+func symlinkFixture(t *testing.T) (string, func()) {
+	dir, clean := rootFixture(t, "films", "Cars/Cars.mkv", "../secret/passwd.mkv")
+	base := filepath.Dir(dir)
+
+	os.Symlink(filepath.Join(dir, "Cars", "Cars.mkv"), filepath.Join(dir, "Favorite.mkv"))
+	os.Symlink(filepath.Join(dir, "Cars"), filepath.Join(dir, "Pixar"))
+	os.Symlink(filepath.Join(base, "secret", "passwd.mkv"), filepath.Join(dir, "Escape.mkv"))
+	os.Symlink(filepath.Join(base, "secret"), filepath.Join(dir, "Secret"))
+	os.Symlink(dir, filepath.Join(dir, "Cars", "Loop"))
+	return dir, clean
+}
+
+func TestNewPathFromId_traversal(t *testing.T) {
+	_, clean := symlinkFixture(t)
+	defer clean()
+
+	for _, pathId := range []string{
+		"films/../../etc/passwd",
+		"films/..",
+		"films/Cars/../../secret/passwd.mkv",
+		"films/./Cars",
+		"films//Cars",
+		"films/Cars\x00.mkv",
+	} {
+		_, err := NewPathFromId(pathId)
+		if assert.Error(t, err, pathId) {
+			assert.IsType(t, &InvalidIdError{}, err, pathId)
+		}
+	}
+
+	_, err := NewPath("films", "", "../secret")
+	assert.Error(t, err)
+
+	path, err := NewPathFromId("films/Cars/Cars.mkv")
+	assert.NoError(t, err)
+	assert.NoError(t, path.checkContainment())
+}
+
+func TestPath_checkContainment(t *testing.T) {
+	_, clean := symlinkFixture(t)
+	defer clean()
+
+	allowed := func(pathId string) bool {
+		path, err := NewPathFromId(pathId)
+		if assert.NoError(t, err, pathId) {
+			err = path.checkContainment()
+		}
+		return err == nil
+	}
+
+	symlinkPolicy = SymlinksInside
+	assert.True(t, allowed("films/Favorite.mkv"))
+	assert.True(t, allowed("films/Pixar/Cars.mkv"))
+	assert.False(t, allowed("films/Escape.mkv"))
+	assert.False(t, allowed("films/Secret/passwd.mkv"))
+
+	symlinkPolicy = SymlinksNever
+	assert.True(t, allowed("films/Cars/Cars.mkv"))
+	assert.False(t, allowed("films/Favorite.mkv"))
+	assert.False(t, allowed("films/Pixar/Cars.mkv"))
+
+	symlinkPolicy = SymlinksAll
+	assert.True(t, allowed("films/Escape.mkv"))
+	assert.True(t, allowed("films/Secret/passwd.mkv"))
+
+	// an escaping file can't be loaded either
+	symlinkPolicy = SymlinksInside
+	path, _ := NewPathFromId("films/Escape.mkv")
+	_, err := path.ToFile(true)
+	assert.IsType(t, &ForbiddenError{}, err)
+}
+
+func TestDir_children_symlinks(t *testing.T) {
+	_, clean := symlinkFixture(t)
+	defer clean()
+
+	children := func(policy string) []string {
+		symlinkPolicy = policy
+		path, _ := NewPathFromId("films")
+		file, err := path.ToFile(false)
+		assert.NoError(t, err)
+
+		var names []string
+		for _, c := range file.(*Dir).Children {
+			names = append(names, c.Path().Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	assert.Equal(t, []string{"Cars", "Favorite.mkv", "Pixar"}, children(SymlinksInside))
+	assert.Equal(t, []string{"Cars"}, children(SymlinksNever))
+	assert.Equal(t, []string{"Cars", "Escape.mkv", "Favorite.mkv", "Pixar", "Secret"}, children(SymlinksAll))
+}
+
+func Test_walkRoot_symlinks(t *testing.T) {
+	dir, clean := symlinkFixture(t)
+	defer clean()
+
+	walk := func(policy string) []string {
+		symlinkPolicy = policy
+		var found []string
+		err := walkRoot("films", dir, false, func(_ string, relative string, _ os.FileInfo, _ error) error {
+			found = append(found, relative)
+			return nil
+		})
+		assert.NoError(t, err)
+		sort.Strings(found)
+		return found
+	}
+
+	// loop back to the root is walked once, linked directory too
+	inside := walk(SymlinksInside)
+	assert.Contains(t, inside, "Favorite.mkv")
+	assert.Contains(t, inside, "Cars/Loop")
+	assert.NotContains(t, inside, "Escape.mkv")
+	assert.NotContains(t, inside, "Cars/Loop/Cars")
+	assert.Len(t, inside, 6)
+
+	assert.Equal(t, []string{"", "Cars", "Cars/Cars.mkv"}, walk(SymlinksNever))
+	assert.Contains(t, walk(SymlinksAll), "Secret/passwd.mkv")
+}
+
+func TestStreamMedia_symlinks(t *testing.T) {
+	_, clean := symlinkFixture(t)
+	defer clean()
+
+	stream := func(pathId string) int {
+		w := httptest.NewRecorder()
+		StreamMedia(w, httptest.NewRequest("GET", STREAM_PREFIX+"/"+pathId, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, 200, stream("films/Favorite.mkv"))
+	assert.Equal(t, 403, stream("films/Escape.mkv"))
+	assert.Equal(t, 403, stream("films/Secret/passwd.mkv"))
+	assert.Equal(t, 400, stream("films/Cars/../../secret/passwd.mkv"))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Files, in data directory, of the generated self-signed certificate
+const (
+	selfSignedCertFile = "tls-cert.pem"
+	selfSignedKeyFile  = "tls-key.pem"
+)
+
+const (
+	// Validity of generated certificate, browsers refuse longer ones
+	selfSignedValidity = 825 * 24 * time.Hour
+	// Generated certificate is renewed when it expires sooner than that
+	selfSignedRenewal = 30 * 24 * time.Hour
+)
+
+// TLS configuration with secure defaults: TLS 1.2+, forward secrecy and AEAD ciphers only
+func serverTlsConfig(certFile string, keyFile string) (*tls.Config, error) {
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: true,
+		CurvePreferences:         []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}, nil
+}
+
+// Load given certificate, or a self-signed one from data directory, generated when missing or about to expire
+func loadCertificate(certFile string, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	certFile, keyFile = dataFile(selfSignedCertFile), dataFile(selfSignedKeyFile)
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && !expiresSoon(cert) {
+		return cert, nil
+	}
+
+	glog.Info("Generating self-signed certificate in ", certFile)
+	if err := generateSelfSigned(certFile, keyFile, certificateHosts()); err != nil {
+		return tls.Certificate{}, fmt.Errorf("can't generate self-signed certificate: %s", err.Error())
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func expiresSoon(cert tls.Certificate) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	return err != nil || time.Now().Add(selfSignedRenewal).After(leaf.NotAfter)
+}
+
+// Names and IPs this server can be reached with
+func certificateHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname, hostname+".local")
+	}
+	if addresses, err := net.InterfaceAddrs(); err == nil {
+		for _, address := range addresses {
+			if ip, ok := address.(*net.IPNet); ok {
+				hosts = append(hosts, ip.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+// Write a new ECDSA P-256 key and a self-signed certificate valid for given hosts
+func generateSelfSigned(certFile string, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Medima PI"}, CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataFile(""), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// Plain HTTP handler: DLNA and stream requests are served as is (TVs don't handle self-signed certificates),
+// others are redirected to HTTPS server on given port.
+func httpsRedirect(httpsPort int, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, DLNA_PREFIX+"/") || strings.HasPrefix(r.URL.Path, STREAM_PREFIX+"/") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(httpsPort))
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
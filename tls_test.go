@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_generateSelfSigned(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "medima-tls")
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if !assert.NoError(t, generateSelfSigned(certFile, keyFile, []string{"medima", "192.168.1.10"})) {
+		return
+	}
+
+	stat, _ := os.Stat(keyFile)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	config, err := serverTlsConfig(certFile, keyFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	leaf, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	assert.NoError(t, leaf.VerifyHostname("medima"))
+	assert.NoError(t, leaf.VerifyHostname("192.168.1.10"))
+	assert.Error(t, leaf.VerifyHostname("example.com"))
+	assert.False(t, expiresSoon(config.Certificates[0]))
+}
+
+func Test_httpsRedirect(t *testing.T) {
+	served := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(200) }
+
+	tests := []struct {
+		port     int
+		url      string
+		code     int
+		location string
+	}{
+		{443, "http://medima:80/api/browser/data?x=1", 301, "https://medima/api/browser/data?x=1"},
+		{8443, "http://medima/", 301, "https://medima:8443/"},
+		{8443, "http://medima/dlna/device.xml", 200, ""},
+		{8443, "http://medima/api/stream/data/film.mkv", 200, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			httpsRedirect(tt.port, http.HandlerFunc(served)).ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}